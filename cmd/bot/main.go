@@ -1,16 +1,29 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os/signal"
 	"project/internal/config"
 	"project/internal/db"
 	"project/internal/handlers"
+	"project/internal/jobs"
+	"project/internal/polling"
 	"project/internal/repo"
+	"project/internal/webhook"
+	"sync"
+	"syscall"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+const shutdownTimeout = 15 * time.Second // сколько ждать обработчики после SIGINT/SIGTERM, потом их запросы к БД отменяются
+
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	cfg, err := config.Load()
 	if err != nil {
 		log.Panic("Ошибка загрузки конфига", err)
@@ -21,10 +34,50 @@ func main() {
 	CategoryRepo := repo.NewCategoryRepo(db)
 	UserRepo := repo.NewUserRepo(db)
 	OrderRepo := repo.NewOrderRepo(db)
+	SubscriptionRepo := repo.NewSubscriptionRepo(db)
+	SaleRepo := repo.NewSaleRepo(db)
+	RecommendationRepo := repo.NewRecommendationRepo(db)
+	BundleRepo := repo.NewBundleRepo(db)
+	BrandRepo := repo.NewBrandRepo(db)
+	SessionRepo := repo.NewSessionRepo(db)
+	RoleRepo := repo.NewRoleRepo(db)
+	LoginAttemptRepo := repo.NewLoginAttemptRepo(db)
+	PasswordResetRepo := repo.NewPasswordResetRepo(db)
+	BanRepo := repo.NewBanRepo(db)
+	ReferralRepo := repo.NewReferralRepo(db)
+	SettingRepo := repo.NewSettingRepo(db)
+	LoyaltyRepo := repo.NewLoyaltyRepo(db)
+	AuditRepo := repo.NewAuditRepo(db)
+	ConversationRepo := repo.NewConversationRepo(db)
 	if err != nil {
 		log.Panic("Ошибка подключения к PG4", err)
 	}
 	defer db.Close()
+	//подпись токенов и хранилище сессий
+	err = handlers.InitAuth(handlers.JWTConfig{
+		Keys:          cfg.JWTKeys,
+		ActiveKey:     cfg.JWTActiveKey,
+		TokenDuration: cfg.SessionTTL,
+	}, SessionRepo)
+	if err != nil {
+		log.Panic("Ошибка настройки авторизации", err)
+	}
+	handlers.InitConversations(ConversationRepo) //шаги диалогов хранятся в БД и переживают перезапуск
+	//фоновые задачи, останавливаются по сигналу
+	var jobsWG sync.WaitGroup
+	every := func(name string, interval time.Duration, job func(ctx context.Context) error) {
+		jobsWG.Add(1)
+		go func() {
+			defer jobsWG.Done()
+			jobs.Every(ctx, name, interval, job)
+		}()
+	}
+	every("sales", time.Minute, SaleRepo.ApplySales)
+	every("recommendations", time.Hour, RecommendationRepo.Refresh)
+	every("sessions", 24*time.Hour, SessionRepo.DeleteExpired)
+	every("login_attempts", 24*time.Hour, LoginAttemptRepo.DeleteOld)
+	every("loyalty_expiry", time.Hour, LoyaltyRepo.ExpirePoints)
+	every("conversations", 24*time.Hour, ConversationRepo.DeleteExpired)
 	//создание бота
 	bot, err := tgbotapi.NewBotAPI(cfg.BotToken)
 	if err != nil {
@@ -33,5 +86,62 @@ func main() {
 	bot.Debug = false
 	log.Printf("Authorize %s", bot.Self.UserName)
 
-	handlers.HandleUpdates(bot, ProductRepo, CategoryRepo, UserRepo, OrderRepo)
+	var updates tgbotapi.UpdatesChannel
+	var server *webhook.Server
+	if cfg.UpdatesMode == config.ModeWebhook {
+		server, err = webhook.NewServer(bot, webhook.Config{
+			URL:        cfg.WebhookURL,
+			Listen:     cfg.WebhookListen,
+			Secret:     cfg.WebhookSecret,
+			CertFile:   cfg.WebhookCert,
+			KeyFile:    cfg.WebhookKey,
+			UploadCert: cfg.WebhookUploadCert,
+		})
+		if err != nil {
+			log.Panic("Ошибка настройки вебхука", err)
+		}
+		if err := server.Start(); err != nil {
+			log.Panic(err)
+		}
+		updates = server.Updates()
+	} else {
+		if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil { //с зарегистрированным вебхуком getUpdates не работает
+			log.Printf("Ошибка удаления вебхука: %v", err)
+		}
+		updates = polling.Updates(ctx, bot, 60) //канал закрывается по сигналу
+	}
+
+	//обработчики получают свой контекст: по сигналу они не прерываются, а дорабатывают до shutdownTimeout
+	handlersCtx, cancelHandlers := context.WithCancel(context.Background())
+	defer cancelHandlers()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		handlers.HandleUpdates(handlersCtx, bot, updates, ProductRepo, CategoryRepo, UserRepo, OrderRepo, SubscriptionRepo, SaleRepo,
+			RecommendationRepo, BundleRepo, BrandRepo, RoleRepo, LoginAttemptRepo, PasswordResetRepo, BanRepo,
+			ReferralRepo, SettingRepo, LoyaltyRepo, AuditRepo)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-done: //канал обновлений закрылся сам
+	}
+	stop() //повторный сигнал завершает процесс сразу
+	log.Printf("Остановка: приём обновлений прекращён, ожидание обработчиков до %s", shutdownTimeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if server != nil { //вебхук снимается, сервер дожидается принятых запросов и закрывает канал
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Ошибка остановки вебхука: %v", err)
+		}
+	}
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		log.Printf("Обработчики не завершились за %s, их запросы к БД отменены", shutdownTimeout)
+		cancelHandlers()
+	}
+	jobsWG.Wait()
+	log.Printf("Бот остановлен") //db.Close выполняется отложенно
 }
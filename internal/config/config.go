@@ -1,9 +1,13 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,8 +20,29 @@ type Config struct {
 	DBPass    string
 	DBName    string
 	DBSSLMode string
+
+	JWTKeys      map[string]string // ключи подписи токенов по kid
+	JWTActiveKey string            // kid которым подписываются новые токены
+	SessionTTL   time.Duration     // время жизни сессии без активности
+
+	UpdatesMode       string // polling или webhook
+	WebhookURL        string // публичный https адрес вебхука
+	WebhookListen     string // адрес HTTP сервера вебхука
+	WebhookSecret     string // secret_token, который Telegram присылает в заголовке
+	WebhookCert       string // сертификат и ключ TLS; пустые - бот за обратным прокси
+	WebhookKey        string
+	WebhookUploadCert bool // самоподписанный сертификат передаётся Telegram
 }
 
+const DefaultSessionTTL = 24 * time.Hour
+
+const (
+	ModePolling = "polling"
+	ModeWebhook = "webhook"
+
+	DefaultWebhookListen = ":8443"
+)
+
 func Load() (*Config, error) {
 	_, filename, _, _ := runtime.Caller(0) // корневая папка проекта
 	rootDir := filepath.Join(filepath.Dir(filename), "..", "..")
@@ -28,7 +53,7 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
-	return &Config{ //подключение бд
+	cfg := &Config{ //подключение бд
 		BotToken:  os.Getenv("BOT_TOKEN"),
 		DBHost:    os.Getenv("DB_HOST"),
 		DBPort:    os.Getenv("DB_PORT"),
@@ -36,5 +61,59 @@ func Load() (*Config, error) {
 		DBPass:    os.Getenv("DB_PASSWORD"),
 		DBName:    os.Getenv("DB_NAME"),
 		DBSSLMode: os.Getenv("DB_SSLMODE"),
-	}, nil
+		JWTKeys:   make(map[string]string),
+	}
+
+	//JWT_KEYS=kid1:secret1,kid2:secret2 - несколько ключей для ротации, JWT_SECRET - один ключ
+	for _, pair := range strings.Split(os.Getenv("JWT_KEYS"), ",") {
+		kid, secret, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found || kid == "" || secret == "" {
+			continue
+		}
+		cfg.JWTKeys[kid] = secret
+		if cfg.JWTActiveKey == "" {
+			cfg.JWTActiveKey = kid
+		}
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		cfg.JWTKeys["default"] = secret
+		if cfg.JWTActiveKey == "" {
+			cfg.JWTActiveKey = "default"
+		}
+	}
+	if kid := os.Getenv("JWT_ACTIVE_KEY"); kid != "" {
+		cfg.JWTActiveKey = kid
+	}
+
+	cfg.SessionTTL = DefaultSessionTTL
+	if ttl := os.Getenv("SESSION_TTL"); ttl != "" {
+		cfg.SessionTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	//UPDATES_MODE=webhook - приём обновлений через вебхук, по умолчанию long polling
+	cfg.UpdatesMode = strings.ToLower(os.Getenv("UPDATES_MODE"))
+	switch cfg.UpdatesMode {
+	case "":
+		cfg.UpdatesMode = ModePolling
+	case ModePolling:
+	case ModeWebhook:
+		cfg.WebhookURL = os.Getenv("WEBHOOK_URL")
+		cfg.WebhookListen = os.Getenv("WEBHOOK_LISTEN")
+		cfg.WebhookSecret = os.Getenv("WEBHOOK_SECRET")
+		cfg.WebhookCert = os.Getenv("WEBHOOK_CERT")
+		cfg.WebhookKey = os.Getenv("WEBHOOK_KEY")
+		cfg.WebhookUploadCert = os.Getenv("WEBHOOK_UPLOAD_CERT") == "true"
+		if cfg.WebhookListen == "" {
+			cfg.WebhookListen = DefaultWebhookListen
+		}
+		if cfg.WebhookURL == "" || cfg.WebhookSecret == "" {
+			return nil, errors.New("для режима webhook нужны WEBHOOK_URL и WEBHOOK_SECRET")
+		}
+	default:
+		return nil, fmt.Errorf("неизвестный UPDATES_MODE: %s", cfg.UpdatesMode)
+	}
+	return cfg, nil
 }
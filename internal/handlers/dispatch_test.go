@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func chatUpdate(updateID int, chatID int64) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message:  &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: chatID}},
+	}
+}
+
+func TestDispatchUpdatesKeepsChatOrder(t *testing.T) {
+	chats := []int64{1, 2, 9, -100500, 777} //1 и 9 попадают к одному воркеру, группа - с отрицательным ID
+	const perChat = 200
+
+	updates := make(chan tgbotapi.Update)
+	go func() {
+		id := 0
+		for i := 0; i < perChat; i++ {
+			for _, chatID := range chats {
+				id++
+				updates <- chatUpdate(id, chatID)
+			}
+		}
+		close(updates)
+	}()
+
+	var mu sync.Mutex
+	seen := make(map[int64][]int)
+	dispatchUpdates(updates, func(update tgbotapi.Update) {
+		mu.Lock()
+		defer mu.Unlock()
+		chatID := update.Message.Chat.ID
+		seen[chatID] = append(seen[chatID], update.UpdateID)
+	})
+
+	for _, chatID := range chats {
+		ids := seen[chatID]
+		if len(ids) != perChat {
+			t.Fatalf("чат %d: обработано %d обновлений, ожидалось %d", chatID, len(ids), perChat)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] <= ids[i-1] {
+				t.Fatalf("чат %d: обновление %d обработано после %d", chatID, ids[i], ids[i-1])
+			}
+		}
+	}
+}
+
+func TestDispatchUpdatesRunsChatsInParallel(t *testing.T) {
+	updates := make(chan tgbotapi.Update, 2)
+	updates <- chatUpdate(1, 1)
+	updates <- chatUpdate(2, 2)
+	close(updates)
+
+	released := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		dispatchUpdates(updates, func(update tgbotapi.Update) {
+			switch update.Message.Chat.ID {
+			case 1: //медленный чат ждёт, пока обработается другой
+				select {
+				case <-released:
+				case <-time.After(5 * time.Second):
+					t.Error("обновление чата 2 ждало медленный чат 1")
+				}
+			case 2:
+				close(released)
+			}
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("dispatchUpdates не завершился после закрытия канала")
+	}
+}
+
+func TestDispatchUpdatesRecoversPanic(t *testing.T) {
+	updates := make(chan tgbotapi.Update, 3)
+	updates <- chatUpdate(1, 5)
+	updates <- chatUpdate(2, 5)
+	updates <- chatUpdate(3, 13) //тот же воркер, что у чата 5
+	close(updates)
+
+	var mu sync.Mutex
+	var handled []int
+	dispatchUpdates(updates, func(update tgbotapi.Update) {
+		if update.UpdateID == 1 {
+			panic("сбой обработчика")
+		}
+		mu.Lock()
+		handled = append(handled, update.UpdateID)
+		mu.Unlock()
+	})
+
+	if len(handled) != 2 || handled[0] != 2 || handled[1] != 3 {
+		t.Fatalf("после паники обработаны %v, ожидались [2 3]", handled)
+	}
+}
+
+func TestChatStateConcurrentAccess(t *testing.T) {
+	state := NewChatState[PaginationState]()
+	var wg sync.WaitGroup
+	for worker := 0; worker < updateWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				chatID := int64(i % 16) //чаты пересекаются между горутинами
+				switch (worker + i) % 4 {
+				case 0:
+					state.Set(chatID, PaginationState{CurrentPage: i})
+				case 1:
+					state.Get(chatID)
+				case 2:
+					state.Lookup(chatID)
+				case 3:
+					state.Delete(chatID)
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	state.Set(42, PaginationState{CurrentPage: 3})
+	if value, ok := state.Lookup(42); !ok || value.CurrentPage != 3 {
+		t.Fatalf("Lookup(42) = %+v, %v", value, ok)
+	}
+	state.Delete(42)
+	if _, ok := state.Lookup(42); ok {
+		t.Fatal("состояние чата 42 осталось после Delete")
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: loginFreeAttempts - 1, want: 0},
+		{failures: loginFreeAttempts, want: loginBaseDelay},
+		{failures: loginFreeAttempts + 1, want: 2 * loginBaseDelay},
+		{failures: loginFreeAttempts + 2, want: 4 * loginBaseDelay},
+		{failures: loginLockAfter - 1, want: loginBaseDelay << (loginLockAfter - 1 - loginFreeAttempts)},
+		{failures: loginLockAfter, want: loginLockout},
+		{failures: 1000, want: loginLockout}, //дальше блокировки задержка не растёт
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("loginDelay(%d) = %s, ожидалось %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestParseBanDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: " - ", want: 0},
+		{value: "0", want: 0},
+		{value: "30m", want: 30 * time.Minute},
+		{value: "12h", want: 12 * time.Hour},
+		{value: "7d", want: 7 * 24 * time.Hour},
+		{value: "0d", wantErr: true},
+		{value: "-1d", wantErr: true},
+		{value: "-5m", wantErr: true},
+		{value: "d", wantErr: true},
+		{value: "неделя", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseBanDuration(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseBanDuration(%q) = %s, ожидалась ошибка", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseBanDuration(%q) = %s, %v; ожидалось %s", tt.value, got, err, tt.want)
+		}
+	}
+}
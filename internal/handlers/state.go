@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"project/internal/models"
+	"sync"
+	"time"
+)
+
+// ChatState - состояние просмотра по чатам (страница, фильтр), которое не нужно хранить в БД.
+// Обновления разных чатов обрабатываются параллельно, поэтому доступ к значениям защищён мьютексом
+type ChatState[T any] struct {
+	mu     sync.Mutex
+	values map[int64]T
+}
+
+func NewChatState[T any]() *ChatState[T] {
+	return &ChatState[T]{values: make(map[int64]T)}
+}
+
+func (s *ChatState[T]) Lookup(chatID int64) (T, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[chatID]
+	return value, ok
+}
+
+func (s *ChatState[T]) Get(chatID int64) T { //нулевое значение, если состояния нет
+	value, _ := s.Lookup(chatID)
+	return value
+}
+
+func (s *ChatState[T]) Set(chatID int64, value T) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[chatID] = value
+}
+
+func (s *ChatState[T]) Delete(chatID int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, chatID)
+}
+
+// ConversationStore - хранилище шагов диалога, переживающее перезапуск бота
+type ConversationStore interface {
+	Conversation(ctx context.Context, chatID int64) (*models.Conversation, error)
+	SaveConversation(ctx context.Context, conversation *models.Conversation) error
+	DeleteConversation(ctx context.Context, chatID int64) error
+}
+
+var conversationStore ConversationStore
+
+func InitConversations(store ConversationStore) {
+	conversationStore = store
+}
+
+// Состояния диалога. У чата одно состояние: начало нового диалога отменяет предыдущий
+const (
+	StateSearchProduct  = "search_product"  // ждём запрос поиска товара
+	StateSearchUser     = "search_user"     // ждём запрос поиска пользователя
+	StateSearchCategory = "search_category" // ждём запрос поиска категории
+	StateConfirm        = "confirm"         // ждём + для подтверждения удаления, ConfirmPayload
+	StateLoginTOTP      = "login_totp"      // ждём код второго фактора, PendingLogin
+	StateProfileInput   = "profile_input"   // ждём новое значение поля профиля, ProfilePayload
+	StateShopping       = "shopping"        // выбор товара и количества, ShoppingPayload
+)
+
+type conversationState struct {
+	Timeout time.Duration
+	Expired string // ответ на сообщение после таймаута; пусто - сообщение обрабатывается как обычно
+}
+
+var conversationStates = map[string]conversationState{
+	StateSearchProduct:  {Timeout: 10 * time.Minute, Expired: "Время ожидания запроса истекло. Повторите поиск"},
+	StateSearchUser:     {Timeout: 10 * time.Minute, Expired: "Время ожидания запроса истекло. Повторите поиск"},
+	StateSearchCategory: {Timeout: 10 * time.Minute, Expired: "Время ожидания запроса истекло. Повторите поиск"},
+	StateConfirm:        {Timeout: 5 * time.Minute, Expired: "Время подтверждения истекло, удаление отменено"},
+	StateLoginTOTP:      {Timeout: totpLoginTimeout, Expired: "Время ввода кода истекло. Повторите /login"},
+	StateProfileInput:   {Timeout: 15 * time.Minute, Expired: "Время ввода истекло, профиль не изменён. Повторите /profile"},
+	StateShopping:       {Timeout: 24 * time.Hour},
+}
+
+type ConfirmPayload struct { //удаление, ожидающее подтверждения
+	Action  string `json:"action"` // ключ в confirmActions
+	ID      int64  `json:"id"`
+	ActorID int64  `json:"actor_id"` // кто запросил удаление; подтвердить может только он
+}
+
+type ProfilePayload struct {
+	Field string `json:"field"`
+}
+
+type ShoppingPayload struct { //выбранные категория или бренд для перелистывания, товар или набор и количество
+	CategoryID int `json:"category_id,omitempty"`
+	BrandID    int `json:"brand_id,omitempty"`
+	ProductID  int `json:"product_id,omitempty"`
+	BundleID   int `json:"bundle_id,omitempty"`
+	Quantity   int `json:"quantity,omitempty"`
+}
+
+// currentConversation - шаг диалога чата, в том числе истёкший; nil если диалога нет или хранилище недоступно
+func currentConversation(ctx context.Context, chatID int64) *models.Conversation {
+	conversation, err := conversationStore.Conversation(ctx, chatID)
+	if err != nil {
+		return nil
+	}
+	return conversation
+}
+
+// conversationPayload разбирает данные шага, если диалог не истёк и находится в состоянии state
+func conversationPayload[T any](conversation *models.Conversation, state string) (T, bool) {
+	var payload T
+	if conversation == nil || conversation.State != state || conversation.Expired() {
+		return payload, false
+	}
+	if err := json.Unmarshal(conversation.Payload, &payload); err != nil {
+		log.Printf("Ошибка разбора состояния %s чата %d: %v", state, conversation.ChatID, err)
+		return payload, false
+	}
+	return payload, true
+}
+
+// conversationErrorText - ответ вместо приглашения к вводу, если шаг диалога не сохранился: иначе ввод потеряется
+const conversationErrorText = "Не удалось начать диалог, повторите попытку позже"
+
+// setConversation - переход в состояние; таймаут отсчитывается заново.
+// При ошибке вызывающий не должен просить ввод: следующее сообщение не будет связано с диалогом
+func setConversation(ctx context.Context, chatID int64, state string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Ошибка сохранения состояния %s чата %d: %v", state, chatID, err)
+		return err
+	}
+	err = conversationStore.SaveConversation(ctx, &models.Conversation{
+		ChatID:    chatID,
+		State:     state,
+		Payload:   data,
+		ExpiresAt: time.Now().Add(conversationStates[state].Timeout),
+	})
+	if err != nil {
+		log.Printf("Ошибка сохранения состояния %s чата %d: %v", state, chatID, err)
+	}
+	return err
+}
+
+func endConversation(ctx context.Context, chatID int64) {
+	if err := conversationStore.DeleteConversation(ctx, chatID); err != nil {
+		log.Printf("Ошибка сброса состояния диалога чата %d: %v", chatID, err)
+	}
+}
+
+func endConversationIn(ctx context.Context, chatID int64, state string) { //сброс, только если диалог в этом состоянии
+	if conversation := currentConversation(ctx, chatID); conversation != nil && conversation.State == state {
+		endConversation(ctx, chatID)
+	}
+}
+
+func shoppingState(ctx context.Context, chatID int64) ShoppingPayload { //пустой выбор, если чат не в состоянии покупки
+	payload, _ := conversationPayload[ShoppingPayload](currentConversation(ctx, chatID), StateShopping)
+	return payload
+}
+
+func updateShopping(ctx context.Context, chatID int64, update func(*ShoppingPayload)) error { //изменение выбора; другой диалог при этом отменяется
+	payload := shoppingState(ctx, chatID)
+	update(&payload)
+	return setConversation(ctx, chatID, StateShopping, payload)
+}
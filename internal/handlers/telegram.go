@@ -1,33 +1,75 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"log"
+	"math"
+	"net/mail"
 	"project/internal/models"
 	"project/internal/repo"
+	"project/internal/router"
 	"project/internal/utils"
 	"reflect"
+	"runtime/debug"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 type JWTConfig struct {
-	SecretKey     string
-	TokenDuration time.Duration // длительность жизни токена
+	Keys          map[string]string // ключи подписи по kid: старые ключи остаются для проверки после ротации
+	ActiveKey     string            // kid которым подписываются новые токены
+	TokenDuration time.Duration     // длительность жизни сессии без активности
+}
+
+// SessionStore - хранилище сессий. Реализация по умолчанию - repo.SessionRepo (таблица sessions)
+type SessionStore interface {
+	CreateSession(ctx context.Context, session *models.Session) error
+	Session(ctx context.Context, sessionID int64) (*models.Session, error)
+	ChatSession(ctx context.Context, chatID, fromID int64) (*models.Session, error)
+	Touch(ctx context.Context, sessionID int64, expiresAt time.Time) error
+	UserSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	SessionHistory(ctx context.Context, userID int64) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID int64) error
+	RevokeChatSessions(ctx context.Context, chatID, fromID int64) error
+	RevokeUserSessions(ctx context.Context, userID int64) error
 }
 
 var (
-	jwtConfig = JWTConfig{
-		SecretKey:     "secret_key",
-		TokenDuration: time.Minute * 10, //10 минут
-	}
-	userTokens = make(map[int64]string) // Храним токены в памяти (в продакшене используйте Redis/БД)
+	jwtConfig    JWTConfig
+	sessionStore SessionStore
 )
 
+const sessionTouchInterval = time.Minute // продление сессии не чаще раза в минуту
+
+const totpLoginTimeout = 5 * time.Minute // время на ввод кода второго фактора
+
+// ErrLoginRequired - покупатели входят по Telegram ID, остальным ролям нужен /login
+var ErrLoginRequired = errors.New("login required")
+
+type PendingLogin struct { //вход по паролю, ожидающий код второго фактора
+	UserID int64 `json:"user_id"`
+}
+
+func InitAuth(cfg JWTConfig, store SessionStore) error { //настройка подписи токенов и хранилища сессий
+	if _, ok := cfg.Keys[cfg.ActiveKey]; !ok {
+		return fmt.Errorf("не задан ключ подписи токенов %q: укажите JWT_SECRET или JWT_KEYS", cfg.ActiveKey)
+	}
+	jwtConfig = cfg
+	sessionStore = store
+	return nil
+}
+
 type Claims struct {
 	UserID   int64  `json:"user_id"`
 	Username string `json:"username"`
@@ -42,10 +84,6 @@ type PaginationState struct {
 	Count       int
 }
 
-type BuyingState struct {
-	Total_quantity int
-}
-
 type OrderState struct {
 	ProductID   int
 	ProductName string
@@ -57,40 +95,38 @@ type OrderState struct {
 
 const DataOnPage = 5
 
-var paginationState = make(map[int64]PaginationState) //состояние пагинации
-var buyingState = make(map[int64]BuyingState)         //состояние покупки
-var waitingProduct = make(map[int64]bool)             //состояние поиска товара 2м смс
-var waitingUser = make(map[int64]bool)                //состояние поиска юзера 2м смс
-var waitingCategory = make(map[int64]bool)            //состояние поиска категории 2м смс
-var waitingConfirm = make(map[int64]func() error)     //чат и функция по удалению
-var SelectProduct = make(map[int64]int)               //выбранный товар
-var SelectQuantity = make(map[int64]int)              //выбранное количество
-var SelectCategory = make(map[int64]int)              //выбранная категория
-
-func GenerateToken(user *models.User) (string, error) {
-	expirationTime := time.Now().Add(jwtConfig.TokenDuration)
+var paginationState = NewChatState[PaginationState]() //состояние пагинации
+var auditFilters = NewChatState[models.AuditFilter]() //фильтр журнала действий для перелистывания
 
+func GenerateToken(user *models.User, session *models.Session) (string, error) { //токен сессии, подписанный активным ключом
 	claims := &Claims{
 		UserID:   int64(user.ID),
 		Username: user.Username,
 		Role:     user.Role,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime), //когда истечёт
-			IssuedAt:  jwt.NewNumericDate(time.Now()),     //текущее время
-			Subject:   strconv.FormatInt(user.ID, 10),     //UserID
+			ID:        strconv.FormatInt(session.ID, 10),     //сессия
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt), //когда истечёт
+			IssuedAt:  jwt.NewNumericDate(time.Now()),        //текущее время
+			Subject:   strconv.FormatInt(user.ID, 10),        //UserID
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(jwtConfig.SecretKey))
+	token.Header["kid"] = jwtConfig.ActiveKey
+	return token.SignedString([]byte(jwtConfig.Keys[jwtConfig.ActiveKey]))
 }
 
 func VerifyToken(tokenString string) (*Claims, error) { //верификация токена: преобразовывает в данные и проверяет на валидность
 	claims := &Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(jwtConfig.SecretKey), nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		secret, ok := jwtConfig.Keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return []byte(secret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return nil, err
 	}
@@ -101,47 +137,231 @@ func VerifyToken(tokenString string) (*Claims, error) { //верификация
 	return claims, nil
 }
 
-func AuthenticateUser(tokenString string, userRepo *repo.UserRepo) (*models.User, error) { //аутентефикация по токену
+func StartSession(ctx context.Context, user *models.User, ChatID, FromID int64) (string, error) { //новая сессия отправителя в чате вместо предыдущей
+	session := &models.Session{
+		UserID:    user.ID,
+		ChatID:    ChatID,
+		FromID:    FromID,
+		ExpiresAt: time.Now().Add(jwtConfig.TokenDuration),
+	}
+	if err := sessionStore.CreateSession(ctx, session); err != nil {
+		return "", err
+	}
+	return GenerateToken(user, session)
+}
+
+func AuthenticateUser(ctx context.Context, tokenString string, FromID int64, userRepo *repo.UserRepo) (*models.User, error) { //аутентефикация по токену отправителя FromID
 	claims, err := VerifyToken(tokenString) //получение юзера из бд по токену
 	if err != nil {
 		return nil, err
 	}
-	users, err := userRepo.SearchUser(fmt.Sprintf("%d", claims.UserID))
+	sessionID, err := strconv.ParseInt(claims.ID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+	session, err := sessionStore.Session(ctx, sessionID)
 	if err != nil {
 		return nil, err
 	}
-	if len(users) == 0 {
-		return nil, fmt.Errorf("user not found")
+	if !session.Active() || session.UserID != claims.UserID {
+		return nil, fmt.Errorf("session expired")
+	}
+	if session.FromID != FromID { //токен из чужой сессии, например переданный в группе
+		return nil, fmt.Errorf("session belongs to another telegram user")
+	}
+	if time.Since(session.LastSeenAt) > sessionTouchInterval { //скользящее продление сессии
+		if err := sessionStore.Touch(ctx, session.ID, time.Now().Add(jwtConfig.TokenDuration)); err != nil {
+			log.Printf("Ошибка продления сессии %d: %v", session.ID, err)
+		}
 	}
-	return &users[0], nil
-}
 
-func AuthMiddleware(handler func(bot *tgbotapi.BotAPI, update tgbotapi.Update, // аутентефикация юзера и если с токеном - выполняет переданную функцию
-	user *models.User, userRepo *repo.UserRepo)) func(bot *tgbotapi.BotAPI, update tgbotapi.Update, userRepo *repo.UserRepo) {
+	return userRepo.UserByID(ctx, claims.UserID)
+}
 
-	return func(bot *tgbotapi.BotAPI, update tgbotapi.Update, userRepo *repo.UserRepo) { //аутентефикация в боте
-		token := GetTokenFromUpdate(update)
-		if token == "" {
-			msg := tgbotapi.NewMessage(GetChatID(update), "Используйте команду /login")
-			bot.Send(msg)
-			return
+// AuthorizeUpdate возвращает пользователя из сессии отправителя в чате, а если её нет - покупателя по Telegram ID.
+// Новый покупатель регистрируется автоматически, привилегированные роли должны войти через /login
+func AuthorizeUpdate(ctx context.Context, update tgbotapi.Update, userRepo *repo.UserRepo) (*models.User, error) {
+	from := update.SentFrom()
+	if from == nil {
+		return nil, ErrLoginRequired
+	}
+	if token := GetTokenFromUpdate(ctx, update); token != "" {
+		return AuthenticateUser(ctx, token, from.ID, userRepo)
+	}
+	user, err := userRepo.SearchUserTGID(ctx, from.ID)
+	if err != nil {
+		if !strings.Contains(err.Error(), "user not found") {
+			return nil, err
+		}
+		user = newCustomer(from)
+		if err := userRepo.CreateUser(ctx, user); err != nil {
+			return nil, err
 		}
-		user, err := AuthenticateUser(token, userRepo) //получение юзера из бд по токену
+		log.Printf("Зарегистрирован покупатель %d по Telegram ID", from.ID)
+	}
+	if user.Role != "user" {
+		return nil, ErrLoginRequired
+	}
+	return user, nil
+}
+
+func newCustomer(from *tgbotapi.User) *models.User { //покупатель без пароля
+	return &models.User{
+		TelegramID: from.ID,
+		Username:   from.UserName, //пусто, если ника нет: числа в нике путали бы поиск по ID
+		FirstName:  from.FirstName,
+		Role:       "user",
+	}
+}
+
+func completeLogin(ctx context.Context, bot *tgbotapi.BotAPI, ChatID, FromID int64, user *models.User) { //создание сессии после проверки пароля и кода
+	_, err := StartSession(ctx, user, ChatID, FromID)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(ChatID, fmt.Sprintf("Ошибка создания сессии: %v", err)))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(ChatID,
+		fmt.Sprintf("Здравствуйте, %s!\nВаш статус: %s\nID: %d\nСессия активна %s с последнего действия. Активные сессии: /sessions",
+			user.FirstName, user.Role, user.ID, formatDuration(jwtConfig.TokenDuration))))
+}
+
+const (
+	loginFreeAttempts = 3                // неудачные попытки без задержки
+	loginBaseDelay    = 30 * time.Second // первая задержка, дальше удваивается
+	loginLockAfter    = 10               // неудач подряд до временной блокировки
+	loginLockout      = time.Hour        // блокировка, она же наибольшая задержка
+	loginWindow       = 24 * time.Hour   // учитываются неудачи за это время
+)
+
+func loginDelay(failures int) time.Duration { //экспоненциальная задержка после серии неудачных входов
+	switch {
+	case failures < loginFreeAttempts:
+		return 0
+	case failures >= loginLockAfter:
+		return loginLockout
+	}
+	delay := loginBaseDelay << (failures - loginFreeAttempts)
+	if delay > loginLockout {
+		return loginLockout
+	}
+	return delay
+}
+
+// loginWait - сколько ждать до следующей попытки: счётчики ведутся отдельно по аккаунту и по чату
+func loginWait(ctx context.Context, attemptRepo *repo.LoginAttemptRepo, TelegramID, ChatID int64) (time.Duration, error) {
+	accountFailures, sinceAccount, err := attemptRepo.AccountFailures(ctx, TelegramID, loginWindow)
+	if err != nil {
+		return 0, err
+	}
+	chatFailures, sinceChat, err := attemptRepo.ChatFailures(ctx, ChatID, loginWindow)
+	if err != nil {
+		return 0, err
+	}
+	wait := loginDelay(accountFailures) - sinceAccount
+	if chatWait := loginDelay(chatFailures) - sinceChat; chatWait > wait {
+		wait = chatWait
+	}
+	return wait, nil
+}
+
+func loginAttempt(message *tgbotapi.Message, TelegramID int64, user *models.User, reason string) models.LoginAttempt {
+	attempt := models.LoginAttempt{
+		TelegramID: TelegramID,
+		ChatID:     message.Chat.ID,
+		FromID:     message.From.ID,
+		Success:    reason == models.LoginOK || reason == models.LoginReset,
+		Reason:     reason,
+	}
+	if user != nil {
+		attempt.UserID = user.ID
+	}
+	return attempt
+}
+
+var loginFailureText = map[string]string{ //причина неудачи для уведомления владельца
+	models.LoginPassword: "неверный пароль",
+	models.LoginTOTP:     "неверный код второго фактора",
+	models.LoginBadReset: "неверный код восстановления пароля",
+}
+
+// recordLogin пишет попытку в журнал, о неудаче из чужого чата сообщает владельцу аккаунта
+func recordLogin(ctx context.Context, bot *tgbotapi.BotAPI, attemptRepo *repo.LoginAttemptRepo, attempt models.LoginAttempt, owner *models.User) {
+	if err := attemptRepo.RecordAttempt(ctx, &attempt); err != nil {
+		return
+	}
+	if attempt.Success || owner == nil || attempt.ChatID == owner.TelegramID {
+		return
+	}
+	text := fmt.Sprintf("Неудачная попытка входа в ваш аккаунт из чата %d (Telegram ID %d): %s.\nЕсли это были не вы, смените пароль и проверьте /sessions",
+		attempt.ChatID, attempt.FromID, loginFailureText[attempt.Reason])
+	if failures, _, err := attemptRepo.AccountFailures(ctx, owner.TelegramID, loginWindow); err == nil && failures >= loginLockAfter {
+		text += fmt.Sprintf("\nВход в аккаунт заблокирован на %s", formatDuration(loginLockout))
+	}
+	bot.Send(tgbotapi.NewMessage(owner.TelegramID, text))
+}
+
+func deleteSecret(bot *tgbotapi.BotAPI, message *tgbotapi.Message) { //сообщения с паролями не остаются в чате
+	if _, err := bot.Request(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID)); err != nil {
+		log.Printf("Не удалось удалить сообщение с паролем в чате %d: %v", message.Chat.ID, err)
+	}
+}
+
+// finishPasswordChange завершает все сессии аккаунта и сообщает владельцу о смене пароля
+func finishPasswordChange(ctx context.Context, bot *tgbotapi.BotAPI, user *models.User, ChatID int64) {
+	if err := sessionStore.RevokeUserSessions(ctx, user.ID); err != nil {
+		log.Printf("Ошибка завершения сессий пользователя %d: %v", user.ID, err)
+	}
+	text := "Пароль изменён, все сессии завершены."
+	if user.Role != "user" {
+		text += " Войдите заново: /login password"
+	}
+	bot.Send(tgbotapi.NewMessage(ChatID, text))
+	if ChatID != user.TelegramID {
+		bot.Send(tgbotapi.NewMessage(user.TelegramID,
+			fmt.Sprintf("Пароль вашего аккаунта изменён из чата %d, все сессии завершены. Если это были не вы: /reset_password", ChatID)))
+	}
+}
+
+func authErrorText(err error) string { //ответ пользователю при ошибке авторизации
+	if errors.Is(err, ErrLoginRequired) {
+		return "Для этой роли нужен вход по паролю: /login password"
+	}
+	return "Сессия недействительна. Выполните /login"
+}
+
+func CurrentSessionID(ctx context.Context, ChatID, FromID int64) int64 { //0 если у отправителя в чате нет активной сессии
+	session, err := sessionStore.ChatSession(ctx, ChatID, FromID)
+	if err != nil || session == nil {
+		return 0
+	}
+	return session.ID
+}
+
+func AuthMiddleware(handler func(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update, // аутентефикация юзера и если с токеном - выполняет переданную функцию
+	user *models.User, userRepo *repo.UserRepo)) func(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update, userRepo *repo.UserRepo) {
+
+	return func(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update, userRepo *repo.UserRepo) { //аутентефикация в боте
+		user, err := AuthorizeUpdate(ctx, update, userRepo) //сессия или вход по Telegram ID
 		if err != nil {
-			msg := tgbotapi.NewMessage(GetChatID(update), "Токен недействителен /login")
+			msg := tgbotapi.NewMessage(GetChatID(update), authErrorText(err))
 			bot.Send(msg)
 			return
 		}
-		userTokens[GetChatID(update)] = token
 
-		handler(bot, update, user, userRepo) //вызов обработчика
+		handler(ctx, bot, update, user, userRepo) //вызов обработчика
 	}
 }
-func GetTokenFromUpdate(update tgbotapi.Update) string { //извлечение токена из сообщения
+func GetTokenFromUpdate(ctx context.Context, update tgbotapi.Update) string { //токен активной сессии отправителя в чате или переданный в команде
 	ChatID := GetChatID(update)
-	if ChatID > 0 {
-		if token, ok := userTokens[ChatID]; ok {
-			return token
+	if from := update.SentFrom(); ChatID != 0 && from != nil {
+		session, err := sessionStore.ChatSession(ctx, ChatID, from.ID)
+		if err != nil {
+			log.Printf("Ошибка загрузки сессии чата %d: %v", ChatID, err)
+		} else if session != nil {
+			token, err := GenerateToken(&models.User{ID: session.UserID}, session)
+			if err == nil {
+				return token
+			}
 		}
 	}
 
@@ -151,16 +371,6 @@ func GetTokenFromUpdate(update tgbotapi.Update) string { //извлечение
 			return strings.TrimPrefix(args, "token:")
 		}
 	}
-	if update.Message != nil {
-		if token, ok := userTokens[update.Message.Chat.ID]; ok {
-			return token
-		}
-	}
-	if update.CallbackQuery != nil {
-		if token, ok := userTokens[update.CallbackQuery.Message.Chat.ID]; ok {
-			return token
-		}
-	}
 	return ""
 }
 
@@ -174,17 +384,125 @@ func GetChatID(update tgbotapi.Update) int64 {
 	return 0
 }
 
-func CheckPermissions(userRepo *repo.UserRepo, token string, status int) error {
-	user, err := AuthenticateUser(token, userRepo)
-	if status == 1 && (user.Role == "user" || user.Role == "admin") {
-		return nil
-	} else if status == 2 && (user.Role == "admin") {
+var ErrForbidden = errors.New("forbidden")
+
+// Authorize - единая проверка прав для команд и кнопок: разрешение должно быть у одной из ролей пользователя
+func Authorize(ctx context.Context, roleRepo *repo.RoleRepo, user *models.User, permission string) error {
+	if permission == "" {
 		return nil
-	} else if status == 0 {
+	}
+	ok, err := roleRepo.HasPermission(ctx, user.ID, permission)
+	if err != nil {
+		log.Printf("Ошибка проверки прав пользователя %d: %v", user.ID, err)
+		return err
+	}
+	if !ok {
+		return ErrForbidden
+	}
+	return nil
+}
+
+const routeRateLimit = 30 // команд и нажатий кнопок одного чата за routeRateWindow
+const routeRateWindow = time.Minute
+
+type routeAuth struct { //проверка входа и разрешений для маршрутов router
+	userRepo *repo.UserRepo
+	roleRepo *repo.RoleRepo
+}
+
+func (a routeAuth) Authenticate(ctx *router.Context) (*models.User, error) {
+	return AuthorizeUpdate(ctx, ctx.Update, a.userRepo)
+}
+
+func (a routeAuth) Authorize(ctx *router.Context, user *models.User, permission string) error {
+	return Authorize(ctx, a.roleRepo, user, permission)
+}
+
+func (a routeAuth) Deny(ctx *router.Context, err error) {
+	var permissionErr *router.PermissionError
+	if errors.As(err, &permissionErr) {
+		router.Reply(ctx, forbiddenText(permissionErr.Err, permissionErr.Permission))
+		return
+	}
+	router.Reply(ctx, authErrorText(err))
+}
+
+func helpText(routes *router.Router) string { //список команд из описаний маршрутов: сначала общие, потом для сотрудников
+	var common, staff []string
+	for _, route := range routes.Commands() {
+		if route.Description == "" {
+			continue
+		}
+		line := "/" + route.Name
+		if route.Args != "" {
+			line += " " + route.Args
+		}
+		line += " - " + route.Description
+		if route.Permission != "" {
+			staff = append(staff, line)
+		} else {
+			common = append(common, line)
+		}
+	}
+	text := strings.Join(common, "\n")
+	if len(staff) > 0 {
+		text += "\n\nДля сотрудников:\n" + strings.Join(staff, "\n")
+	}
+	return text
+}
+
+func forbiddenText(err error, permission string) string { //ответ пользователю при нехватке прав
+	if errors.Is(err, ErrForbidden) {
+		return fmt.Sprintf("Недостаточно прав: нужно разрешение %s", permission)
+	}
+	return "Ошибка проверки прав доступа"
+}
+
+var ErrOwnerOnly = errors.New("роль owner назначает и снимает только владелец")
+
+func checkRoleChange(ctx context.Context, roleRepo *repo.RoleRepo, actor *models.User, role string) error { //роли меняет пользователь с roles.manage, owner - только владелец
+	if err := Authorize(ctx, roleRepo, actor, models.PermRolesManage); err != nil {
+		return err
+	}
+	if role != models.RoleOwner {
 		return nil
-	} else {
+	}
+	roles, err := roleRepo.UserRoles(ctx, actor.ID)
+	if err != nil {
+		return err
+	}
+	for _, actorRole := range roles {
+		if actorRole == models.RoleOwner {
+			return nil
+		}
+	}
+	return ErrOwnerOnly
+}
+
+func grantRole(ctx context.Context, roleRepo *repo.RoleRepo, actor *models.User, userID int64, role string) error {
+	if err := checkRoleChange(ctx, roleRepo, actor, role); err != nil {
 		return err
 	}
+	return roleRepo.GrantRole(ctx, userID, role, actor.ID)
+}
+
+func roleErrorText(err error) string {
+	if errors.Is(err, ErrForbidden) {
+		return forbiddenText(err, models.PermRolesManage)
+	}
+	return err.Error()
+}
+
+func parseRoleArgs(args string) (int64, string, bool) { //аргументы вида "user_id role"
+	data := strings.Fields(args)
+	if len(data) != 2 {
+		return 0, "", false
+	}
+	userID, err := strconv.ParseInt(data[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	return userID, strings.ToLower(data[1]), true
 }
 
 func CreateBuyingKeyboard(total_quantity int) tgbotapi.InlineKeyboardMarkup { // функция создания клавиатуры для покупки товара
@@ -194,23 +512,34 @@ func CreateBuyingKeyboard(total_quantity int) tgbotapi.InlineKeyboardMarkup { //
 
 	if total_quantity > 1 {
 		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("-",
-			fmt.Sprintf("buying_del_%d", total_quantity)))
+			router.Callback("buying", "del", total_quantity)))
 	}
 	quantity := fmt.Sprintf("%d", total_quantity)
 	nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%v", quantity),
-		fmt.Sprintf("buying_quantity_%d", total_quantity)))
+		router.Callback("buying", "quantity", total_quantity)))
 	nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("+",
-		fmt.Sprintf("buying_add_%d", total_quantity)))
+		router.Callback("buying", "add", total_quantity)))
 	if len(nav) > 0 {
 		rows = append(rows, nav)
 	}
 	rows = append(rows, []tgbotapi.InlineKeyboardButton{
-		tgbotapi.NewInlineKeyboardButtonData("Отмена", "cancell"),
-		tgbotapi.NewInlineKeyboardButtonData("Подтвердить", "confirm"),
+		tgbotapi.NewInlineKeyboardButtonData("Отмена", router.Callback("purchase", "cancel")),
+		tgbotapi.NewInlineKeyboardButtonData("Подтвердить", router.Callback("purchase", "confirm")),
 	})
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
+func CreateNotifyKeyboard(productID int) tgbotapi.InlineKeyboardMarkup { // клавиатура товара которого нет в наличии
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Сообщить о поступлении", router.Callback("notify", productID)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Главная", "start"),
+		),
+	)
+}
+
 func CreateCategoriesKeyboard(CurrentPage, Pages int, data []interface{}) tgbotapi.InlineKeyboardMarkup { //функция создания клавиатуры для выбора категории
 	var rows [][]tgbotapi.InlineKeyboardButton
 
@@ -218,14 +547,14 @@ func CreateCategoriesKeyboard(CurrentPage, Pages int, data []interface{}) tgbota
 	Type := "buycategories"
 	if CurrentPage > 1 {
 		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("← Назад",
-			fmt.Sprintf("prev_%s_%d", Type, CurrentPage)))
+			router.Callback(Type, CurrentPage-1)))
 	}
 	currentpage := fmt.Sprintf("%d/%d", CurrentPage, Pages)
 	nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(currentpage,
-		fmt.Sprintf("current_%s_%d", Type, CurrentPage)))
+		router.Callback(Type, CurrentPage)))
 	if CurrentPage < Pages {
 		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("Вперед →",
-			fmt.Sprintf("next_%s_%d", Type, CurrentPage)))
+			router.Callback(Type, CurrentPage+1)))
 	}
 
 	if len(nav) > 0 {
@@ -238,11 +567,9 @@ func CreateCategoriesKeyboard(CurrentPage, Pages int, data []interface{}) tgbota
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func ShowBuying(bot *tgbotapi.BotAPI, ChatID int64, MessageID, total_quantity int) { //не используется но аналогия с пагинацией
+func ShowBuying(ctx context.Context, bot *tgbotapi.BotAPI, ChatID int64, MessageID, total_quantity int) { //не используется но аналогия с пагинацией
 
-	buyingState[ChatID] = BuyingState{
-		Total_quantity: total_quantity,
-	}
+	updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { shopping.Quantity = total_quantity })
 	keyboard := CreateBuyingKeyboard(total_quantity)
 	response := fmt.Sprintf("К покупке: %d", total_quantity)
 	if MessageID != 0 {
@@ -256,13 +583,196 @@ func ShowBuying(bot *tgbotapi.BotAPI, ChatID int64, MessageID, total_quantity in
 	}
 }
 
-func ShowPagination(bot *tgbotapi.BotAPI, ChatID int64, MessageID int, Page int, //универсальная функция показа данных на страницу с пагинацией
-	CountData func() (int, error), //подсчёт страниц. Передается к примеру productRepo.CountProduct
-	PaginationFunc func(limit, offset int) ([]interface{}, error), //возрат данных одной страницы
+func showProduct(ctx context.Context, bot *tgbotapi.BotAPI, ChatID int64, MessageID int, product models.Product, //карточка товара: покупка или подписка если нет в наличии
+	productRepo *repo.ProductRepo, recommendationRepo *repo.RecommendationRepo) {
+	var response string
+	var keyboard tgbotapi.InlineKeyboardMarkup
+
+	details := formatUnitPrices(product)
+	nutrition, err := productRepo.Nutrition(ctx, product.ID)
+	if err != nil {
+		log.Printf("Ошибка загрузки пищевой ценности товара %d: %v", product.ID, err)
+	} else if nutrition != nil {
+		details += formatNutrition(*nutrition)
+	}
+
+	saveErr := updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { //выбор товара сбрасывает выбранный набор и количество
+		shopping.ProductID, shopping.BundleID, shopping.Quantity = 0, 0, 0
+		if product.Quantity > 0 {
+			shopping.ProductID = product.ID
+		}
+	})
+	if product.Quantity > 0 && saveErr != nil { //без сохранённого выбора кнопки покупки не сработают
+		response = fmt.Sprintf("Товар: %s (%s)\nЦена: %s руб.\n%s\n%s", product.Name, product.Flavor, formatPrice(product), details, conversationErrorText)
+		keyboard = tgbotapi.NewInlineKeyboardMarkup()
+	} else if product.Quantity > 0 {
+		response = fmt.Sprintf("Выбран товар: %s (%s)\nЦена: %s руб.\n%s\nВыберите количество:", product.Name, product.Flavor, formatPrice(product), details)
+		keyboard = CreateBuyingKeyboard(1) //создает клавиатуру покупки
+	} else {
+		response = fmt.Sprintf("Товар: %s (%s)\nЦена: %s руб.\n%s\nНет в наличии", product.Name, product.Flavor, formatPrice(product), details)
+		keyboard = CreateNotifyKeyboard(product.ID)
+	}
+
+	related, err := recommendationRepo.Related(ctx, product.ID, RecommendationsLimit)
+	if err != nil {
+		log.Printf("Ошибка загрузки рекомендаций товара %d: %v", product.ID, err)
+	}
+	text, rows := formatRecommendations("Часто покупают вместе:", related)
+	response += text
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, rows...)
+
+	if MessageID != 0 {
+		msg := tgbotapi.NewEditMessageText(ChatID, MessageID, response)
+		msg.ReplyMarkup = &keyboard
+		bot.Send(msg)
+	} else {
+		msg := tgbotapi.NewMessage(ChatID, response)
+		msg.ReplyMarkup = keyboard
+		bot.Send(msg)
+	}
+}
+
+const RecommendationsLimit = 3 //сколько товаров предлагать в "часто покупают вместе"
+
+func formatRecommendations(title string, products []models.Product) (string, [][]tgbotapi.InlineKeyboardButton) { //текст и кнопки перехода к рекомендованным товарам
+	if len(products) == 0 {
+		return "", nil
+	}
+	response := "\n\n" + title + "\n"
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, product := range products {
+		response += fmt.Sprintf("• %s (%s) - %s руб.\n", product.Name, product.Flavor, formatPrice(product))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s (%s)", product.Name, product.Flavor),
+				router.Callback("product", product.ID)),
+		))
+	}
+	return response, rows
+}
+
+func showBundle(ctx context.Context, bot *tgbotapi.BotAPI, ChatID int64, MessageID int, bundle models.Bundle) { //карточка набора: покупка если все товары набора есть в наличии
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	response := formatBundle(bundle)
+
+	saveErr := updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) {
+		shopping.ProductID, shopping.BundleID, shopping.Quantity = 0, 0, 0
+		if bundle.Available > 0 {
+			shopping.BundleID = bundle.ID
+		}
+	})
+	if bundle.Available > 0 && saveErr != nil { //без сохранённого выбора кнопки покупки не сработают
+		response += "\n" + conversationErrorText
+		keyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Все наборы", "bundles"),
+		))
+	} else if bundle.Available > 0 {
+		response += "\nВыберите количество:"
+		keyboard = CreateBuyingKeyboard(1)
+	} else {
+		response += "\nНет в наличии"
+		keyboard = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Все наборы", "bundles"),
+			tgbotapi.NewInlineKeyboardButtonData("Главная", "start"),
+		))
+	}
+
+	if MessageID != 0 {
+		msg := tgbotapi.NewEditMessageText(ChatID, MessageID, response)
+		msg.ReplyMarkup = &keyboard
+		bot.Send(msg)
+	} else {
+		msg := tgbotapi.NewMessage(ChatID, response)
+		msg.ReplyMarkup = keyboard
+		bot.Send(msg)
+	}
+}
+
+func addBundleToCart(ctx context.Context, bot *tgbotapi.BotAPI, ChatID int64, user *models.User, bundleID, quantity int, //добавление набора в корзину покупателя, корзина создаётся если её нет
+	orderRepo *repo.OrderRepo, bundleRepo *repo.BundleRepo) tgbotapi.MessageConfig {
+	bundle, err := bundleRepo.SearchBundle(ctx, bundleID)
+	if err != nil {
+		return tgbotapi.NewMessage(ChatID, "Набор не найден")
+	}
+
+	cart, err := orderRepo.DetailCart(ctx, int64(user.ID))
+	if err != nil {
+		return tgbotapi.NewMessage(ChatID, "Ошибка при работе с корзиной: "+err.Error())
+	}
+	var order models.Order
+	if cart == nil {
+		created, err := orderRepo.CreateOrder(ctx, int64(user.ID))
+		if err != nil {
+			return tgbotapi.NewMessage(ChatID, "Ошибка создания заказа: "+err.Error())
+		}
+		order = *created
+	} else {
+		order = cart.Order
+	}
+
+	err = orderRepo.AddBundleToCart(ctx, order.ID, *bundle, quantity)
+	if err != nil {
+		return tgbotapi.NewMessage(ChatID, "Ошибка добавления набора в корзину: "+err.Error())
+	}
+	updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { shopping.BundleID, shopping.Quantity = 0, 0 })
+
+	msg := tgbotapi.NewMessage(ChatID,
+		fmt.Sprintf("Набор добавлен в корзину\n\nЗаказ: #%d\nНабор: %s\nЦена набора: %.2f руб.\nКоличество: %d\nСумма: %.2f руб.",
+			order.ID, bundle.Name, bundle.Price, quantity, bundle.Price*float64(quantity)))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Продолжить покупки", "bundles"),
+			tgbotapi.NewInlineKeyboardButtonData("Корзина", "cart"),
+		),
+	)
+	return msg
+}
+
+// notifyRestocked рассылает подписчикам товары, которые репозиторий отметил как вновь появившиеся в наличии
+func notifyRestocked(ctx context.Context, bot *tgbotapi.BotAPI, productRepo *repo.ProductRepo, subscriptionRepo *repo.SubscriptionRepo, productIDs []int) {
+	for _, productID := range productIDs {
+		product, err := productRepo.ProductByID(ctx, productID)
+		if err != nil {
+			log.Printf("Ошибка рассылки о поступлении товара %d: %v", productID, err)
+			continue
+		}
+		if product.ArchivedAt != nil || !product.IsActive || product.Quantity <= 0 { //купить его всё равно нельзя, подписка сохраняется
+			continue
+		}
+		notifyRestock(ctx, bot, subscriptionRepo, *product)
+	}
+}
+
+func notifyRestock(ctx context.Context, bot *tgbotapi.BotAPI, subscriptionRepo *repo.SubscriptionRepo, product models.Product) { //рассылка подписчикам при поступлении товара
+	subscriptions, err := subscriptionRepo.PopSubscribers(ctx, product.ID)
+	if err != nil {
+		log.Printf("Ошибка рассылки о поступлении товара %d: %v", product.ID, err)
+		return
+	}
+	failed := 0
+	for _, subscription := range subscriptions {
+		msg := tgbotapi.NewMessage(subscription.ChatID,
+			fmt.Sprintf("Товар снова в наличии!\n\n%s (%s)\nЦена: %s руб.", product.Name, product.Flavor, formatPrice(product)))
+		msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Купить", router.Callback("product", product.ID)),
+			),
+		)
+		if _, err := bot.Send(msg); err != nil { //недоставленное уведомление не должно снимать подписку
+			log.Printf("Ошибка уведомления о поступлении товара %d в чат %d: %v", product.ID, subscription.ChatID, err)
+			failed++
+			subscriptionRepo.RestoreSubscription(ctx, subscription)
+		}
+	}
+	log.Printf("product_id: %d, action: restock_notify, subscribers: %d, failed: %d", product.ID, len(subscriptions), failed)
+}
+
+func ShowPagination(ctx context.Context, bot *tgbotapi.BotAPI, ChatID int64, MessageID int, Page int, //универсальная функция показа данных на страницу с пагинацией
+	CountData func(ctx context.Context) (int, error), //подсчёт страниц. Передается к примеру productRepo.CountProduct
+	PaginationFunc func(ctx context.Context, limit, offset int) ([]interface{}, error), //возрат данных одной страницы
 	formatFunc func(interface{}) string, //форматирование(вывод) данных
 	title string, paginationType string, showKeyboard bool) {
 	offset := (Page - 1) * DataOnPage
-	count, err := CountData()
+	count, err := CountData(ctx)
 	if err != nil {
 		fmt.Printf("error: %v", err)
 		msg := tgbotapi.NewMessage(ChatID, "Ошибка подсчёта данных")
@@ -270,7 +780,7 @@ func ShowPagination(bot *tgbotapi.BotAPI, ChatID int64, MessageID int, Page int,
 		return
 	}
 
-	data, err := PaginationFunc(DataOnPage, offset)
+	data, err := PaginationFunc(ctx, DataOnPage, offset)
 	if err != nil {
 		msg := tgbotapi.NewMessage(ChatID, "Ошибка загрузки данных")
 		bot.Send(msg)
@@ -280,17 +790,19 @@ func ShowPagination(bot *tgbotapi.BotAPI, ChatID int64, MessageID int, Page int,
 	if len(data) == 0 {
 		msg := tgbotapi.NewEditMessageText(ChatID, MessageID, "Нет данных!")
 		bot.Send(msg)
-		delete(SelectCategory, ChatID)
+		if shopping := shoppingState(ctx, ChatID); shopping.CategoryID != 0 || shopping.BrandID != 0 {
+			updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { shopping.CategoryID, shopping.BrandID = 0, 0 })
+		}
 		return
 	}
 
 	pages := (count + DataOnPage - 1) / DataOnPage
-	paginationState[ChatID] = PaginationState{
+	paginationState.Set(ChatID, PaginationState{
 		CurrentPage: Page,
 		Pages:       pages,
 		Type:        paginationType,
 		Count:       count,
-	}
+	})
 
 	response := fmt.Sprintf("Все %s\n\n", title)
 	for _, item := range data {
@@ -317,14 +829,14 @@ func CreatePaginationKeyboard(CurrentPage, Pages int, Type string, data []interf
 
 	if CurrentPage > 1 {
 		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("← Назад",
-			fmt.Sprintf("prev_%s_%d", Type, CurrentPage)))
+			router.Callback(Type, CurrentPage-1)))
 	}
 	currentpage := fmt.Sprintf("%d/%d", CurrentPage, Pages)
 	nav = append(nav, tgbotapi.NewInlineKeyboardButtonData(currentpage,
-		fmt.Sprintf("current_%s_%d", Type, CurrentPage)))
+		router.Callback(Type, CurrentPage)))
 	if CurrentPage < Pages {
 		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("Вперед →",
-			fmt.Sprintf("next_%s_%d", Type, CurrentPage)))
+			router.Callback(Type, CurrentPage+1)))
 	}
 
 	if len(nav) > 0 {
@@ -342,23 +854,42 @@ func CreatePaginationKeyboard(CurrentPage, Pages int, Type string, data []interf
 				}
 				currentRow = append(currentRow, tgbotapi.NewInlineKeyboardButtonData(
 					fmt.Sprintf("ID%d", product.ID),
-					fmt.Sprintf("product_%d", product.ID)))
+					router.Callback("product", product.ID)))
+			}
+			if len(currentRow) > 0 {
+				rows = append(rows, currentRow)
+			}
+		}
+		if Type == "bundles" {
+			var currentRow []tgbotapi.InlineKeyboardButton
+			for i, item := range data {
+				bundle := item.(models.Bundle)
+				if i > 0 && i%5 == 0 { //кнопок в ряду
+					rows = append(rows, currentRow)
+					currentRow = []tgbotapi.InlineKeyboardButton{}
+				}
+				currentRow = append(currentRow, tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf("ID%d", bundle.ID),
+					router.Callback("bundle", bundle.ID)))
 			}
 			if len(currentRow) > 0 {
 				rows = append(rows, currentRow)
 			}
 		}
-		if Type == "buycategories" {
+		if Type == "buycategories" || Type == "buybrands" {
 			var currentRow []tgbotapi.InlineKeyboardButton
 			for i, item := range data {
 				var buttonText, callbackData string
 				switch v := item.(type) {
 				case models.Category: //работа с категориями
 					buttonText = fmt.Sprintf("%d", v.ID)
-					callbackData = fmt.Sprintf("category_%d", v.ID)
+					callbackData = router.Callback("category", v.ID)
+				case models.Brand: //работа с брендами
+					buttonText = v.Name
+					callbackData = router.Callback("brand", v.ID)
 				case models.Product: //работа с товарами
 					buttonText = fmt.Sprintf("%d", v.ID)
-					callbackData = fmt.Sprintf("product_%d", v.ID)
+					callbackData = router.Callback("product", v.ID)
 				default:
 					continue // пропускаем неизвестный тип
 				}
@@ -383,83 +914,150 @@ func CreatePaginationKeyboard(CurrentPage, Pages int, Type string, data []interf
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
-func HandleUpdates(bot *tgbotapi.BotAPI, productRepo *repo.ProductRepo, categoryRepo *repo.CategoryRepo, //мейн функция обработки написанных сообщений
-	userRepo *repo.UserRepo, orderRepo *repo.OrderRepo) {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
-	var msg tgbotapi.MessageConfig
-	updates := bot.GetUpdatesChan(u)
-
-	commandHandlers := map[string]struct {
-		AuthRequired bool
-		AdminOnly    bool
-		Action       string
-		Handler      func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-			user *models.User, userRepo *repo.UserRepo)
-	}{
-		"create_product": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "create_product",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Split(update.Message.CommandArguments(), "|")
-
-				if len(data) < 10 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте\n /create_product name|description|flawor|brand|price|quantity|category_id|weight|servings|is_active\n")
-					bot.Send(msg)
-					return
-				}
+// HandleUpdates - мейн функция обработки обновлений; возвращается, когда канал закрыт и всё обработано.
+// Отмена baseCtx прерывает запросы к БД в обработчиках, которые не успели завершиться
+func HandleUpdates(baseCtx context.Context, bot *tgbotapi.BotAPI, updates tgbotapi.UpdatesChannel,
+	productRepo *repo.ProductRepo, categoryRepo *repo.CategoryRepo,
+	userRepo *repo.UserRepo, orderRepo *repo.OrderRepo, subscriptionRepo *repo.SubscriptionRepo, saleRepo *repo.SaleRepo,
+	recommendationRepo *repo.RecommendationRepo, bundleRepo *repo.BundleRepo, brandRepo *repo.BrandRepo,
+	roleRepo *repo.RoleRepo, loginAttemptRepo *repo.LoginAttemptRepo, passwordResetRepo *repo.PasswordResetRepo,
+	banRepo *repo.BanRepo, referralRepo *repo.ReferralRepo, settingRepo *repo.SettingRepo, loyaltyRepo *repo.LoyaltyRepo,
+	auditRepo *repo.AuditRepo) {
+	routes := router.New()
+	routes.Use(
+		router.Recover(),
+		router.Logger(),
+		router.RateLimit(routeRateLimit, routeRateWindow),
+		router.Auth(routeAuth{userRepo: userRepo, roleRepo: roleRepo}),
+	)
+	routes.NotFound = func(ctx *router.Context) { //кнопка из старого сообщения или с неизвестными данными
+		router.Reply(ctx, "Кнопка устарела. Откройте меню заново: /start")
+	}
+	registerCallbacks(routes, productRepo, categoryRepo, userRepo, orderRepo, subscriptionRepo, recommendationRepo,
+		bundleRepo, brandRepo, roleRepo, loyaltyRepo, auditRepo)
+	routes.Command(router.Route{
+		Name:         "create_product",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+
+			if len(data) < 10 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /create_product name|description|flawor|brand|price|quantity|category_id|weight|servings|is_active|image_url\nimage_url необязателен")
+				bot.Send(msg)
+				return
+			}
 
-				product := &models.Product{}
+			product := &models.Product{}
 
-				for i, field := range []*string{&product.Name, &product.Description,
-					&product.Flavor, &product.Brand} {
-					*field = data[i]
-				}
+			for i, field := range []*string{&product.Name, &product.Description,
+				&product.Flavor, &product.Brand} {
+				*field = data[i]
+			}
 
-				for i, field := range []interface{}{&product.Price, &product.Quantity, &product.Category_id, &product.Weight,
-					&product.Servings, &product.IsActive} {
-					switch field := field.(type) {
-					case *float64:
-						*field, _ = strconv.ParseFloat(data[i+4], 64)
-					case *int:
-						*field, _ = strconv.Atoi(data[i+4])
-					case *bool:
-						*field, _ = strconv.ParseBool(data[i+4])
-					}
+			for i, field := range []interface{}{&product.Price, &product.Quantity, &product.Category_id, &product.Weight,
+				&product.Servings, &product.IsActive} {
+				switch field := field.(type) {
+				case *float64:
+					*field, _ = strconv.ParseFloat(data[i+4], 64)
+				case *int:
+					*field, _ = strconv.Atoi(data[i+4])
+				case *bool:
+					*field, _ = strconv.ParseBool(data[i+4])
 				}
-
-				err := productRepo.CreateProduct(product)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания товара: %v", err))
-					bot.Send(msg)
-					return
-				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Создан товар\nID: %d\nНазвание: %s\nОписание: %s\nЦена: %.2f\nКоличество: %d\nКатегория ID: %d\nВес: %v\nВкус: %s\nБренд: %s\nПорций: %d\nАктивен: %v",
-							product.ID, product.Name, product.Description, product.Price, product.Quantity,
-							product.Category_id, product.Weight, product.Flavor, product.Brand, product.Servings,
-							product.IsActive))
-					bot.Send(msg)
+			}
+			if len(data) > 10 { //картинка для inline-поиска
+				product.ImageURL = data[10]
+			}
+			brand, err := brandRepo.BrandByName(ctx, product.Brand)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Бренд %s не найден. Создайте его: /create_brand", product.Brand))
+				bot.Send(msg)
+				return
+			}
+			product.Brand = brand.Name
+
+			err = productRepo.CreateProduct(ctx, product)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания товара: %v", err))
+				bot.Send(msg)
+				return
+			} else {
+				recordAudit(ctx, auditRepo, user, "create_product", models.AuditProduct, product.ID, nil, product)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Создан товар\nID: %d\nНазвание: %s\nОписание: %s\nЦена: %.2f\nКоличество: %d\nКатегория ID: %d\nВес: %v\nВкус: %s\nБренд: %s\nПорций: %d\nАктивен: %v",
+						product.ID, product.Name, product.Description, product.Price, product.Quantity,
+						product.Category_id, product.Weight, product.Flavor, product.Brand, product.Servings,
+						product.IsActive))
+				bot.Send(msg)
+			}
+
+		},
+	})
+	routes.Command(router.Route{
+		Name:        "products",
+		Description: "все товары",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			products, err := productRepo.AllProducts(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки товаров")
+				bot.Send(msg)
+				return
+			} else {
+				response := "All products \n\n"
+				for _, product := range products {
+					response += formatProduct(product) + "\n"
 				}
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+				bot.Send(msg)
+			}
 
-			},
 		},
-		"products": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "products",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				products, err := productRepo.AllProducts()
+	})
+	routes.Command(router.Route{
+		Name:        "search_product",
+		Args:        "текст|бренд",
+		Description: "поиск товаров бренда",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			searchQuery := update.Message.CommandArguments()
+
+			if searchQuery == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите название товара для поиска")
+				if err := setConversation(ctx, update.Message.Chat.ID, StateSearchProduct, nil); err != nil { // ждём запрос следующим сообщением
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText)
+				}
+				bot.Send(msg)
+			} else {
+				var products []models.Product
+				var err error
+				if text, brandName, found := strings.Cut(searchQuery, "|"); found { //фильтр по бренду: /search_product текст|бренд
+					brand, brandErr := brandRepo.BrandByName(ctx, brandName)
+					if brandErr != nil {
+						msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Бренд %s не найден. Список брендов: /brands", brandName))
+						bot.Send(msg)
+						return
+					}
+					products, err = productRepo.SearchProductByBrand(ctx, strings.TrimSpace(text), brand.ID)
+				} else {
+					products, err = productRepo.SearchProduct(ctx, searchQuery)
+				}
 				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки товаров")
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
+					bot.Send(msg)
+					return
+				} else if len(products) == 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" товаров не найдено")
 					bot.Send(msg)
 					return
 				} else {
-					response := "All products \n\n"
+					response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
 					for _, product := range products {
 						response += formatProduct(product) + "\n"
 					}
@@ -467,1109 +1065,3596 @@ func HandleUpdates(bot *tgbotapi.BotAPI, productRepo *repo.ProductRepo, category
 					bot.Send(msg)
 				}
 
-			},
-		},
-		"search_product": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "search_product",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				searchQuery := update.Message.CommandArguments()
-
-				if searchQuery == "" {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите название товара для поиска")
-					waitingProduct[update.Message.Chat.ID] = true // поднимаем флаг если не будет поиска 1м сообщением
-					bot.Send(msg)
-				} else {
-					products, err := productRepo.SearchProduct(searchQuery)
-					if err != nil {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
-						bot.Send(msg)
-						return
-					} else if len(products) == 0 {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" товаров не найдено")
-						bot.Send(msg)
-						return
-					} else {
-						response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
-						for _, product := range products {
-							response += formatProduct(product) + "\n"
-						}
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-						bot.Send(msg)
-					}
-
-				}
-			},
+			}
 		},
-		"update_product": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "update_product",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Split(update.Message.CommandArguments(), "|")
-
-				if len(data) < 11 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте\n /update_product id|price|quantity|weight|category_id|servings|is_active|name|description|flavor|brand\nНеизменённые поля заполнять символом *")
-					bot.Send(msg)
-					return
-				}
+	})
+	routes.Command(router.Route{
+		Name:         "update_product",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+
+			if len(data) < 11 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /update_product id|price|quantity|weight|category_id|servings|is_active|name|description|flavor|brand|image_url\nНеизменённые поля заполнять символом *, image_url необязателен")
+				bot.Send(msg)
+				return
+			}
 
-				products, err := productRepo.SearchProduct(data[0])
-				if err != nil || len(products) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Товар не найден")
-					bot.Send(msg)
-					return
-				}
-				product := &products[0] //инициализация товара который будет изменться
-
-				for i, field := range []interface{}{&product.Price, &product.Quantity, &product.Weight, &product.Category_id,
-					//конструкция для обработки int,float,bool подающегося поля
-					&product.Servings, &product.IsActive} {
-					switch field := field.(type) {
-					case *float64:
-						*field, _ = strconv.ParseFloat(data[i+1], 64)
-					case *int:
-						*field, _ = strconv.Atoi(data[i+1])
-					case *bool:
-						*field, _ = strconv.ParseBool(data[i+2])
-					}
+			products, err := productRepo.SearchProduct(ctx, data[0])
+			if err != nil || len(products) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Товар не найден")
+				bot.Send(msg)
+				return
+			}
+			product := &products[0] //инициализация товара который будет изменться
+			before := *product
+
+			for i, field := range []interface{}{&product.Price, &product.Quantity, &product.Weight, &product.Category_id,
+				//конструкция для обработки int,float,bool подающегося поля
+				&product.Servings, &product.IsActive} {
+				switch field := field.(type) {
+				case *float64:
+					*field, _ = strconv.ParseFloat(data[i+1], 64)
+				case *int:
+					*field, _ = strconv.Atoi(data[i+1])
+				case *bool:
+					*field, _ = strconv.ParseBool(data[i+2])
 				}
+			}
 
-				for i, field := range []*string{&product.Name, &product.Description, //обработка строковых полей вкуса и бренда
-					&product.Flavor, &product.Brand} {
+			for i, field := range []*string{&product.Name, &product.Description, //обработка строковых полей вкуса и бренда
+				&product.Flavor, &product.Brand} {
+				if data[i+7] != "*" {
 					*field = data[i+7]
 				}
+			}
+			if len(data) > 11 && data[11] != "*" {
+				product.ImageURL = data[11]
+			}
+			brand, err := brandRepo.BrandByName(ctx, product.Brand)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Бренд %s не найден. Создайте его: /create_brand", product.Brand))
+				bot.Send(msg)
+				return
+			}
+			product.Brand = brand.Name
 
-				err = productRepo.UpdateProduct(product) //внесённые изменения вносятся в товар
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка изменения товара: %v", err))
-					bot.Send(msg)
-					return
-				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Изменен товар\nID: %d\nНазвание: %s\nОписание: %s\nЦена: %.2f\nКоличество: %d\nКатегория ID: %d\nВес: %v\nВкус: %s\nБренд: %s\nПорций: %d\nАктивен: %v",
-							product.ID, product.Name, product.Description, product.Price, product.Quantity,
-							product.Category_id, product.Weight, product.Flavor, product.Brand, product.Servings,
-							product.IsActive))
-					bot.Send(msg)
+			restocked, err := productRepo.UpdateProduct(ctx, product) //внесённые изменения вносятся в товар
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка изменения товара: %v", err))
+				bot.Send(msg)
+				return
+			} else {
+				recordAudit(ctx, auditRepo, user, "update_product", models.AuditProduct, product.ID, before, product)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Изменен товар\nID: %d\nНазвание: %s\nОписание: %s\nЦена: %.2f\nКоличество: %d\nКатегория ID: %d\nВес: %v\nВкус: %s\nБренд: %s\nПорций: %d\nАктивен: %v",
+						product.ID, product.Name, product.Description, product.Price, product.Quantity,
+						product.Category_id, product.Weight, product.Flavor, product.Brand, product.Servings,
+						product.IsActive))
+				bot.Send(msg)
+				if restocked {
+					notifyRestocked(ctx, bot, productRepo, subscriptionRepo, []int{product.ID})
 				}
+			}
 
-			},
 		},
-		"delete_product": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "delete_product",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Fields(update.Message.CommandArguments())
-				if len(data) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_product product_id")
-					bot.Send(msg)
-					return
-				}
-				productID, err := strconv.Atoi(data[0])
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
-					bot.Send(msg)
-					return
-				}
-				product, err := productRepo.SearchProduct(fmt.Sprintf("%d", productID))
-				if err != nil || len(product) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Товар не найден")
-					bot.Send(msg)
-					return
-				}
-
-				waitingConfirm[update.Message.Chat.ID] = func() error { return productRepo.DeleteProduct(productID) }
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
-					"Напишите + если хотите удалить товар: %s, ID = %d", product[0].Name, productID))
+	})
+	routes.Command(router.Route{
+		Name:         "delete_product",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Fields(update.Message.CommandArguments())
+			if len(data) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_product product_id")
 				bot.Send(msg)
-			},
+				return
+			}
+			productID, err := strconv.Atoi(data[0])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
+				bot.Send(msg)
+				return
+			}
+			product, err := productRepo.SearchProduct(ctx, fmt.Sprintf("%d", productID))
+			if err != nil || len(product) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Товар не найден")
+				bot.Send(msg)
+				return
+			}
+
+			if err := setConversation(ctx, update.Message.Chat.ID, StateConfirm, ConfirmPayload{Action: "delete_product", ID: int64(productID), ActorID: user.ID}); err != nil {
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText))
+				return
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+				"Напишите + если хотите удалить товар: %s, ID = %d\nТовар будет перенесён в архив, история заказов сохранится. Восстановить: /restore_product %d",
+				product[0].Name, productID, productID))
+			bot.Send(msg)
 		},
+	})
 
-		"create_category": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "create_category",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Split(update.Message.CommandArguments(), "|")
+	routes.Command(router.Route{
+		Name:        "compare",
+		Args:        "id id [id id]",
+		Description: "сравнение товаров",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			data := strings.FieldsFunc(update.Message.CommandArguments(), func(r rune) bool {
+				return r == ' ' || r == ',' || r == '|'
+			})
+			if len(data) < CompareMin || len(data) > CompareMax {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Укажите от %d до %d ID товаров: /compare 1 2 3", CompareMin, CompareMax))
+				bot.Send(msg)
+				return
+			}
 
-				if len(data) < 3 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте\n /create_category name|description|is_active\n")
+			var products []models.Product
+			var nutritions []*models.Nutrition
+			for _, ID := range data {
+				productID, err := strconv.Atoi(ID)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
 					bot.Send(msg)
 					return
 				}
-				is_active, err := strconv.ParseBool(data[2])
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("is_active должно быть true/false: %v", err))
+				found, err := productRepo.ProductByID(ctx, productID)
+				if err != nil || found.ArchivedAt != nil || !found.IsActive { //снятые с продажи товары покупателю не показываются
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Товар %s не найден", ID))
 					bot.Send(msg)
 					return
 				}
-				category := &models.Category{ //всего 3 поля которые можно изменять. id,created_at автоматические
-					Name:        data[0],
-					Description: data[1],
-					IsActive:    is_active,
-				}
-				err = categoryRepo.CreateCategory(category)
+				nutrition, err := productRepo.Nutrition(ctx, found.ID)
 				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания категории: %v", err))
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки пищевой ценности")
 					bot.Send(msg)
 					return
-				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Создана категория %s\nID: %d\nОписание: %s",
-							category.Name, category.ID, category.Description))
-					bot.Send(msg)
 				}
+				products = append(products, *found)
+				nutritions = append(nutritions, nutrition)
+			}
 
-			},
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, formatComparison(products, nutritions))
+			msg.ParseMode = tgbotapi.ModeHTML
+			bot.Send(msg)
 		},
-		"categories": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "categories",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				categories, err := categoryRepo.AllCategories()
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки категорий")
+	})
+	routes.Command(router.Route{
+		Name:         "set_nutrition",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+			if len(data) < 6 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /set_nutrition product_id|serving_size|protein|carbs|fat|calories\nЗначения указываются на одну порцию, в граммах")
+				bot.Send(msg)
+				return
+			}
+			productID, err := strconv.Atoi(data[0])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
+				bot.Send(msg)
+				return
+			}
+			product, err := productRepo.ProductByID(ctx, productID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Товар не найден")
+				bot.Send(msg)
+				return
+			}
+
+			nutrition := &models.Nutrition{ProductID: productID}
+			for i, field := range []*float64{&nutrition.ServingSize, &nutrition.Protein,
+				&nutrition.Carbs, &nutrition.Fat, &nutrition.Calories} {
+				*field, err = strconv.ParseFloat(strings.ReplaceAll(data[i+1], ",", "."), 64)
+				if err != nil || *field < 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Некорректное значение: %s", data[i+1]))
 					bot.Send(msg)
 					return
-				} else {
-					response := "Все категории:\n\n"
-					for _, categories := range categories {
-						response += formatCategory(categories)
-					}
-					response = response + "\n\n"
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-					bot.Send(msg)
 				}
+			}
+			if nutrition.ServingSize == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Размер порции должен быть больше 0")
+				bot.Send(msg)
+				return
+			}
 
-			},
-		},
-		"search_by_category": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "search_by_category",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				category := update.Message.CommandArguments()
-
-				if category == "" {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите название категории для поиска")
-					waitingCategory[update.Message.Chat.ID] = true // поднимаем флаг если не будет поиска 1м сообщением
-				} else {
-					products, err := productRepo.ProductsByCategory(category)
-					if err != nil {
-						fmt.Printf("error: %v", err)
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
-					} else if len(products) == 0 {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+category+" категорий не найдено")
-					} else {
-						response := "Результаты поиска по запросу: " + category + "\n\n"
-						for _, product := range products {
-							response += formatProduct(product) + "\n"
-						}
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-					}
-					bot.Send(msg)
-				}
-			},
+			before, err := productRepo.Nutrition(ctx, productID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки пищевой ценности")
+				bot.Send(msg)
+				return
+			}
+			err = productRepo.SetNutrition(ctx, nutrition)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка сохранения пищевой ценности: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "set_nutrition", models.AuditProduct, productID, before, nutrition)
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Пищевая ценность товара %s (ID %d) сохранена\n%s", product.Name, productID, formatNutrition(*nutrition)))
+			bot.Send(msg)
 		},
-		"search_category": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "search_category",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				searchQuery := update.Message.CommandArguments()
-
-				if searchQuery == "" {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите название категории для поиска")
-					waitingCategory[update.Message.Chat.ID] = true // поднимаем флаг если не будет поиска 1м сообщением
-				} else {
-					categories, err := categoryRepo.SearchCategory(searchQuery)
-					if err != nil {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
-						bot.Send(msg)
-						return
-					} else if len(categories) == 0 {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" категорий не найдено")
-						bot.Send(msg)
-						return
-					} else {
-						response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
-						for _, category := range categories {
-							response += formatCategory(category) + "\n"
-						}
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-						bot.Send(msg)
-					}
-				}
-			},
+	})
+
+	routes.Command(router.Route{
+		Name:         "price_history",
+		AuthRequired: true,
+		Permission:   models.PermReportsView,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			productID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /price_history product_id")
+				bot.Send(msg)
+				return
+			}
+			history, err := productRepo.PriceHistory(ctx, productID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки истории цен")
+				bot.Send(msg)
+				return
+			}
+			if len(history) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Цена товара не менялась")
+				bot.Send(msg)
+				return
+			}
+			response := fmt.Sprintf("История цен товара ID %d\n\n", productID)
+			for _, change := range history {
+				response += fmt.Sprintf("%s: %.2f → %.2f руб.\n",
+					change.ChangedAt.Format("02.01.2006 15:04"), change.OldPrice, change.NewPrice)
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
 		},
-		"update_category": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "update_category",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Split(update.Message.CommandArguments(), "|")
+	})
+	routes.Command(router.Route{
+		Name:         "create_sale",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+			if len(data) < 5 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /create_sale type|target|value|starts_at|ends_at\n"+
+						"type: product, category или brand\ntarget: ID товара/категории или название бренда\n"+
+						"value: скидка в процентах (20%) или цена (только для товара)\nДаты в формате 02.01.2006 15:04")
+				bot.Send(msg)
+				return
+			}
 
-				if len(data) < 4 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте\n /update_category id|name|description|is_active\nНеизменённые поля заполнять символом *")
+			sale := &models.Sale{TargetType: data[0], Target: strings.TrimSpace(data[1])}
+			switch sale.TargetType {
+			case models.SaleTargetProduct, models.SaleTargetCategory:
+				if _, err := strconv.Atoi(sale.Target); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
 					bot.Send(msg)
 					return
 				}
-
-				categories, err := categoryRepo.SearchCategory(data[0])
-				if err != nil || len(categories) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Категория не найдена")
+			case models.SaleTargetBrand: //в распродаже хранится ID бренда, чтобы она пережила переименование
+				brand, err := brandRepo.BrandByName(ctx, sale.Target)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Бренд %s не найден", sale.Target))
 					bot.Send(msg)
 					return
 				}
-				category := &categories[0] //категория для изменения
-
-				for i, field := range []*string{&category.Name, &category.Description} { //строковые поля изменяются
-					if data[i+1] != "*" {
-						*field = data[i+1]
-					}
-				}
-
-				if data[3] != "*" {
-					IsActive, _ := strconv.ParseBool(data[3]) //бул значение
-					category.IsActive = IsActive
-				}
+				sale.Target = strconv.Itoa(brand.ID)
+			default:
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "type должен быть product, category или brand")
+				bot.Send(msg)
+				return
+			}
 
-				err = categoryRepo.UpdateCategory(category)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка изменения категории: %v", err))
-					bot.Send(msg)
-					return
-				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Изменена категория\nID: %d\nИмя: %s\nОписание: %s\nАктивна: %v",
-							category.ID, category.Name, category.Description, category.IsActive))
+			value := strings.TrimSpace(data[2])
+			if strings.HasSuffix(value, "%") {
+				percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+				if err != nil || percent <= 0 || percent >= 100 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Скидка должна быть от 0 до 100%")
 					bot.Send(msg)
-				}
-			},
-		},
-		"delete_category": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "delete_category",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Fields(update.Message.CommandArguments())
-				if len(data) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_category category_id")
 					return
 				}
-				categoryID, err := strconv.Atoi(data[0])
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
-					return
-				}
-				categories, err := categoryRepo.SearchCategory(fmt.Sprintf("%d", categoryID))
-				if err != nil || len(categories) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Категория не найдена")
+				sale.DiscountPercent = percent
+			} else {
+				price, err := strconv.ParseFloat(value, 64)
+				if err != nil || price <= 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Цена должна быть положительным числом")
+					bot.Send(msg)
 					return
 				}
-				waitingConfirm[update.Message.Chat.ID] = func() error { return categoryRepo.DeleteCategory(categoryID) }
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
-					"Напишите + если хотите удалить категорию: %s, %s, ID = %d", categories[0].Name, categories[0].Description, categoryID))
-				bot.Send(msg)
-			},
-		},
-		"create_user": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "create_user",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				var password string
-				data := strings.Split(update.Message.CommandArguments(), "|")
-
-				if len(data) < 6 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте\n /create_user telegram_id|telegram_username|first_name|phone|email|role|password\n")
+				if sale.TargetType != models.SaleTargetProduct {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Для категории и бренда укажите скидку в процентах")
 					bot.Send(msg)
 					return
 				}
-				TelegramID, err := strconv.ParseInt(data[0], 10, 64)
+				sale.SalePrice = price
+			}
+
+			var err error
+			for i, field := range []*time.Time{&sale.StartsAt, &sale.EndsAt} {
+				*field, err = time.ParseInLocation("02.01.2006 15:04", strings.TrimSpace(data[i+3]), time.Local)
 				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: telegram_id должен быть числом")
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Некорректная дата: %s", data[i+3]))
 					bot.Send(msg)
 					return
 				}
+			}
+			if !sale.EndsAt.After(sale.StartsAt) {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Окончание распродажи должно быть позже начала")
+				bot.Send(msg)
+				return
+			}
 
-				NewUser := &models.User{
-					TelegramID: TelegramID,
-					Username:   data[1],
-					FirstName:  data[2],
-					Phone:      data[3],
-					Email:      data[4],
-					Role:       data[5],
-				}
-				if len(data) > 6 {
-					password = data[6]
-				}
-
-				err = userRepo.CreateUser(NewUser, password)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания пользователя: %v", err))
-				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Создан пользователь\nID: %d\nTelegramID: %d\nНик: %s\nИмя: %s\nТелефон: %v\nПочта: %s\nРоль: %s\nПароль: %s",
-							NewUser.ID, NewUser.TelegramID, NewUser.Username, NewUser.FirstName,
-							NewUser.Phone, NewUser.Email, NewUser.Role, password))
+			err = saleRepo.CreateSale(ctx, sale)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания распродажи: %v", err))
+				bot.Send(msg)
+				return
+			}
+			if err := saleRepo.ApplySales(ctx); err != nil { //не ждём фоновую задачу если распродажа уже началась
+				log.Printf("Ошибка применения распродаж: %v", err)
+			}
+			recordAudit(ctx, auditRepo, user, "create_sale", models.AuditSale, sale.ID, nil, sale)
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Распродажа создана\n\n"+formatSale(*sale))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "sales",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			sales, err := saleRepo.UpcomingSales(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки распродаж")
+				bot.Send(msg)
+				return
+			}
+			if len(sales) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Нет запланированных распродаж")
+				bot.Send(msg)
+				return
+			}
+			response := "Распродажи\n\n"
+			for _, sale := range sales {
+				response += formatSale(sale) + "\n"
+			}
+			response += "Удалить: /delete_sale id"
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "delete_sale",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			saleID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_sale sale_id")
+				bot.Send(msg)
+				return
+			}
+			err = saleRepo.DeleteSale(ctx, saleID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка удаления распродажи: %v", err))
+				bot.Send(msg)
+				return
+			}
+			if err := saleRepo.ApplySales(ctx); err != nil {
+				log.Printf("Ошибка применения распродаж: %v", err)
+			}
+			recordAudit(ctx, auditRepo, user, "delete_sale", models.AuditSale, saleID, map[string]bool{"deleted": false}, map[string]bool{"deleted": true})
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Распродажа ID %d удалена", saleID))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "create_bundle",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+			if len(data) < 4 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /create_bundle name|description|price|product_id:quantity,product_id:quantity")
+				bot.Send(msg)
+				return
+			}
+			price, err := strconv.ParseFloat(strings.TrimSpace(data[2]), 64)
+			if err != nil || price <= 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Цена должна быть положительным числом")
+				bot.Send(msg)
+				return
+			}
+			bundle := &models.Bundle{
+				Name:        strings.TrimSpace(data[0]),
+				Description: strings.TrimSpace(data[1]),
+				Price:       price,
+				IsActive:    true,
+			}
+			for _, part := range strings.Split(data[3], ",") {
+				fields := strings.Split(strings.TrimSpace(part), ":")
+				productID, err := strconv.Atoi(fields[0])
+				quantity := 1
+				if err == nil && len(fields) > 1 {
+					quantity, err = strconv.Atoi(fields[1])
+				}
+				if err != nil || quantity <= 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Некорректный товар в составе: %s", part))
 					bot.Send(msg)
+					return
 				}
-			},
-		},
-		"users": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "users",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				users, err := userRepo.AllUsers()
+				product, err := productRepo.ProductByID(ctx, productID)
 				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки юзеров")
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Товар с ID %d не найден", productID))
 					bot.Send(msg)
 					return
-				} else {
-					response := "Все юзеры\n\n"
-					for _, user := range users {
-						response += formatUser(user) + "\n"
-					}
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+				}
+				if product.ArchivedAt != nil || !product.IsActive { //набор с таким товаром нельзя было бы купить
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Товар с ID %d в архиве или снят с продажи", productID))
 					bot.Send(msg)
+					return
 				}
-			},
+				bundle.Items = append(bundle.Items, models.BundleItem{ProductID: productID, Quantity: quantity})
+			}
+			if len(bundle.Items) < 2 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "В наборе должно быть хотя бы два товара")
+				bot.Send(msg)
+				return
+			}
+
+			err = bundleRepo.CreateBundle(ctx, bundle)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания набора: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "create_bundle", models.AuditBundle, bundle.ID, nil, bundle)
+			created, err := bundleRepo.SearchBundle(ctx, bundle.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Набор создан, ID %d", bundle.ID))
+			} else {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Набор создан\n\n"+formatBundle(*created))
+			}
+			bot.Send(msg)
 		},
-		"search_user": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "search_user",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				searchQuery := update.Message.CommandArguments()
-				if searchQuery == "" {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите имя пользователя для поиска")
-					waitingUser[update.Message.Chat.ID] = true // поднимаем флаг если не будет поиска 1м сообщением
-				} else {
-					users, err := userRepo.SearchUser(searchQuery)
+	})
+	routes.Command(router.Route{
+		Name:        "bundles",
+		Description: "наборы товаров",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			ShowPagination(ctx, bot, update.Message.Chat.ID, 0, 1,
+				bundleRepo.CountBundles,
+				func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					bundles, err := bundleRepo.PaginateBundles(ctx, limit, offset)
 					if err != nil {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
-						bot.Send(msg)
-						return
-					} else if len(users) == 0 {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" пользователей не найдено")
-						bot.Send(msg)
-						return
-					} else {
-						response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
-						for _, user := range users {
-							response += formatUser(user) + "\n"
-						}
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-						bot.Send(msg)
+						return nil, err
 					}
+					return convertToInterfaceSlice(bundles)
+				},
+				func(data interface{}) string { return formatBundle(data.(models.Bundle)) },
+				"наборы",
+				"bundles",
+				true)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "delete_bundle",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			bundleID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_bundle bundle_id")
+				bot.Send(msg)
+				return
+			}
+			bundle, err := bundleRepo.SearchBundle(ctx, bundleID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Набор не найден")
+				bot.Send(msg)
+				return
+			}
+			if err := setConversation(ctx, update.Message.Chat.ID, StateConfirm, ConfirmPayload{Action: "delete_bundle", ID: int64(bundleID), ActorID: user.ID}); err != nil {
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText))
+				return
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+				"Напишите + если хотите удалить набор: %s, ID = %d\nЗаказы с этим набором сохранятся",
+				bundle.Name, bundleID))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "create_brand",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+
+			if len(data) < 4 || strings.TrimSpace(data[0]) == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /create_brand name|description|country|is_active|logo_url\nlogo_url необязателен")
+				bot.Send(msg)
+				return
+			}
+			is_active, err := strconv.ParseBool(data[3])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("is_active должно быть true/false: %v", err))
+				bot.Send(msg)
+				return
+			}
+			brand := &models.Brand{
+				Name:        strings.TrimSpace(data[0]),
+				Description: data[1],
+				Country:     data[2],
+				IsActive:    is_active,
+			}
+			if len(data) > 4 {
+				brand.LogoURL = data[4]
+			}
+			err = brandRepo.CreateBrand(ctx, brand)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания бренда: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "create_brand", models.AuditBrand, brand.ID, nil, brand)
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Создан бренд\n"+formatBrand(*brand))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:        "brands",
+		Description: "бренды",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			brands, err := brandRepo.AllBrands(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки брендов")
+				bot.Send(msg)
+				return
+			}
+			response := "Все бренды:\n\n"
+			for _, brand := range brands {
+				response += formatBrand(brand) + "\n"
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name: "search_brand",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			searchQuery := strings.TrimSpace(update.Message.CommandArguments())
+			if searchQuery == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /search_brand текст")
+				bot.Send(msg)
+				return
+			}
+			brands, err := brandRepo.SearchBrand(ctx, searchQuery)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
+			} else if len(brands) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" брендов не найдено")
+			} else {
+				response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
+				for _, brand := range brands {
+					response += formatBrand(brand) + "\n"
 				}
-			},
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			}
+			bot.Send(msg)
 		},
-		"update_user": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "update_user",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Split(update.Message.CommandArguments(), "|")
+	})
+	routes.Command(router.Route{
+		Name:         "update_brand",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+
+			if len(data) < 6 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /update_brand id|name|description|country|logo_url|is_active\nНеизменённые поля заполнять символом *")
+				bot.Send(msg)
+				return
+			}
 
-				if len(data) < 7 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте\n /update_user id|telegram_id|telegram_username|first_name|phone|email|role\nНеизменённые поля заполнять символом *")
-					return
-				}
+			brandID, err := strconv.Atoi(strings.TrimSpace(data[0]))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
+				bot.Send(msg)
+				return
+			}
+			brands, err := brandRepo.SearchBrand(ctx, fmt.Sprintf("%d", brandID))
+			if err != nil || len(brands) == 0 || brands[0].ID != brandID {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Бренд не найден")
+				bot.Send(msg)
+				return
+			}
+			brand := &brands[0] //бренд для изменения
+			before := *brand
 
-				users, err := userRepo.SearchUser(data[0])
-				if err != nil || len(users) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
-					return
+			for i, field := range []*string{&brand.Name, &brand.Description, &brand.Country, &brand.LogoURL} {
+				if data[i+1] != "*" {
+					*field = data[i+1]
 				}
-				OldUser := &users[0]
+			}
+			if data[5] != "*" {
+				IsActive, _ := strconv.ParseBool(data[5])
+				brand.IsActive = IsActive
+			}
+
+			err = brandRepo.UpdateBrand(ctx, brand)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка изменения бренда: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "update_brand", models.AuditBrand, brand.ID, before, brand)
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Изменен бренд\n%sАктивен: %v", formatBrand(*brand), brand.IsActive))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "delete_brand",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			brandID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_brand brand_id")
+				bot.Send(msg)
+				return
+			}
+			brands, err := brandRepo.SearchBrand(ctx, fmt.Sprintf("%d", brandID))
+			if err != nil || len(brands) == 0 || brands[0].ID != brandID {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Бренд не найден")
+				bot.Send(msg)
+				return
+			}
+			if err := setConversation(ctx, update.Message.Chat.ID, StateConfirm, ConfirmPayload{Action: "delete_brand", ID: int64(brandID), ActorID: user.ID}); err != nil {
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText))
+				return
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+				"Напишите + если хотите удалить бренд: %s, ID = %d\nБренд и его товары будут перенесены в архив. Восстановить: /restore_brand %d",
+				brands[0].Name, brandID, brandID))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "restore_brand",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			brandID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /restore_brand brand_id")
+				bot.Send(msg)
+				return
+			}
+			err = brandRepo.RestoreBrand(ctx, brandID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка восстановления бренда: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "restore_brand", models.AuditBrand, brandID, map[string]bool{"archived": true}, map[string]bool{"archived": false})
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Бренд ID %d восстановлен вместе с товарами, архивированными вместе с ним", brandID))
+			bot.Send(msg)
+		},
+	})
 
-				NewUser := []*string{&OldUser.Username, &OldUser.FirstName, &OldUser.Phone, &OldUser.Email, &OldUser.Role} //строковые поля обрабатываются
+	routes.Command(router.Route{
+		Name:         "create_category",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
 
-				for i := 2; i < len(data) && i-2 < len(NewUser); i++ {
-					if data[i] != "*" {
-						*NewUser[i-2] = data[i]
-					}
-				}
+			if len(data) < 3 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /create_category name|description|is_active\n")
+				bot.Send(msg)
+				return
+			}
+			is_active, err := strconv.ParseBool(data[2])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("is_active должно быть true/false: %v", err))
+				bot.Send(msg)
+				return
+			}
+			category := &models.Category{ //всего 3 поля которые можно изменять. id,created_at автоматические
+				Name:        data[0],
+				Description: data[1],
+				IsActive:    is_active,
+			}
+			err = categoryRepo.CreateCategory(ctx, category)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания категории: %v", err))
+				bot.Send(msg)
+				return
+			} else {
+				recordAudit(ctx, auditRepo, user, "create_category", models.AuditCategory, category.ID, nil, category)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Создана категория %s\nID: %d\nОписание: %s",
+						category.Name, category.ID, category.Description))
+				bot.Send(msg)
+			}
 
-				if data[1] != "*" { //обработка числового значения TG_ID
-					TelegramID, _ := strconv.ParseInt(data[1], 10, 64)
-					OldUser.TelegramID = TelegramID
+		},
+	})
+	routes.Command(router.Route{
+		Name:        "categories",
+		Description: "все категории",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			categories, err := categoryRepo.AllCategories(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки категорий")
+				bot.Send(msg)
+				return
+			} else {
+				response := "Все категории:\n\n"
+				for _, categories := range categories {
+					response += formatCategory(categories)
 				}
+				response = response + "\n\n"
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+				bot.Send(msg)
+			}
 
-				err = userRepo.UpdateUser(OldUser)
+		},
+	})
+	routes.Command(router.Route{
+		Name: "search_by_category",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			category := update.Message.CommandArguments()
+
+			if category == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите название категории для поиска")
+				if err := setConversation(ctx, update.Message.Chat.ID, StateSearchCategory, nil); err != nil { // ждём запрос следующим сообщением
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText)
+				}
+			} else {
+				products, err := productRepo.ProductsByCategory(ctx, category)
 				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка изменения пользователя: "+err.Error())
-					return
+					fmt.Printf("error: %v", err)
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
+				} else if len(products) == 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+category+" категорий не найдено")
 				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Изменен пользователь\nID: %d\nTelegramID: %d\nНик: %s\nИмя: %s\nТелефон: %v\nПочта: %s\nРоль: %s",
-							OldUser.ID, OldUser.TelegramID, OldUser.Username, OldUser.FirstName,
-							OldUser.Phone, OldUser.Email, OldUser.Role))
-					bot.Send(msg)
+					response := "Результаты поиска по запросу: " + category + "\n\n"
+					for _, product := range products {
+						response += formatProduct(product) + "\n"
+					}
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
 				}
-			},
+				bot.Send(msg)
+			}
 		},
-		"delete_user": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "delete_user",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Fields(update.Message.CommandArguments())
-				if len(data) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_user user_id")
-					bot.Send(msg)
-					return
+	})
+	routes.Command(router.Route{
+		Name: "search_category",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			searchQuery := update.Message.CommandArguments()
+
+			if searchQuery == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите название категории для поиска")
+				if err := setConversation(ctx, update.Message.Chat.ID, StateSearchCategory, nil); err != nil { // ждём запрос следующим сообщением
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText)
 				}
-				userID, err := strconv.Atoi(data[0])
+			} else {
+				categories, err := categoryRepo.SearchCategory(ctx, searchQuery)
 				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
 					bot.Send(msg)
 					return
-				}
-				users, err := userRepo.SearchUser(fmt.Sprintf("%d", userID))
-				if err != nil || len(users) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				} else if len(categories) == 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" категорий не найдено")
 					bot.Send(msg)
 					return
+				} else {
+					response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
+					for _, category := range categories {
+						response += formatCategory(category) + "\n"
+					}
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+					bot.Send(msg)
 				}
-				waitingConfirm[update.Message.Chat.ID] = func() error { return userRepo.DeleteUser(userID) }
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
-					"Напишите + если хотите удалить пользователя: %s, %s, ID = %d", users[0].FirstName, users[0].Username, userID))
-				bot.Send(msg)
-			},
+			}
 		},
-		"start": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "start",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
+	})
+	routes.Command(router.Route{
+		Name:         "update_category",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+
+			if len(data) < 4 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /update_category id|name|description|is_active\nНеизменённые поля заполнять символом *")
+				bot.Send(msg)
+				return
+			}
 
-				delete(SelectProduct, update.Message.Chat.ID)
-				delete(SelectCategory, update.Message.Chat.ID)
-				delete(buyingState, update.Message.Chat.ID)
-				delete(SelectQuantity, update.Message.Chat.ID)
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("%s, добро пожаловать в магазин спортивного питания!\nВаш TG_ID: %s\n\nВыберите нужное действие:", update.Message.From.FirstName, update.Message.From.UserName))
+			categories, err := categoryRepo.SearchCategory(ctx, data[0])
+			if err != nil || len(categories) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Категория не найдена")
+				bot.Send(msg)
+				return
+			}
+			category := &categories[0] //категория для изменения
+			before := *category
 
-				keyboard := tgbotapi.NewInlineKeyboardMarkup(
-					tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData("Все товары", "products"),
-						tgbotapi.NewInlineKeyboardButtonData("Поиск товаров", "search_product"),
-					),
-					tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData("Все пользователи", "users"),
-						tgbotapi.NewInlineKeyboardButtonData("Поиск пользователя", "search_user"),
-					),
-					tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData("Корзина", "cart"),
-						tgbotapi.NewInlineKeyboardButtonData("Категории", "categories"),
-					),
-					tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData("Все категории", "categories"),
-						tgbotapi.NewInlineKeyboardButtonData("Поиск категорий", "search_category"),
-					),
-					tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData("Помощь по командам", "help"),
-					),
-					tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData("Заказы", "orders"),
-						tgbotapi.NewInlineKeyboardButtonData("Создать заказ", "create_order"),
-					),
-					tgbotapi.NewInlineKeyboardRow(
-						tgbotapi.NewInlineKeyboardButtonData("Выбрать товар для покупки", "buyproducts"),
-					),
-				)
-				msg.ReplyMarkup = keyboard
+			for i, field := range []*string{&category.Name, &category.Description} { //строковые поля изменяются
+				if data[i+1] != "*" {
+					*field = data[i+1]
+				}
+			}
+
+			if data[3] != "*" {
+				IsActive, _ := strconv.ParseBool(data[3]) //бул значение
+				category.IsActive = IsActive
+			}
+
+			err = categoryRepo.UpdateCategory(ctx, category)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка изменения категории: %v", err))
 				bot.Send(msg)
-			},
-		},
-		"help": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "help",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
+				return
+			} else {
+				recordAudit(ctx, auditRepo, user, "update_category", models.AuditCategory, category.ID, before, category)
 				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-					"/start - начало\n/products - все товары\n/categories - все категории\n/search [product/user] [текст] - поиск товаров/пользователей\n/help - помощь\n/users - список пользователей")
+					fmt.Sprintf("Изменена категория\nID: %d\nИмя: %s\nОписание: %s\nАктивна: %v",
+						category.ID, category.Name, category.Description, category.IsActive))
 				bot.Send(msg)
-			},
+			}
 		},
-		"cart": {
-			AuthRequired: true,
-			AdminOnly:    false,
-			Action:       "cart",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				cart, err := orderRepo.DetailCart(int64(user.ID))
-				if err != nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки корзины!")
-					bot.Send(msg)
-					return
-				}
-				if cart == nil {
-					msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Нет заказов!")
-					bot.Send(msg)
-					return
-				}
-				response := "Ваша корзина:\n\n"
-				response = formatCart(&cart.Order, cart.Items, productRepo)
-				msg := tgbotapi.NewMessage(update.Message.Chat.ID, response)
+	})
+	routes.Command(router.Route{
+		Name:         "delete_category",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			data := strings.Fields(update.Message.CommandArguments())
+			if len(data) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_category category_id")
+				return
+			}
+			categoryID, err := strconv.Atoi(data[0])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
+				return
+			}
+			categories, err := categoryRepo.SearchCategory(ctx, fmt.Sprintf("%d", categoryID))
+			if err != nil || len(categories) == 0 || categories[0].ID != categoryID {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Категория не найдена")
 				bot.Send(msg)
-			},
-		},
-		"register": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "register",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				args := update.Message.CommandArguments()
+				return
+			}
+			products, carts, err := categoryRepo.DeletionImpact(ctx, categoryID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка подсчёта товаров категории")
+				bot.Send(msg)
+				return
+			}
+			targets, err := categoryRepo.AllCategories(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки категорий")
+				bot.Send(msg)
+				return
+			}
 
-				if args == "" {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Используйте команду: /register password|UserID для регистрации")
-					return
-				}
-				data := strings.Split(args, "|")
-				var password string
-				var TelegramID int64
-				var err error
-				if len(data) == 1 { //введён только пароль
-					password = data[0]
-					TelegramID = update.Message.From.ID
-				} else if len(data) == 2 { //введён и пароль и юзер
-					password = data[0]
-					TelegramID, err = strconv.ParseInt(data[1], 10, 64)
-					if err != nil {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							"Ошибка: Telegram ID должен быть числом")
-						return
-					}
-				} else { //обработка некорректной команды
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте: /register password|TelegramID")
-					return
+			var rows [][]tgbotapi.InlineKeyboardButton //перенос товаров в любую другую категорию или архивация
+			for _, target := range targets {
+				if target.ID == categoryID {
+					continue
 				}
-				users, err := userRepo.SearchUserTGID(TelegramID)
+				rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+					fmt.Sprintf("Перенести в: %s", target.Name),
+					router.Callback("catmove", categoryID, target.ID))))
+			}
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Архивировать вместе с товарами", router.Callback("catarch", categoryID)),
+				tgbotapi.NewInlineKeyboardButtonData("Отмена", router.Callback("purchase", "cancel")),
+			))
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+				"Удаление категории: %s, ID = %d\nАктивных товаров: %d\nОткрытых корзин с товарами категории: %d\n\n"+
+					"Выберите категорию, в которую перенести товары, или архивируйте категорию вместе с товарами (восстановить: /restore_category %d)",
+				categories[0].Name, categoryID, products, carts, categoryID))
+			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "archived",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			products, err := productRepo.ArchivedProducts(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки архива товаров")
+				bot.Send(msg)
+				return
+			}
+			categories, err := categoryRepo.ArchivedCategories(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки архива категорий")
+				bot.Send(msg)
+				return
+			}
+			brands, err := brandRepo.ArchivedBrands(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки архива брендов")
+				bot.Send(msg)
+				return
+			}
+			if len(products) == 0 && len(categories) == 0 && len(brands) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Архив пуст")
+				bot.Send(msg)
+				return
+			}
 
-				if err != nil && !strings.Contains(err.Error(), "user not found") { //ошибка отсутствия юзера
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Ошибка поиска пользователя: %v", err))
-					return
+			response := "Архив\n\n"
+			if len(categories) > 0 {
+				response += "Категории:\n"
+				for _, category := range categories {
+					response += fmt.Sprintf("ID %d: %s (архив с %s)\n",
+						category.ID, category.Name, category.ArchivedAt.Format("02.01.2006 15:04"))
 				}
-
-				if users != nil { //обработка существующего пользователя
-					if users.Password != "" { //вход по паролю
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							"Пользователь уже зарегистрирован. Войдите: /login password|TelegramID")
-						msgToUser := tgbotapi.NewMessage(TelegramID,
-							fmt.Sprintf("Напоминание пароля для аккаунта ID=%d", users.ID))
-						bot.Send(msgToUser)
-					} else { //обновление пароля
-						err = userRepo.UpdatePassword(int(users.ID), password)
-						if err != nil {
-							msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-								fmt.Sprintf("Ошибка установки пароля: %v", err))
-							return
-						}
-						token, err := GenerateToken(users)
-						if err != nil {
-							msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-								fmt.Sprintf("Ошибка генерации токена: %v", err))
-							return
-						}
-						userTokens[update.Message.Chat.ID] = token
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("Пароль установлен для пользователя %s. Сессия активна 10 минут.",
-								users.FirstName))
-
-					}
-				} else { //создание нового пользователя
-					username := update.Message.From.UserName
-					if username == "" {
-						username = strconv.FormatInt(TelegramID, 10)
-					}
-
-					NewUser := &models.User{
-						TelegramID: TelegramID,
-						Username:   username,
-						FirstName:  update.Message.From.FirstName,
-						Phone:      "",
-						Email:      "",
-						Role:       "user",
-					}
-					err = userRepo.CreateUser(NewUser, password)
-					if err != nil {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("Ошибка создания пользователя: %v", err))
-						bot.Send(msg)
-						return
-					}
-					token, err := GenerateToken(NewUser)
-					if err != nil {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("Ошибка генерации токена: %v", err))
-						bot.Send(msg)
-						return
-					}
-
-					userTokens[update.Message.Chat.ID] = token
-					msgToUser := tgbotapi.NewMessage(TelegramID,
-						fmt.Sprintf("Ваш пароль для аккаунта ID=%d установлен", NewUser.ID))
-					bot.Send(msgToUser)
-
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Пользователь %s успешно зарегистрирован. Сессия активна 10 минут.",
-							NewUser.FirstName))
+				response += "Восстановить: /restore_category id\n\n"
+			}
+			if len(brands) > 0 {
+				response += "Бренды:\n"
+				for _, brand := range brands {
+					response += fmt.Sprintf("ID %d: %s (архив с %s)\n",
+						brand.ID, brand.Name, brand.ArchivedAt.Format("02.01.2006 15:04"))
 				}
-			},
+				response += "Восстановить: /restore_brand id\n\n"
+			}
+			if len(products) > 0 {
+				response += "Товары:\n"
+				for _, product := range products {
+					response += fmt.Sprintf("ID %d: %s (%s), категория %d (архив с %s)\n",
+						product.ID, product.Name, product.Flavor, product.Category_id,
+						product.ArchivedAt.Format("02.01.2006 15:04"))
+				}
+				response += "Восстановить: /restore_product id"
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "restore_product",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			productID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /restore_product product_id")
+				bot.Send(msg)
+				return
+			}
+			err = productRepo.RestoreProduct(ctx, productID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка восстановления товара: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "restore_product", models.AuditProduct, productID, map[string]bool{"archived": true}, map[string]bool{"archived": false})
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Товар ID %d восстановлен", productID))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "restore_category",
+		AuthRequired: true,
+		Permission:   models.PermCatalogWrite,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			categoryID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /restore_category category_id")
+				bot.Send(msg)
+				return
+			}
+			err = categoryRepo.RestoreCategory(ctx, categoryID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка восстановления категории: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "restore_category", models.AuditCategory, categoryID, map[string]bool{"archived": true}, map[string]bool{"archived": false})
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Категория ID %d восстановлена вместе с товарами, архивированными вместе с ней", categoryID))
+			bot.Send(msg)
 		},
-		"login": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "login",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
+	})
+	routes.Command(router.Route{
+		Name:         "create_user",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			var password string
+			data := strings.Split(update.Message.CommandArguments(), "|")
+
+			if len(data) < 6 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /create_user telegram_id|telegram_username|first_name|phone|email|role|password\n")
+				bot.Send(msg)
+				return
+			}
+			TelegramID, err := strconv.ParseInt(data[0], 10, 64)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: telegram_id должен быть числом")
+				bot.Send(msg)
+				return
+			}
 
-				args := update.Message.CommandArguments()
-				if args == "" {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Используйте команду:\n/login password|TelegramID")
+			NewUser := &models.User{
+				TelegramID: TelegramID,
+				Username:   data[1],
+				FirstName:  data[2],
+				Phone:      data[3],
+				Email:      data[4],
+				Role:       "user", //роль сотрудника назначается через user_roles
+			}
+			if len(data) > 6 {
+				password = data[6]
+				deleteSecret(bot, update.Message)
+				if err := utils.CheckPasswordStrength(password); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ненадёжный пароль: "+err.Error())
 					bot.Send(msg)
 					return
 				}
-				data := strings.Split(args, "|")
-				var password string
-				var TelegramID int64
-				var err error
-				if len(data) == 1 { // вход в текущий аккаунт
-					password = data[0]
-					TelegramID = update.Message.From.ID
-				} else if len(data) == 2 { // вход в указанный аккаунт
-					password = data[0]
-					TelegramID, err = strconv.ParseInt(data[1], 10, 64)
-					if err != nil {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							"Ошибка: Telegram ID должен быть числом")
-						bot.Send(msg)
+			}
 
-						return
-					}
-				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						"Некорректный формат. Используйте: /login password|TelegramID")
-					bot.Send(msg)
-					return
-				}
-				users, err := userRepo.SearchUserTGID(TelegramID)
-				if err != nil {
-					if strings.Contains(err.Error(), "user not found") {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							"Пользователь не найден. Пройдите регистрацию: /register password|TelegramID")
-						bot.Send(msg)
-						return
+			err = userRepo.CreateUser(ctx, NewUser, password)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка создания пользователя: %v", err))
+			} else {
+				if role := strings.TrimSpace(data[5]); role != "" && role != "user" {
+					if err := grantRole(ctx, roleRepo, user, NewUser.ID, role); err != nil {
+						bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "Роль не назначена: "+roleErrorText(err)))
 					} else {
-						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-							fmt.Sprintf("Ошибка поиска пользователя: %v", err))
-						bot.Send(msg)
-						return
+						NewUser.Role = role
 					}
 				}
-				if users.Password == "" {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отсутствует пароль. Введите\n/register password|TelegramID для установки пароля")
-					bot.Send(msg)
-					return
-				}
-				if !utils.CheckPasswordHash(password, users.Password) {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный пароль!")
-					bot.Send(msg)
-					return
-				}
-				token, err := GenerateToken(users)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Ошибка генерации токена: %v", err))
-					bot.Send(msg)
-					return
-				}
-				userTokens[update.Message.Chat.ID] = token
+				recordAudit(ctx, auditRepo, user, "create_user", models.AuditUser, NewUser.ID, nil, NewUser)
 				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-					fmt.Sprintf("Здравствуйте, %s!\nВаш статус: %s\nID: %d\nСессия активна 10 минут",
-						users.FirstName, users.Role, users.ID))
+					fmt.Sprintf("Создан пользователь\nID: %d\nTelegramID: %d\nНик: %s\nИмя: %s\nТелефон: %v\nПочта: %s\nРоль: %s\nПароль: %s",
+						NewUser.ID, NewUser.TelegramID, NewUser.Username, NewUser.FirstName,
+						NewUser.Phone, NewUser.Email, NewUser.Role, strings.Repeat("*", len([]rune(password)))))
 				bot.Send(msg)
-			},
+			}
 		},
-		"token": {
-			AuthRequired: true,
-			AdminOnly:    false,
-			Action:       "token",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				NewToken, err := GenerateToken(user)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-						fmt.Sprintf("Ошибка генерации токена: %v", err))
-					bot.Send(msg)
-					return
-				}
-
-				userTokens[update.Message.Chat.ID] = NewToken
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-					fmt.Sprintf("Ваш новый токен: %s\nДействует 10 минут", NewToken))
+	})
+	routes.Command(router.Route{
+		Name:         "users",
+		Description:  "список пользователей",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			users, err := userRepo.AllUsers(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки юзеров")
 				bot.Send(msg)
-				msg.ParseMode = "Markdown"
-			},
-		},
-		"logout": {
-			AuthRequired: false,
-			AdminOnly:    false,
-			Action:       "logout",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-
-				delete(userTokens, update.Message.Chat.ID)
-				delete(SelectProduct, update.Message.Chat.ID)
-				delete(SelectCategory, update.Message.Chat.ID)
-				delete(buyingState, update.Message.Chat.ID)
-				delete(SelectQuantity, update.Message.Chat.ID)
-				delete(waitingProduct, update.Message.Chat.ID)
-				delete(waitingUser, update.Message.Chat.ID)
-				delete(waitingCategory, update.Message.Chat.ID)
-				delete(waitingConfirm, update.Message.Chat.ID)
-				delete(paginationState, update.Message.Chat.ID)
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Успешный выхох из программы. Вход: /login")
+				return
+			} else {
+				response := "Все юзеры\n\n"
+				for _, user := range users {
+					response += formatUser(user) + "\n"
+				}
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
 				bot.Send(msg)
-			},
+			}
 		},
-		"create_order": {
-			AuthRequired: true,
-			AdminOnly:    false,
-			Action:       "create_order",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				token, err := GenerateToken(user)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка генерации токена: %v", err))
-					bot.Send(msg)
-
-					return
+	})
+	routes.Command(router.Route{
+		Name:         "search_user",
+		Args:         "текст",
+		Description:  "поиск пользователей",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			searchQuery := update.Message.CommandArguments()
+			if searchQuery == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Укажите имя пользователя для поиска")
+				if err := setConversation(ctx, update.Message.Chat.ID, StateSearchUser, nil); err != nil { // ждём запрос следующим сообщением
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText)
 				}
-				err = CheckPermissions(userRepo, token, 1)
+			} else {
+				users, err := userRepo.SearchUser(ctx, searchQuery)
 				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Недостаточно прав доступа")
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
 					bot.Send(msg)
 					return
-				}
-				order, err := orderRepo.CreateOrder(user.ID)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка создания заказа")
+				} else if len(users) == 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" пользователей не найдено")
 					bot.Send(msg)
 					return
 				} else {
-					response := fmt.Sprintf("Заказ создан\nНомер заказа: %d", order.ID)
+					response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
+					for _, user := range users {
+						response += formatUser(user) + "\n"
+					}
 					msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
 					bot.Send(msg)
 				}
-
-			},
+			}
 		},
-		"orders": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "orders",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-
-				ShowPagination(bot, update.Message.Chat.ID, 0, 1,
-					orderRepo.CountOrders,
-					func(limit, offset int) ([]interface{}, error) {
-						orders, err := orderRepo.PaginateOrders(limit, offset)
-						if err != nil {
-							return nil, err
-						}
-						return convertToInterfaceSlice(orders)
-					},
-					func(data interface{}) string {
-						return formatOrder(data.(models.Order), userRepo)
-					},
-					"заказы",
-					"orders",
-					false)
+	})
+	routes.Command(router.Route{
+		Name:         "update_user",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Split(update.Message.CommandArguments(), "|")
+
+			if len(data) < 7 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте\n /update_user id|telegram_id|telegram_username|first_name|phone|email|role\nНеизменённые поля заполнять символом *")
+				bot.Send(msg)
+				return
+			}
 
-			},
-		},
-		"delete_order": {
-			AuthRequired: true,
-			AdminOnly:    true,
-			Action:       "delete_order",
-			Handler: func(bot *tgbotapi.BotAPI, update tgbotapi.Update,
-				user *models.User, userRepo *repo.UserRepo) {
-				data := strings.Fields(update.Message.CommandArguments())
-				if len(data) == 0 {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_order order_id")
-					bot.Send(msg)
-					return
-				}
-				orderID, err := strconv.Atoi(data[0])
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
-					bot.Send(msg)
-					return
+			users, err := userRepo.SearchUser(ctx, data[0])
+			if err != nil || len(users) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			OldUser := &users[0]
+			before := *OldUser
+
+			if data[6] != "*" && data[6] != OldUser.Role {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Роли меняются командами /grant_role и /revoke_role")
+				bot.Send(msg)
+				return
+			}
+
+			NewUser := []*string{&OldUser.Username, &OldUser.FirstName, &OldUser.Phone, &OldUser.Email} //строковые поля обрабатываются
+
+			for i := 2; i < len(data) && i-2 < len(NewUser); i++ {
+				if data[i] != "*" {
+					*NewUser[i-2] = data[i]
 				}
-				order, err := orderRepo.SearchOrder(orderID)
-				if err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска заказа: "+err.Error())
+			}
+
+			if data[1] != "*" { //обработка числового значения TG_ID
+				TelegramID, err := strconv.ParseInt(data[1], 10, 64)
+				if err != nil || TelegramID <= 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Telegram ID должен быть положительным числом")
 					bot.Send(msg)
 					return
 				}
-				if order == nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Заказ не найден")
-					bot.Send(msg)
-					return
+				if TelegramID != OldUser.TelegramID && OldUser.Role != "user" { //смена Telegram ID сотрудника передаёт его роли другому человеку
+					if err := checkRoleChange(ctx, roleRepo, user, OldUser.Role); err != nil {
+						msg = tgbotapi.NewMessage(update.Message.Chat.ID, roleErrorText(err))
+						bot.Send(msg)
+						return
+					}
 				}
+				OldUser.TelegramID = TelegramID
+			}
 
-				waitingConfirm[update.Message.Chat.ID] = func() error {
-					return orderRepo.DeleteOrder(orderID)
+			err = userRepo.UpdateUser(ctx, OldUser)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка изменения пользователя: "+err.Error())
+				bot.Send(msg)
+				return
+			} else {
+				recordAudit(ctx, auditRepo, user, "update_user", models.AuditUser, OldUser.ID, before, OldUser)
+				if OldUser.TelegramID != before.TelegramID { //прежний владелец Telegram ID не должен остаться в аккаунте
+					if err := sessionStore.RevokeUserSessions(ctx, OldUser.ID); err != nil {
+						log.Printf("Ошибка завершения сессий пользователя %d: %v", OldUser.ID, err)
+					}
 				}
 				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
-					fmt.Sprintf("Напишите + если хотите удалить заказ с ID = %d\nПользователь: %d\nСумма: %.2f\nСтатус: %s",
-						order.ID, order.UserID, order.Amount, order.Status))
+					fmt.Sprintf("Изменен пользователь\nID: %d\nTelegramID: %d\nНик: %s\nИмя: %s\nТелефон: %v\nПочта: %s\nРоль: %s",
+						OldUser.ID, OldUser.TelegramID, OldUser.Username, OldUser.FirstName,
+						OldUser.Phone, OldUser.Email, OldUser.Role))
 				bot.Send(msg)
-			},
+			}
 		},
-	}
-
-	for update := range updates {
-		if update.CallbackQuery != nil {
-			handleCallback(bot, update.CallbackQuery, productRepo, categoryRepo, userRepo, orderRepo)
+	})
+	routes.Command(router.Route{
+		Name:         "delete_user",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Fields(update.Message.CommandArguments())
+			if len(data) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_user user_id")
+				bot.Send(msg)
+				return
+			}
+			userID, err := strconv.Atoi(data[0])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
+				bot.Send(msg)
+				return
+			}
+			target, err := userRepo.UserByID(ctx, int64(userID))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			if target.Role != "user" { //удаление сотрудника снимает его роли
+				if err := checkRoleChange(ctx, roleRepo, user, target.Role); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, roleErrorText(err))
+					bot.Send(msg)
+					return
+				}
+			}
+			if err := setConversation(ctx, update.Message.Chat.ID, StateConfirm, ConfirmPayload{Action: "delete_user", ID: target.ID, ActorID: user.ID}); err != nil {
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText))
+				return
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+				"Напишите + если хотите удалить пользователя: %s, %s, ID = %d\nПерсональные данные будут обезличены, оформленные заказы сохранятся",
+				target.FirstName, target.Username, userID))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "ban",
+		Args:         "user_id|срок|причина",
+		Description:  "блокировка",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.SplitN(update.Message.CommandArguments(), "|", 3)
+			userID, err := strconv.ParseInt(strings.TrimSpace(data[0]), 10, 64)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Отправьте команду в формате /ban user_id|срок|причина\nСрок: 30m, 12h, 7d или «-» - бессрочно")
+				bot.Send(msg)
+				return
+			}
+			var duration time.Duration
+			var reason string
+			if len(data) > 1 {
+				if duration, err = parseBanDuration(data[1]); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: "+err.Error())
+					bot.Send(msg)
+					return
+				}
+			}
+			if len(data) > 2 {
+				reason = strings.TrimSpace(data[2])
+			}
+			target, err := userRepo.UserByID(ctx, userID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			if target.ID == user.ID {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Нельзя заблокировать самого себя")
+				bot.Send(msg)
+				return
+			}
+			if target.Role != "user" { //сотрудника блокирует только тот, кто управляет ролями
+				if err := checkRoleChange(ctx, roleRepo, user, target.Role); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, roleErrorText(err))
+					bot.Send(msg)
+					return
+				}
+			}
+			ban := &models.Ban{TelegramID: target.TelegramID, UserID: target.ID, Reason: reason, BlockedBy: user.ID}
+			if err := banRepo.Ban(ctx, ban, duration); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка блокировки: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "ban", models.AuditUser, target.ID, nil, ban)
+			if err := sessionStore.RevokeUserSessions(ctx, target.ID); err != nil {
+				log.Printf("Ошибка завершения сессий пользователя %d: %v", target.ID, err)
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Пользователь %s (ID %d) заблокирован\n%s", target.FirstName, target.ID, formatBan(*ban)))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "unban",
+		Args:         "user_id",
+		Description:  "разблокировка",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			userID, err := strconv.ParseInt(strings.TrimSpace(update.Message.CommandArguments()), 10, 64)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /unban user_id")
+				bot.Send(msg)
+				return
+			}
+			target, err := userRepo.UserByID(ctx, userID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			if err := banRepo.Unban(ctx, target.TelegramID); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка: %v", err))
+			} else {
+				recordAudit(ctx, auditRepo, user, "unban", models.AuditUser, target.ID,
+					map[string]bool{"banned": true}, map[string]bool{"banned": false})
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Пользователь %s (ID %d) разблокирован", target.FirstName, target.ID))
+				bot.Send(tgbotapi.NewMessage(target.TelegramID, "Ваш аккаунт разблокирован"))
+			}
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "bans",
+		Description:  "заблокированные",
+		AuthRequired: true,
+		Permission:   models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			bans, err := banRepo.ActiveBans(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки блокировок")
+				bot.Send(msg)
+				return
+			}
+			if len(bans) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Заблокированных пользователей нет")
+				bot.Send(msg)
+				return
+			}
+			response := "Заблокированные пользователи\n\n"
+			for _, ban := range bans {
+				response += fmt.Sprintf("Пользователь ID %d (Telegram ID %d)\n%s\n", ban.UserID, ban.TelegramID, formatBan(ban))
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "order_status",
+		Args:         "order_id статус",
+		Description:  "статус заказа",
+		AuthRequired: true,
+		Permission:   models.PermOrdersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Fields(update.Message.CommandArguments())
+			var orderID int
+			var err error
+			if len(data) == 2 {
+				orderID, err = strconv.Atoi(data[0])
+			}
+			if len(data) != 2 || err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Отправьте команду в формате /order_status order_id статус\nСтатусы: shipped - передан в доставку, delivered - доставлен, cancelled - отменён (товары вернутся на склад, баллы - покупателю)")
+				bot.Send(msg)
+				return
+			}
+			before, err := orderRepo.SearchOrder(ctx, orderID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Заказ с ID %d не найден", orderID))
+				bot.Send(msg)
+				return
+			}
+			order, restocked, err := orderRepo.UpdateStatus(ctx, orderID, data[1])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: "+err.Error())
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "order_status", models.AuditOrder, order.ID,
+				map[string]string{"status": before.Status}, map[string]string{"status": order.Status})
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Заказ #%d: %s", order.ID, orderStatusNames[order.Status]))
+			bot.Send(msg)
+
+			if customer, err := userRepo.UserByID(ctx, order.UserID); err == nil && customer.NotifyOrders {
+				bot.Send(tgbotapi.NewMessage(customer.TelegramID,
+					fmt.Sprintf("Ваш заказ #%d %s", order.ID, orderStatusNames[order.Status])))
+			}
+			notifyRestocked(ctx, bot, productRepo, subscriptionRepo, restocked)
+			if order.Status == models.OrderDelivered {
+				points, err := loyaltyRepo.AccrueForOrder(ctx, order.ID)
+				if err != nil {
+					log.Printf("Ошибка начисления баллов за заказ %d: %v", order.ID, err)
+				} else if customer, err := userRepo.UserByID(ctx, order.UserID); err == nil && points > 0 {
+					bot.Send(tgbotapi.NewMessage(customer.TelegramID,
+						fmt.Sprintf("За заказ #%d начислено %.2f бонусных баллов", order.ID, points)))
+				}
+				rewardReferral(ctx, bot, userRepo, referralRepo, order.UserID)
+			}
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "referral",
+		Description:  "ссылка-приглашение и бонусы",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			code, err := referralRepo.ReferralCode(ctx, user.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка создания ссылки-приглашения")
+				bot.Send(msg)
+				return
+			}
+			stats, err := referralRepo.Stats(ctx, user.ID)
+			if err != nil {
+				log.Printf("Ошибка загрузки статистики приглашений: %v", err)
+			}
+			referrerBonus, _ := settingRepo.Setting(ctx, "referral_referrer_bonus")
+			refereeBonus, _ := settingRepo.Setting(ctx, "referral_referee_bonus")
+			minOrder, _ := settingRepo.Setting(ctx, "referral_min_order")
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+				"Ваша ссылка-приглашение:\n%s\n\nКогда приглашённый получит первый заказ от %s руб., вы получите %s бонусов, а он - %s.\n\nПриглашено: %d\nС доставленным заказом: %d\nНачислено бонусов: %.2f",
+				referralLink(bot, code), minOrder, referrerBonus, refereeBonus, stats.Invited, stats.Rewarded, stats.Bonus))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "top_referrers",
+		Args:         "[N]",
+		Description:  "лучшие пригласившие",
+		AuthRequired: true,
+		Permission:   models.PermReportsView,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			limit := 10
+			if args := strings.TrimSpace(update.Message.CommandArguments()); args != "" {
+				n, err := strconv.Atoi(args)
+				if err != nil || n < 1 || n > 50 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /top_referrers [1-50]")
+					bot.Send(msg)
+					return
+				}
+				limit = n
+			}
+			top, err := referralRepo.TopReferrers(ctx, limit)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки отчёта")
+				bot.Send(msg)
+				return
+			}
+			if len(top) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Приглашений пока нет")
+				bot.Send(msg)
+				return
+			}
+			response := "Лучшие пригласившие\n\n"
+			for i, stats := range top {
+				response += fmt.Sprintf("%d. ", i+1) + formatReferrerStats(stats)
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "settings",
+		Args:         "[ключ значение]",
+		Description:  "настройки магазина",
+		AuthRequired: true,
+		Permission:   models.PermSettings,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			if data := strings.Fields(update.Message.CommandArguments()); len(data) > 0 {
+				if len(data) != 2 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /settings ключ значение")
+					bot.Send(msg)
+					return
+				}
+				old, _ := settingRepo.Setting(ctx, data[0])
+				if err := settingRepo.SetSetting(ctx, data[0], data[1]); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: "+err.Error())
+				} else {
+					recordAudit(ctx, auditRepo, user, "settings", models.AuditSetting, data[0],
+						map[string]string{"value": old}, map[string]string{"value": data[1]})
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Настройка %s = %s", data[0], data[1]))
+				}
+				bot.Send(msg)
+				return
+			}
+			settings, err := settingRepo.AllSettings(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки настроек")
+				bot.Send(msg)
+				return
+			}
+			response := "Настройки\n\n"
+			for _, setting := range settings {
+				response += fmt.Sprintf("%s = %s\n%s\n\n", setting.Key, setting.Value, setting.Description)
+			}
+			response += "Изменить: /settings ключ значение"
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "adjust_points",
+		Args:         "user_id|сумма|причина",
+		Description:  "корректировка баллов",
+		AuthRequired: true,
+		Permission:   models.PermLoyalty,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.SplitN(update.Message.CommandArguments(), "|", 3)
+			var userID int64
+			var amount float64
+			var err error
+			if len(data) == 3 {
+				userID, err = strconv.ParseInt(strings.TrimSpace(data[0]), 10, 64)
+				if err == nil {
+					amount, err = strconv.ParseFloat(strings.TrimSpace(data[1]), 64)
+				}
+			}
+			if len(data) != 3 || err != nil || amount == 0 || strings.TrimSpace(data[2]) == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Отправьте команду в формате /adjust_points user_id|сумма|причина\nОтрицательная сумма списывает баллы")
+				bot.Send(msg)
+				return
+			}
+			reason := strings.TrimSpace(data[2])
+			target, err := userRepo.UserByID(ctx, userID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			if err := loyaltyRepo.Adjust(ctx, target.ID, amount, reason, user.ID); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: "+err.Error())
+				bot.Send(msg)
+				return
+			}
+			recordAudit(ctx, auditRepo, user, "adjust_points", models.AuditUser, target.ID, nil,
+				map[string]interface{}{"points": amount, "reason": reason})
+			balance, _ := loyaltyRepo.Balance(ctx, target.ID)
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Баллы пользователя %s (ID %d) изменены на %+.2f\nБаланс: %.2f", target.FirstName, target.ID, amount, balance))
+			bot.Send(msg)
+			bot.Send(tgbotapi.NewMessage(target.TelegramID,
+				fmt.Sprintf("Ваши бонусные баллы изменены на %+.2f: %s\nБаланс: %.2f", amount, reason, balance)))
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "audit",
+		Args:         "[entity=...] [id=...] [admin=...]",
+		Description:  "журнал действий сотрудников",
+		AuthRequired: true,
+		Permission:   models.PermAuditView,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			filter, err := parseAuditFilter(update.Message.CommandArguments())
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: "+err.Error()+
+					"\nОтправьте команду в формате /audit [entity=product|category|brand|bundle|sale|user|order|setting] [id=ID] [admin=user_id]")
+				bot.Send(msg)
+				return
+			}
+			auditFilters.Set(update.Message.Chat.ID, filter)
+			count, paginate := auditPages(auditRepo, update.Message.Chat.ID)
+			ShowPagination(ctx, bot, update.Message.Chat.ID, 0, 1, count, paginate, formatAuditEntry,
+				"записи журнала действий", "audit", false)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "grant_role",
+		AuthRequired: true,
+		Permission:   models.PermRolesManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			userID, role, ok := parseRoleArgs(update.Message.CommandArguments())
+			if !ok {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /grant_role user_id role\nСписок ролей: /roles")
+				bot.Send(msg)
+				return
+			}
+			if err := grantRole(ctx, roleRepo, user, userID, role); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка назначения роли: "+roleErrorText(err))
+			} else {
+				recordAudit(ctx, auditRepo, user, "grant_role", models.AuditUser, userID, nil, map[string]string{"role": role})
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Пользователю %d назначена роль %s", userID, role))
+			}
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "revoke_role",
+		AuthRequired: true,
+		Permission:   models.PermRolesManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			userID, role, ok := parseRoleArgs(update.Message.CommandArguments())
+			if !ok {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /revoke_role user_id role")
+				bot.Send(msg)
+				return
+			}
+			err := checkRoleChange(ctx, roleRepo, user, role)
+			if err == nil {
+				err = roleRepo.RevokeRole(ctx, userID, role)
+			}
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка снятия роли: "+roleErrorText(err))
+			} else {
+				recordAudit(ctx, auditRepo, user, "revoke_role", models.AuditUser, userID, map[string]string{"role": role}, nil)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("У пользователя %d снята роль %s", userID, role))
+			}
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "roles",
+		Description:  "роли сотрудников и их разрешения",
+		AuthRequired: true,
+		Permission:   models.PermRolesManage,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			if args := strings.TrimSpace(update.Message.CommandArguments()); args != "" { //роли и разрешения пользователя
+				userID, err := strconv.ParseInt(args, 10, 64)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /roles [user_id]")
+					bot.Send(msg)
+					return
+				}
+				roles, err := roleRepo.UserRoles(ctx, userID)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки ролей")
+					bot.Send(msg)
+					return
+				}
+				permissions, err := roleRepo.UserPermissions(ctx, userID)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки разрешений")
+					bot.Send(msg)
+					return
+				}
+				if len(roles) == 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("У пользователя %d нет ролей, он покупатель", userID))
+				} else {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Пользователь %d\nРоли: %s\nРазрешения: %s",
+						userID, strings.Join(roles, ", "), strings.Join(permissions, ", ")))
+				}
+				bot.Send(msg)
+				return
+			}
+			roles, err := roleRepo.AllRoles(ctx)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки ролей")
+				bot.Send(msg)
+				return
+			}
+			response := "Роли\n\n"
+			for _, role := range roles {
+				response += formatRole(role) + "\n"
+			}
+			response += "Назначить: /grant_role user_id role\nСнять: /revoke_role user_id role\nРоли пользователя: /roles user_id"
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:        "start",
+		Description: "начало",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+
+			endConversationIn(ctx, update.Message.Chat.ID, StateShopping)
+
+			args := update.Message.CommandArguments()
+			if strings.HasPrefix(args, "product_") { //переход из inline-поиска: t.me/bot?start=product_ID
+				var product *models.Product
+				productID, err := strconv.Atoi(strings.TrimPrefix(args, "product_"))
+				if err == nil {
+					product, err = productRepo.ProductByID(ctx, productID)
+				}
+				if err == nil && product.ArchivedAt == nil && product.IsActive {
+					showProduct(ctx, bot, update.Message.Chat.ID, 0, *product, productRepo, recommendationRepo)
+					return
+				}
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, "Товар не найден или снят с продажи")) //дальше обычное меню
+			}
+			if strings.HasPrefix(args, "ref_") { //переход по приглашению: t.me/bot?start=ref_CODE
+				applyReferral(ctx, bot, update.Message, strings.TrimPrefix(args, "ref_"), userRepo, referralRepo, settingRepo)
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("%s, добро пожаловать в магазин спортивного питания!\nВаш TG_ID: %s\n\nВыберите нужное действие:", update.Message.From.FirstName, update.Message.From.UserName))
+
+			keyboard := tgbotapi.NewInlineKeyboardMarkup(
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Все товары", "products"),
+					tgbotapi.NewInlineKeyboardButtonData("Поиск товаров", "search_product"),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Все пользователи", "users"),
+					tgbotapi.NewInlineKeyboardButtonData("Поиск пользователя", "search_user"),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Корзина", "cart"),
+					tgbotapi.NewInlineKeyboardButtonData("Категории", "categories"),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Все категории", "categories"),
+					tgbotapi.NewInlineKeyboardButtonData("Поиск категорий", "search_category"),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Помощь по командам", "help"),
+					tgbotapi.NewInlineKeyboardButtonData("Профиль", router.Callback("profile", "show")),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Заказы", "orders"),
+					tgbotapi.NewInlineKeyboardButtonData("Создать заказ", "create_order"),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Выбрать товар для покупки", "buyproducts"),
+					tgbotapi.NewInlineKeyboardButtonData("Наборы", "bundles"),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Бренды", "buybrands"),
+				),
+			)
+			msg.ReplyMarkup = keyboard
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:        "help",
+		Description: "помощь",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				helpText(routes))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "cart",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			cart, err := orderRepo.DetailCart(ctx, int64(user.ID))
+			if err != nil {
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки корзины!")
+				bot.Send(msg)
+				return
+			}
+			if cart == nil {
+				msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Нет заказов!")
+				bot.Send(msg)
+				return
+			}
+			response := "Ваша корзина:\n\n"
+			response = formatCart(ctx, &cart.Order, cart.Items, productRepo)
+			suggestions, err := recommendationRepo.ForCart(ctx, cart.Order.ID, RecommendationsLimit)
+			if err != nil {
+				log.Printf("Ошибка загрузки рекомендаций корзины: %v", err)
+			}
+			text, rows := formatRecommendations("Часто покупают вместе с вашими товарами:", suggestions)
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, response+text)
+			if len(rows) > 0 {
+				msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+			}
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name: "register",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			args := update.Message.CommandArguments()
+
+			data := strings.Split(args, "|")
+			var password string
+			var TelegramID int64
+			var err error
+			if len(data) == 1 { //введён только пароль или ничего: покупателю пароль не нужен
+				password = data[0]
+				TelegramID = update.Message.From.ID
+			} else if len(data) == 2 { //старый формат password|TelegramID, ID должен совпадать с отправителем
+				password = data[0]
+				TelegramID, err = strconv.ParseInt(data[1], 10, 64)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						"Ошибка: Telegram ID должен быть числом")
+					bot.Send(msg)
+					return
+				}
+			} else { //обработка некорректной команды
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте: /register [password]")
+				bot.Send(msg)
+				return
+			}
+			if TelegramID != update.Message.From.ID { //иначе можно занять или получить пароль чужого аккаунта
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Зарегистрировать можно только свой Telegram аккаунт: /register [password]")
+				bot.Send(msg)
+				return
+			}
+			if password != "" {
+				defer deleteSecret(bot, update.Message)
+				if err := utils.CheckPasswordStrength(password); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ненадёжный пароль: "+err.Error())
+					bot.Send(msg)
+					return
+				}
+			}
+			users, err := userRepo.SearchUserTGID(ctx, TelegramID)
+
+			if err != nil && !strings.Contains(err.Error(), "user not found") { //ошибка отсутствия юзера
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Ошибка поиска пользователя: %v", err))
+				bot.Send(msg)
+				return
+			}
+
+			if users != nil { //обработка существующего пользователя
+				if users.Password != "" || password == "" { //пароль меняется только через /change_password
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						"Пользователь уже зарегистрирован. Покупатели входят автоматически, остальные: /login password|TelegramID\nСмена пароля: /change_password, восстановление: /reset_password")
+				} else { //первый пароль своего аккаунта
+					err = userRepo.UpdatePassword(ctx, int(users.ID), password)
+					if err != nil {
+						msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+							fmt.Sprintf("Ошибка установки пароля: %v", err))
+						bot.Send(msg)
+						return
+					}
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						fmt.Sprintf("Пароль установлен для пользователя %s. Он нужен для входа в аккаунт из другого Telegram: /login password|%d",
+							users.FirstName, TelegramID))
+				}
+			} else { //создание нового пользователя
+				NewUser := newCustomer(update.Message.From)
+				err = userRepo.CreateUser(ctx, NewUser, password)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						fmt.Sprintf("Ошибка создания пользователя: %v", err))
+					bot.Send(msg)
+					return
+				}
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Пользователь %s успешно зарегистрирован. Вход выполняется автоматически по Telegram ID.",
+						NewUser.FirstName))
+			}
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name: "login",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+
+			args := update.Message.CommandArguments()
+			if args == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Покупатели входят автоматически по Telegram ID.\nВход для администраторов и в другой аккаунт:\n/login password|TelegramID\nЗабыли пароль: /reset_password")
+				bot.Send(msg)
+				return
+			}
+			defer deleteSecret(bot, update.Message)
+			data := strings.Split(args, "|")
+			var password string
+			var TelegramID int64
+			var err error
+			if len(data) == 1 { // вход в текущий аккаунт
+				password = data[0]
+				TelegramID = update.Message.From.ID
+			} else if len(data) == 2 { // вход в указанный аккаунт
+				password = data[0]
+				TelegramID, err = strconv.ParseInt(data[1], 10, 64)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						"Ошибка: Telegram ID должен быть числом")
+					bot.Send(msg)
+
+					return
+				}
+			} else {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Некорректный формат. Используйте: /login password|TelegramID")
+				bot.Send(msg)
+				return
+			}
+			wait, err := loginWait(ctx, loginAttemptRepo, TelegramID, update.Message.Chat.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка проверки попыток входа")
+				bot.Send(msg)
+				return
+			}
+			if wait > 0 { //пароль не проверяется, пока идёт задержка
+				recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, TelegramID, nil, models.LoginBlocked), nil)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Слишком много неудачных попыток входа. Повторите через %s", formatDuration(wait)))
+				bot.Send(msg)
+				return
+			}
+			users, err := userRepo.SearchUserTGID(ctx, TelegramID)
+			if err != nil {
+				if strings.Contains(err.Error(), "user not found") {
+					recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, TelegramID, nil, models.LoginNotFound), nil)
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						"Пользователь не найден. Пройдите регистрацию: /register password")
+					bot.Send(msg)
+					return
+				} else {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						fmt.Sprintf("Ошибка поиска пользователя: %v", err))
+					bot.Send(msg)
+					return
+				}
+			}
+			if ban, err := banRepo.ActiveBan(ctx, users.TelegramID); err != nil || ban != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Вход в аккаунт недоступен: аккаунт заблокирован")
+				bot.Send(msg)
+				return
+			}
+			if TelegramID == update.Message.From.ID && users.Role == "user" { //свой аккаунт покупателя - без пароля
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Вход не требуется: покупатели авторизуются по Telegram ID. Вернуться в свой аккаунт: /logout")
+				bot.Send(msg)
+				return
+			}
+			if users.Password == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отсутствует пароль. Введите\n/register password для установки пароля")
+				bot.Send(msg)
+				return
+			}
+			if !utils.CheckPasswordHash(password, users.Password) {
+				recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, TelegramID, users, models.LoginPassword), users)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный пароль!")
+				bot.Send(msg)
+				return
+			}
+			if users.TOTPEnabled { //сессия создаётся после ввода кода
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Введите код из приложения-аутентификатора (действует %s)", formatDuration(totpLoginTimeout)))
+				if err := setConversation(ctx, update.Message.Chat.ID, StateLoginTOTP, PendingLogin{UserID: users.ID}); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText)
+				}
+				bot.Send(msg)
+				return
+			}
+			recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, TelegramID, users, models.LoginOK), users)
+			completeLogin(ctx, bot, update.Message.Chat.ID, update.Message.From.ID, users)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "token",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			session, err := sessionStore.ChatSession(ctx, update.Message.Chat.ID, update.Message.From.ID)
+			if err != nil || session == nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Нет активной сессии. Выполните /login")
+				bot.Send(msg)
+				return
+			}
+			NewToken, err := GenerateToken(user, session)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Ошибка генерации токена: %v", err))
+				bot.Send(msg)
+				return
+			}
+
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Ваш токен: %s\nДействует пока активна сессия, не дольше чем до %s",
+					NewToken, session.ExpiresAt.Format("02.01.2006 15:04")))
+			bot.Send(msg)
+			msg.ParseMode = "Markdown"
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "change_password",
+		Description:  "смена пароля",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			args := update.Message.CommandArguments()
+			if args != "" {
+				defer deleteSecret(bot, update.Message)
+			}
+			account, err := userRepo.UserByID(ctx, user.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			data := strings.Split(args, "|")
+			var oldPassword, newPassword string
+			if len(data) == 2 {
+				oldPassword, newPassword = data[0], data[1]
+			} else if len(data) == 1 && args != "" && account.Password == "" { //первый пароль аккаунта
+				newPassword = data[0]
+			} else {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Отправьте команду в формате /change_password текущий|новый\nПароль: не короче %d символов, буквы и цифры\nЗабыли пароль: /reset_password",
+						utils.MinPasswordLength))
+				bot.Send(msg)
+				return
+			}
+			if account.Password != "" { //подбор текущего пароля ограничен как и /login
+				wait, err := loginWait(ctx, loginAttemptRepo, account.TelegramID, update.Message.Chat.ID)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка проверки попыток входа")
+					bot.Send(msg)
+					return
+				}
+				if wait > 0 {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+						fmt.Sprintf("Слишком много неудачных попыток. Повторите через %s", formatDuration(wait)))
+					bot.Send(msg)
+					return
+				}
+				if !utils.CheckPasswordHash(oldPassword, account.Password) {
+					recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, account.TelegramID, account, models.LoginPassword), account)
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный текущий пароль")
+					bot.Send(msg)
+					return
+				}
+			}
+			if err := utils.CheckPasswordStrength(newPassword); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ненадёжный пароль: "+err.Error())
+				bot.Send(msg)
+				return
+			}
+			if newPassword == oldPassword {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Новый пароль совпадает с текущим")
+				bot.Send(msg)
+				return
+			}
+			if err := userRepo.UpdatePassword(ctx, int(account.ID), newPassword); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка смены пароля: %v", err))
+				bot.Send(msg)
+				return
+			}
+			finishPasswordChange(ctx, bot, account, update.Message.Chat.ID)
+		},
+	})
+	routes.Command(router.Route{
+		Name:        "reset_password",
+		Description: "восстановление пароля",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			args := strings.TrimSpace(update.Message.CommandArguments())
+			data := strings.Split(args, "|")
+			TelegramID := update.Message.From.ID
+			var err error
+
+			if len(data) == 1 { //запрос кода: он приходит владельцу аккаунта в Telegram
+				if args != "" {
+					TelegramID, err = strconv.ParseInt(args, 10, 64)
+					if err != nil {
+						msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: Telegram ID должен быть числом")
+						bot.Send(msg)
+						return
+					}
+				}
+				//ответ одинаковый, чтобы по нему нельзя было узнать, есть ли аккаунт
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Если аккаунт существует, код восстановления отправлен его владельцу в Telegram. Он действует %s.\nВведите /reset_password код|новый_пароль",
+						formatDuration(repo.PasswordResetTTL)))
+				account, err := userRepo.SearchUserTGID(ctx, TelegramID)
+				if err != nil {
+					bot.Send(msg)
+					return
+				}
+				code, err := utils.GenerateNumericCode(6)
+				if err != nil {
+					log.Printf("Ошибка генерации кода восстановления: %v", err)
+					bot.Send(msg)
+					return
+				}
+				reset := &models.PasswordReset{UserID: account.ID, RequestedIn: update.Message.Chat.ID}
+				if err := passwordResetRepo.CreateReset(ctx, reset, code); err != nil {
+					log.Printf("Код восстановления для %d не создан: %v", account.ID, err)
+					bot.Send(msg)
+					return
+				}
+				text := fmt.Sprintf("Код восстановления пароля: %s\nДействует до %s.\nВведите /reset_password %s|новый_пароль",
+					code, reset.ExpiresAt.Format("15:04"), code)
+				if update.Message.Chat.ID != account.TelegramID {
+					text = fmt.Sprintf("Код запрошен из чата %d. Если это были не вы, никому его не сообщайте.\n", update.Message.Chat.ID) + text
+				}
+				if _, err := bot.Send(tgbotapi.NewMessage(account.TelegramID, text)); err != nil {
+					log.Printf("Не удалось отправить код восстановления пользователю %d: %v", account.ID, err)
+				}
+				bot.Send(msg)
+				return
+			}
+
+			defer deleteSecret(bot, update.Message)
+			var code, newPassword string
+			if len(data) == 2 {
+				code, newPassword = data[0], data[1]
+			} else if len(data) == 3 {
+				TelegramID, err = strconv.ParseInt(data[0], 10, 64)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка: Telegram ID должен быть числом")
+					bot.Send(msg)
+					return
+				}
+				code, newPassword = data[1], data[2]
+			} else {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Отправьте команду в формате /reset_password [TelegramID] для получения кода\nили /reset_password [TelegramID|]код|новый_пароль")
+				bot.Send(msg)
+				return
+			}
+			if err := utils.CheckPasswordStrength(newPassword); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ненадёжный пароль: "+err.Error())
+				bot.Send(msg)
+				return
+			}
+			wait, err := loginWait(ctx, loginAttemptRepo, TelegramID, update.Message.Chat.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка проверки попыток входа")
+				bot.Send(msg)
+				return
+			}
+			if wait > 0 {
+				recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, TelegramID, nil, models.LoginBlocked), nil)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Слишком много неудачных попыток. Повторите через %s", formatDuration(wait)))
+				bot.Send(msg)
+				return
+			}
+
+			account, err := userRepo.SearchUserTGID(ctx, TelegramID)
+			var reset *models.PasswordReset
+			if err == nil {
+				reset, err = passwordResetRepo.ActiveReset(ctx, account.ID)
+			}
+			if err != nil || reset == nil || !utils.CheckPasswordHash(strings.TrimSpace(code), reset.CodeHash) {
+				if reset != nil {
+					passwordResetRepo.FailAttempt(ctx, reset.ID)
+				}
+				recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, TelegramID, account, models.LoginBadReset), account)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный или истёкший код. Новый код: /reset_password")
+				bot.Send(msg)
+				return
+			}
+			if err := passwordResetRepo.CompleteReset(ctx, reset, newPassword); err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка смены пароля: %v", err))
+				bot.Send(msg)
+				return
+			}
+			recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, TelegramID, account, models.LoginReset), account)
+			finishPasswordChange(ctx, bot, account, update.Message.Chat.ID)
+		},
+	})
+	routes.Command(router.Route{
+		Name: "logout",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+
+			if err := sessionStore.RevokeChatSessions(ctx, update.Message.Chat.ID, update.Message.From.ID); err != nil {
+				log.Printf("Ошибка завершения сессии: %v", err)
+			}
+			endConversation(ctx, update.Message.Chat.ID)
+			paginationState.Delete(update.Message.Chat.ID)
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Успешный выхох из программы. Вход: /login")
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:        "cancel",
+		Description: "отмена текущего действия",
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			conversation := currentConversation(ctx, update.Message.Chat.ID)
+			if conversation == nil || conversation.Expired() {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Нет действия для отмены")
+			} else {
+				endConversation(ctx, update.Message.Chat.ID)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Действие отменено")
+			}
+			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true) //убирает кнопку отправки номера, если она была
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "two_factor",
+		Description:  "второй фактор для входа по паролю",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			account, err := userRepo.UserByID(ctx, user.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			if account.Password == "" {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Второй фактор защищает вход по паролю. Сначала установите пароль: /register password")
+				bot.Send(msg)
+				return
+			}
+			args := strings.Fields(update.Message.CommandArguments())
+
+			switch {
+			case account.TOTPEnabled && len(args) == 2 && args[0] == "off": //отключение требует действующий код
+				if !utils.CheckTOTP(account.TOTPSecret, args[1]) {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный код")
+				} else if err := userRepo.SetTOTP(ctx, account.ID, "", false); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка отключения второго фактора")
+				} else {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Второй фактор отключён")
+				}
+			case account.TOTPEnabled:
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					"Второй фактор включён. Отключить: /two_factor off код")
+			case len(args) == 1 && account.TOTPSecret != "": //подтверждение настройки первым кодом
+				if !utils.CheckTOTP(account.TOTPSecret, args[0]) {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный код, попробуйте ещё раз")
+				} else if err := userRepo.SetTOTP(ctx, account.ID, account.TOTPSecret, true); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка включения второго фактора")
+				} else {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Второй фактор включён. При входе по паролю бот запросит код")
+				}
+			default: //новый секрет
+				secret, err := utils.GenerateTOTPSecret()
+				if err == nil {
+					err = userRepo.SetTOTP(ctx, account.ID, secret, false)
+				}
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка настройки второго фактора")
+					bot.Send(msg)
+					return
+				}
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf(
+					"Добавьте ключ в приложение-аутентификатор:\n%s\n\nКлюч: %s\nЗатем подтвердите: /two_factor код",
+					utils.TOTPURI(bot.Self.UserName, account.Username, secret), secret))
+			}
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "profile",
+		Description:  "профиль: имя, телефон, почта, адрес, уведомления",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			account, err := userRepo.UserByID(ctx, user.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+				return
+			}
+			showProfile(ctx, bot, update.Message.Chat.ID, 0, account, loyaltyRepo)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "my_data",
+		Description:  "выгрузка ваших данных",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data, err := collectPersonalData(ctx, user.ID, userRepo, orderRepo, roleRepo, subscriptionRepo, loginAttemptRepo, loyaltyRepo)
+			if err != nil {
+				log.Printf("Ошибка выгрузки данных пользователя %d: %v", user.ID, err)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка выгрузки данных")
+				bot.Send(msg)
+				return
+			}
+			content, err := json.MarshalIndent(data, "", "  ")
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка выгрузки данных")
+				bot.Send(msg)
+				return
+			}
+			document := tgbotapi.NewDocument(update.Message.Chat.ID, tgbotapi.FileBytes{
+				Name:  fmt.Sprintf("my_data_%d.json", user.ID),
+				Bytes: content,
+			})
+			document.Caption = "Ваши данные: профиль, роли, заказы, сессии, подписки и журнал входов"
+			bot.Send(document)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "delete_account",
+		Description:  "удаление аккаунта",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			if err := setConversation(ctx, update.Message.Chat.ID, StateConfirm, ConfirmPayload{Action: "delete_account", ID: user.ID, ActorID: user.ID}); err != nil {
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText))
+				return
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				"Напишите + если хотите удалить аккаунт.\nИмя, телефон, почта, адрес, пароль, роли, сессии, подписки и журнал входов будут удалены. "+
+					"Оформленные заказы сохранятся для учёта без ваших данных.\nВыгрузить данные перед удалением: /my_data")
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "sessions",
+		Description:  "активные сессии",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			userID := user.ID
+			if args := strings.TrimSpace(update.Message.CommandArguments()); args != "" { //с правом users.manage можно посмотреть сессии другого пользователя
+				if err := Authorize(ctx, roleRepo, user, models.PermUsersManage); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, forbiddenText(err, models.PermUsersManage))
+					bot.Send(msg)
+					return
+				}
+				var err error
+				userID, err = strconv.ParseInt(args, 10, 64)
+				if err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /sessions [user_id]")
+					bot.Send(msg)
+					return
+				}
+			}
+			showSessions(ctx, bot, update.Message.Chat.ID, update.Message.From.ID, 0, userID)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "create_order",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			order, err := orderRepo.CreateOrder(ctx, user.ID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка создания заказа")
+				bot.Send(msg)
+				return
+			} else {
+				response := fmt.Sprintf("Заказ создан\nНомер заказа: %d", order.ID)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+				bot.Send(msg)
+			}
+
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "orders",
+		AuthRequired: true,
+		Permission:   models.PermOrdersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+
+			ShowPagination(ctx, bot, update.Message.Chat.ID, 0, 1,
+				orderRepo.CountOrders,
+				func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					orders, err := orderRepo.PaginateOrders(ctx, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(orders)
+				},
+				func(data interface{}) string {
+					return formatOrder(ctx, data.(models.Order), userRepo)
+				},
+				"заказы",
+				"orders",
+				false)
+
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "delete_order",
+		AuthRequired: true,
+		Permission:   models.PermOrdersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update, user := ctx.Bot, ctx.Update, ctx.User
+			var msg tgbotapi.MessageConfig
+			data := strings.Fields(update.Message.CommandArguments())
+			if len(data) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /delete_order order_id")
+				bot.Send(msg)
+				return
+			}
+			orderID, err := strconv.Atoi(data[0])
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "ID должно быть числом")
+				bot.Send(msg)
+				return
+			}
+			order, err := orderRepo.SearchOrder(ctx, orderID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска заказа: "+err.Error())
+				bot.Send(msg)
+				return
+			}
+			if order == nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Заказ не найден")
+				bot.Send(msg)
+				return
+			}
+			if order.Status != models.OrderNew && order.Status != models.OrderCancelled { //иначе пропали бы списанные товары и баллы
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+					fmt.Sprintf("Удалить можно только корзину или отменённый заказ. Сначала отмените его: /order_status %d cancelled", order.ID))
+				bot.Send(msg)
+				return
+			}
+
+			if err := setConversation(ctx, update.Message.Chat.ID, StateConfirm, ConfirmPayload{Action: "delete_order", ID: int64(orderID), ActorID: user.ID}); err != nil {
+				bot.Send(tgbotapi.NewMessage(update.Message.Chat.ID, conversationErrorText))
+				return
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID,
+				fmt.Sprintf("Напишите + если хотите удалить заказ с ID = %d\nПользователь: %d\nСумма: %.2f\nСтатус: %s",
+					order.ID, order.UserID, order.Amount, order.Status))
+			bot.Send(msg)
+		},
+	})
+	routes.Command(router.Route{
+		Name:         "order_details",
+		AuthRequired: true,
+		Permission:   models.PermOrdersManage,
+		Handler: func(ctx *router.Context) {
+			bot, update := ctx.Bot, ctx.Update
+			var msg tgbotapi.MessageConfig
+			orderID, err := strconv.Atoi(strings.TrimSpace(update.Message.CommandArguments()))
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отправьте команду в формате /order_details order_id")
+				bot.Send(msg)
+				return
+			}
+			order, err := orderRepo.SearchOrder(ctx, orderID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Заказ не найден")
+				bot.Send(msg)
+				return
+			}
+			items, err := orderRepo.OrderItems(ctx, orderID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки позиций заказа")
+				bot.Send(msg)
+				return
+			}
+			components, err := orderRepo.OrderItemComponents(ctx, orderID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка загрузки состава наборов")
+				bot.Send(msg)
+				return
+			}
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, formatOrderDetails(ctx, order, items, components, userRepo))
+			bot.Send(msg)
+		},
+	})
+
+	// удаления, ожидающие подтверждения +. Права проверяются ещё раз при подтверждении:
+	// между запросом и ответом могли смениться роли или пройти перезапуск
+	confirmActions := map[string]struct {
+		Permission string
+		Run        func(ctx context.Context, user *models.User, id int64) error
+	}{
+		"delete_product": {
+			Permission: models.PermCatalogWrite,
+			Run: func(ctx context.Context, user *models.User, id int64) error {
+				if err := productRepo.ArchiveProduct(ctx, int(id)); err != nil {
+					return err
+				}
+				recordAudit(ctx, auditRepo, user, "delete_product", models.AuditProduct, id, map[string]bool{"archived": false}, map[string]bool{"archived": true})
+				return nil
+			},
+		},
+		"delete_bundle": {
+			Permission: models.PermCatalogWrite,
+			Run: func(ctx context.Context, user *models.User, id int64) error {
+				if err := bundleRepo.ArchiveBundle(ctx, int(id)); err != nil {
+					return err
+				}
+				recordAudit(ctx, auditRepo, user, "delete_bundle", models.AuditBundle, id, map[string]bool{"archived": false}, map[string]bool{"archived": true})
+				return nil
+			},
+		},
+		"delete_brand": {
+			Permission: models.PermCatalogWrite,
+			Run: func(ctx context.Context, user *models.User, id int64) error {
+				if err := brandRepo.ArchiveBrand(ctx, int(id)); err != nil {
+					return err
+				}
+				recordAudit(ctx, auditRepo, user, "delete_brand", models.AuditBrand, id, map[string]bool{"archived": false}, map[string]bool{"archived": true})
+				return nil
+			},
+		},
+		"delete_user": {
+			Permission: models.PermUsersManage,
+			Run: func(ctx context.Context, user *models.User, id int64) error {
+				target, err := userRepo.UserByID(ctx, id)
+				if err != nil {
+					return errors.New("пользователь не найден")
+				}
+				if target.Role != "user" { //удаление сотрудника снимает его роли
+					if err := checkRoleChange(ctx, roleRepo, user, target.Role); err != nil {
+						return errors.New(roleErrorText(err))
+					}
+				}
+				if err := userRepo.AnonymizeUser(ctx, target.ID); err != nil {
+					return err
+				}
+				recordAudit(ctx, auditRepo, user, "delete_user", models.AuditUser, target.ID,
+					map[string]bool{"deleted": false}, map[string]bool{"deleted": true})
+				return nil
+			},
+		},
+		"delete_account": {
+			Run: func(ctx context.Context, user *models.User, id int64) error {
+				return userRepo.AnonymizeUser(ctx, user.ID)
+			},
+		},
+		"delete_order": {
+			Permission: models.PermOrdersManage,
+			Run: func(ctx context.Context, user *models.User, id int64) error {
+				order, err := orderRepo.SearchOrder(ctx, int(id))
+				if err != nil {
+					return err
+				}
+				if order == nil {
+					return errors.New("заказ не найден")
+				}
+				if err := orderRepo.DeleteOrder(ctx, order.ID); err != nil {
+					return err
+				}
+				recordAudit(ctx, auditRepo, user, "delete_order", models.AuditOrder, order.ID, order, nil)
+				return nil
+			},
+		},
+	}
+	runConfirmAction := func(ctx context.Context, update tgbotapi.Update, pending ConfirmPayload) error {
+		confirmAction, ok := confirmActions[pending.Action]
+		if !ok {
+			return fmt.Errorf("неизвестное действие %s", pending.Action)
+		}
+		user, err := AuthorizeUpdate(ctx, update, userRepo)
+		if err != nil {
+			return errors.New(authErrorText(err))
+		}
+		if user.ID != pending.ActorID {
+			return errors.New("удаление запрошено из другого аккаунта")
+		}
+		if err := Authorize(ctx, roleRepo, user, confirmAction.Permission); err != nil {
+			return errors.New(forbiddenText(err, confirmAction.Permission))
+		}
+		return confirmAction.Run(ctx, user, pending.ID)
+	}
+
+	handleUpdate := func(update tgbotapi.Update) { //обработка одного обновления, вызывается из воркера чата
+		ctx, cancel := context.WithTimeout(baseCtx, updateTimeout)
+		defer cancel()
+		if blockedUpdate(ctx, bot, update, banRepo) { //проверка блокировки до любых обработчиков
+			return
+		}
+		if update.InlineQuery != nil {
+			handleInlineQuery(ctx, bot, update.InlineQuery, productRepo)
+			return
+		}
+		if update.CallbackQuery != nil {
+			routes.HandleCallback(ctx, bot, update)
+			return
+		}
+		if update.Message == nil {
+			return
+		}
+
+		if update.Message.IsCommand() {
+			if routes.HandleCommand(ctx, bot, update) {
+				return
+			}
+			action := update.Message.Text
+			log.Printf("user_id: %d, username: %s, action: %s", update.Message.From.ID, update.Message.From.FirstName, action)
+			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Неизвестная команда")
+			bot.Send(msg)
+			return
+		}
+
+		if update.Message == nil {
+			return
+		}
+
+		userID := update.Message.From.ID
+		userName := update.Message.From.FirstName
+		if update.Message.From.UserName != "" {
+			userName = update.Message.From.UserName
+		}
+		var msg tgbotapi.MessageConfig
+		var action string
+
+		conversation := currentConversation(ctx, update.Message.Chat.ID)
+		if conversation != nil && conversation.Expired() { //ответ пришёл после таймаута шага
+			endConversation(ctx, update.Message.Chat.ID)
+			if expired := conversationStates[conversation.State].Expired; expired != "" && update.Message.Contact == nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, expired)
+				msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+				bot.Send(msg)
+				log.Printf("user_id: %d, username: %s, action: %s expired", userID, userName, conversation.State)
+				return
+			}
+			conversation = nil
+		}
+		profile, inProfile := conversationPayload[ProfilePayload](conversation, StateProfileInput)
+
+		if pending, ok := conversationPayload[PendingLogin](conversation, StateLoginTOTP); ok { //код второго фактора после пароля
+			action = "login totp code"
+			endConversation(ctx, update.Message.Chat.ID)
+			user, err := userRepo.UserByID(ctx, pending.UserID)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Пользователь не найден")
+				bot.Send(msg)
+			} else if !utils.CheckTOTP(user.TOTPSecret, update.Message.Text) {
+				recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, user.TelegramID, user, models.LoginTOTP), user)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Неверный код. Повторите /login")
+				bot.Send(msg)
+			} else {
+				recordLogin(ctx, bot, loginAttemptRepo, loginAttempt(update.Message, user.TelegramID, user, models.LoginOK), user)
+				completeLogin(ctx, bot, update.Message.Chat.ID, update.Message.From.ID, user)
+			}
+		} else if update.Message.Contact != nil { //телефон для профиля из кнопки «Отправить мой номер»
+			action = "profile phone contact"
+			if inProfile {
+				endConversation(ctx, update.Message.Chat.ID)
+			}
+			user, err := AuthorizeUpdate(ctx, update, userRepo)
+			if err != nil {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, authErrorText(err))
+				bot.Send(msg)
+				return
+			}
+			contact := update.Message.Contact
+			verified := false
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Телефон сохранён")
+			if contact.UserID != update.Message.From.ID { //пересланный чужой контакт
+				msg.Text = "Можно указать только свой номер: нажмите кнопку «Отправить мой номер»"
+			} else if user.TelegramID != update.Message.From.ID { //вход в чужой аккаунт по паролю
+				msg.Text = "Телефон подтверждается только из Telegram владельца аккаунта"
+			} else if err := userRepo.SetPhone(ctx, user.ID, normalizePhone(contact.PhoneNumber), true); err != nil {
+				msg.Text = "Ошибка сохранения телефона"
+			} else {
+				verified = true
+			}
+			msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+			bot.Send(msg)
+			if account, err := userRepo.UserByID(ctx, user.ID); err == nil {
+				showProfile(ctx, bot, update.Message.Chat.ID, 0, account, loyaltyRepo)
+			}
+			if verified { //бонус за приглашение ждал подтверждения телефона
+				rewardReferral(ctx, bot, userRepo, referralRepo, user.ID)
+			}
+		} else if inProfile && !update.Message.IsCommand() { //новое значение поля профиля
+			field := profile.Field
+			action = "profile edit " + field
+			if field == "phone" { //телефон принимается только контактом
+				if update.Message.Text == "Отмена" {
+					endConversation(ctx, update.Message.Chat.ID)
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Телефон не изменён")
+					msg.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+				} else {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Нажмите кнопку «Отправить мой номер» или «Отмена»")
+				}
+				bot.Send(msg)
+				return
+			}
+			user, err := AuthorizeUpdate(ctx, update, userRepo)
+			if err != nil {
+				endConversation(ctx, update.Message.Chat.ID)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, authErrorText(err))
+				bot.Send(msg)
+				return
+			}
+			account, err := userRepo.UserByID(ctx, user.ID)
+			if err == nil {
+				err = saveProfileField(ctx, userRepo, account, field, update.Message.Text)
+			}
+			if err != nil { //ожидание ввода остаётся, можно отправить исправленное значение
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка: %v. Повторите ввод или /profile", err))
+				bot.Send(msg)
+				return
+			}
+			endConversation(ctx, update.Message.Chat.ID)
+			showProfile(ctx, bot, update.Message.Chat.ID, 0, account, loyaltyRepo)
+		} else if conversation != nil && conversation.State == StateSearchProduct && !update.Message.IsCommand() { //проверка на ожидание для возможности поиска товара 2м сообщением
+			searchQuery := update.Message.Text
+			action = "search product 2nd msg"
+
+			products, err := productRepo.SearchProduct(ctx, searchQuery)
+			if err != nil {
+				log.Printf("Ошибка: %v", err)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
+				bot.Send(msg)
+				return
+			} else if len(products) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" товаров не найдено")
+				bot.Send(msg)
+				return
+			} else {
+				response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
+				for _, product := range products {
+					response += formatProduct(product) + "\n"
+				}
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+				bot.Send(msg)
+			}
+			endConversation(ctx, update.Message.Chat.ID) // сбрасываем ожидание
+		} else if conversation != nil && conversation.State == StateSearchUser && !update.Message.IsCommand() { //проверка на ожидание для возможности поиска юзера 2м сообщением
+			searchQuery := update.Message.Text
+			action = "search user 2nd msg"
+
+			users, err := userRepo.SearchUser(ctx, searchQuery)
+			if err != nil {
+				log.Printf("Ошибка: %v", err)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
+				bot.Send(msg)
+				return
+			} else if len(users) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" пользователей не найдено")
+				bot.Send(msg)
+				return
+			} else {
+				response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
+				for _, user := range users {
+					response += formatUser(user) + "\n"
+				}
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+				bot.Send(msg)
+			}
+			endConversation(ctx, update.Message.Chat.ID) // сбрасываем ожидание
+		} else if conversation != nil && conversation.State == StateSearchCategory && !update.Message.IsCommand() { //проверка на ожидание для возможности поиска категории 2м сообщением
+			searchQuery := update.Message.Text
+			action = "search category 2nd msg"
+
+			categories, err := categoryRepo.SearchCategory(ctx, searchQuery)
+			if err != nil {
+				log.Printf("Ошибка: %v", err)
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
+				bot.Send(msg)
+				return
+			} else if len(categories) == 0 {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" категорий  не найдено")
+				bot.Send(msg)
+				return
+			} else {
+				response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
+				for _, categories := range categories {
+					response += formatCategory(categories) + "\n"
+				}
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
+				bot.Send(msg)
+			}
+			endConversation(ctx, update.Message.Chat.ID) // сбрасываем ожидание
+		} else if pending, ok := conversationPayload[ConfirmPayload](conversation, StateConfirm); ok {
+			endConversation(ctx, update.Message.Chat.ID) //подтверждение одноразовое
+			confirm := update.Message.Text
+			if confirm == "+" {
+				action = "confirm " + pending.Action
+				if err := runConfirmAction(ctx, update, pending); err != nil {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка удаления: %v", err))
+					bot.Send(msg)
+					return
+				} else {
+					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Успешное удаление!")
+					bot.Send(msg)
+				}
+			} else {
+				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отмена удаления")
+				bot.Send(msg)
+			}
+			return
+		} else {
+			action = update.Message.Text
+			msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Недоступная команда. повторите попытку")
+			bot.Send(msg)
+		}
+		/*если непонятно что ввелось можно обработать как введённое сообщение
+		if action == "" {
+		action = update.Message.Chat.ID
+		}*/
+		log.Printf("user_id: %d, username: %s, action: %s ", userID, userName, action) //лог введённой команды
+	}
+
+	dispatchUpdates(updates, handleUpdate)
+}
+
+const updateWorkers = 8                // обработчики обновлений, работающие параллельно
+const updateWorkerBuffer = 100         // очередь обновлений одного обработчика
+const updateTimeout = 30 * time.Second // время на обработку одного обновления, включая запросы к БД
+
+func updateChatKey(update tgbotapi.Update) int64 { //чат, в пределах которого важен порядок обновлений
+	if chat := update.FromChat(); chat != nil {
+		return chat.ID
+	}
+	if from := update.SentFrom(); from != nil {
+		return from.ID
+	}
+	return 0
+}
+
+// dispatchUpdates раздаёт обновления воркерам по чату: разные чаты обрабатываются
+// параллельно, а обновления одного чата всегда попадают к одному воркеру и идут по порядку.
+// Возвращается, когда канал обновлений закрыт и все воркеры закончили работу
+func dispatchUpdates(updates tgbotapi.UpdatesChannel, handle func(tgbotapi.Update)) {
+	var wg sync.WaitGroup
+	queues := make([]chan tgbotapi.Update, updateWorkers)
+	for i := range queues {
+		queues[i] = make(chan tgbotapi.Update, updateWorkerBuffer)
+		wg.Add(1)
+		go func(queue <-chan tgbotapi.Update) {
+			defer wg.Done()
+			for update := range queue {
+				handleSafely(handle, update)
+			}
+		}(queues[i])
+	}
 
+	for update := range updates {
+		key := updateChatKey(update)
+		if key < 0 { //у групп и каналов отрицательные ID
+			key = -key
 		}
-		if update.Message == nil {
-			continue
+		queues[key%updateWorkers] <- update
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+}
+
+// handleSafely - паника в одном обновлении не должна останавливать воркер: его очередь обслуживает много чатов.
+// router.Recover покрывает только команды и кнопки, а не текст диалогов, inline запросы и блокировки
+func handleSafely(handle func(tgbotapi.Update), update tgbotapi.Update) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Паника при обработке обновления %d: %v\n%s", update.UpdateID, err, debug.Stack())
 		}
+	}()
+	handle(update)
+}
 
-		if update.Message.IsCommand() {
-			command := update.Message.Command()
+func formatUser(user models.User) string { // вывод юзера
+	roleText := "Покупатель"
+	if user.Role != "user" && user.Role != "" { //основная роль сотрудника, все роли - в /roles
+		roleText = user.Role
+	}
+	return fmt.Sprintf("%s: %s(ID=%d)\nТелеграмм: %s (ID=%d)\nИмя: %s\nТелефон: %s\nПочта: %s\nДата регистрации: %s\n",
+		roleText, user.Username, user.ID, user.Username, user.TelegramID, user.FirstName,
+		user.Phone, user.Email, user.CreatedAt.Format("02.01.2006"))
+}
+func formatRole(role models.Role) string { // вывод роли с разрешениями
+	permissions := "нет"
+	if len(role.Permissions) > 0 {
+		permissions = strings.Join(role.Permissions, ", ")
+	}
+	return fmt.Sprintf("%s - %s\nРазрешения: %s\n", role.Name, role.Description, permissions)
+}
+func formatProduct(product models.Product) string { // вывод товара
+	return fmt.Sprintf("ID: %d\nНазвание: %s\nОписание: %s\nЦена: %s\n%sКоличество: %d\nКатегория ID: %d\nВес: %.2f\nВкус: %s\nБренд: %s\nПорций: %d\nАктивен: %v\nСоздан: %s\n\n",
+		product.ID, product.Name, product.Description, formatPrice(product), formatUnitPrices(product), product.Quantity,
+		product.Category_id, product.Weight, product.Flavor, product.Brand, product.Servings,
+		product.IsActive, product.CreatedAt.Format("02.01.2006 15:04"))
+}
 
-			if handler, ok := commandHandlers[command]; ok {
-				var user *models.User
-				var err error
-				action := commandHandlers[command].Action
-				log.Printf("user_id: %d, username: %s, action: %s", update.Message.From.ID, update.Message.From.FirstName, action)
+func pricePerServing(product models.Product) float64 { //0 если количество порций не указано
+	if product.Servings <= 0 {
+		return 0
+	}
+	return product.CurrentPrice() / float64(product.Servings)
+}
 
-				if handler.AuthRequired {
-					token := GetTokenFromUpdate(update)
-					if token == "" {
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Сначала выполните /login")
-						bot.Send(msg)
-						continue
-					}
+func pricePer100g(product models.Product) float64 { //вес товара хранится в граммах
+	if product.Weight <= 0 {
+		return 0
+	}
+	return product.CurrentPrice() / product.Weight * 100
+}
 
-					user, err = AuthenticateUser(token, userRepo)
-					if err != nil {
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Токен недействителен. Выполните /login")
-						bot.Send(msg)
-						continue
-					}
-					if handler.AdminOnly && user.Role != "admin" {
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Доступ только для администраторов")
-						bot.Send(msg)
-						continue
-					}
-				}
+func formatPrice(product models.Product) string { //во время распродажи старая цена зачёркнута
+	if product.CurrentPrice() < product.Price {
+		return fmt.Sprintf("%s %.2f", strikethrough(fmt.Sprintf("%.2f", product.Price)), product.CurrentPrice())
+	}
+	return fmt.Sprintf("%.2f", product.Price)
+}
 
-				handler.Handler(bot, update, user, userRepo)
-				continue
-			}
-			action := update.Message.Text
-			log.Printf("user_id: %d, username: %s, action: %s", update.Message.From.ID, update.Message.From.FirstName, action)
-			msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Неизвестная команда")
-			bot.Send(msg)
-			continue
+func strikethrough(text string) string { //зачёркивание без разметки: комбинируемый символ после каждой буквы
+	var result strings.Builder
+	for _, r := range text {
+		result.WriteRune(r)
+		result.WriteRune('\u0336')
+	}
+	return result.String()
+}
+
+func formatUnitPrices(product models.Product) string { //цена за порцию и за 100 г
+	var response string
+	if perServing := pricePerServing(product); perServing > 0 {
+		response += fmt.Sprintf("Цена за порцию: %.2f руб.\n", perServing)
+	}
+	if per100g := pricePer100g(product); per100g > 0 {
+		response += fmt.Sprintf("Цена за 100 г: %.2f руб.\n", per100g)
+	}
+	return response
+}
+
+func formatNutrition(nutrition models.Nutrition) string { //пищевая ценность порции
+	return fmt.Sprintf("\nПорция: %.0f г\nБелки: %.1f г\nУглеводы: %.1f г\nЖиры: %.1f г\nКалорийность: %.0f ккал\n",
+		nutrition.ServingSize, nutrition.Protein, nutrition.Carbs, nutrition.Fat, nutrition.Calories)
+}
+
+const (
+	CompareMin      = 2  //минимум товаров для сравнения
+	CompareMax      = 4  //максимум товаров для сравнения
+	CompareColWidth = 10 //ширина колонки таблицы сравнения
+)
+
+func formatComparison(products []models.Product, nutritions []*models.Nutrition) string { //таблица сравнения товаров, товары в колонках
+	cell := func(value string) string {
+		runes := []rune(value)
+		if len(runes) > CompareColWidth {
+			runes = append(runes[:CompareColWidth-1], '…')
+		}
+		return fmt.Sprintf("%-*s", CompareColWidth+1, string(runes))
+	}
+	money := func(value float64) string {
+		if value <= 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.2f", value)
+	}
+	nutritionValue := func(nutrition *models.Nutrition, value func(models.Nutrition) float64) string {
+		if nutrition == nil {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f", value(*nutrition))
+	}
+
+	rows := []struct {
+		title string
+		value func(i int) string
+	}{
+		{"ID", func(i int) string { return strconv.Itoa(products[i].ID) }},
+		{"Товар", func(i int) string { return products[i].Name }},
+		{"Вкус", func(i int) string { return products[i].Flavor }},
+		{"Бренд", func(i int) string { return products[i].Brand }},
+		{"Цена", func(i int) string { return money(products[i].CurrentPrice()) }},
+		{"Вес, г", func(i int) string { return fmt.Sprintf("%.0f", products[i].Weight) }},
+		{"Порций", func(i int) string { return strconv.Itoa(products[i].Servings) }},
+		{"Руб/порция", func(i int) string { return money(pricePerServing(products[i])) }},
+		{"Руб/100 г", func(i int) string { return money(pricePer100g(products[i])) }},
+		{"Порция, г", func(i int) string {
+			return nutritionValue(nutritions[i], func(n models.Nutrition) float64 { return n.ServingSize })
+		}},
+		{"Белки", func(i int) string {
+			return nutritionValue(nutritions[i], func(n models.Nutrition) float64 { return n.Protein })
+		}},
+		{"Углеводы", func(i int) string {
+			return nutritionValue(nutritions[i], func(n models.Nutrition) float64 { return n.Carbs })
+		}},
+		{"Жиры", func(i int) string {
+			return nutritionValue(nutritions[i], func(n models.Nutrition) float64 { return n.Fat })
+		}},
+		{"Ккал", func(i int) string {
+			return nutritionValue(nutritions[i], func(n models.Nutrition) float64 { return n.Calories })
+		}},
+	}
+
+	var table strings.Builder
+	for _, row := range rows {
+		table.WriteString(cell(row.title))
+		for i := range products {
+			table.WriteString(cell(row.value(i)))
+		}
+		table.WriteString("\n")
+	}
+	return "Сравнение товаров\n<pre>" + html.EscapeString(table.String()) + "</pre>"
+}
+
+func formatProductCard(product models.Product) string { // карточка товара для отправки в другие чаты
+	return fmt.Sprintf("%s (%s)\n%s\n\nБренд: %s\nВес: %.0f г\nПорций: %d\nЦена: %s руб.\n%s",
+		product.Name, product.Flavor, product.Description, product.Brand,
+		product.Weight, product.Servings, formatPrice(product), formatUnitPrices(product))
+}
+
+func formatSale(sale models.Sale) string { //вывод распродажи
+	targets := map[string]string{
+		models.SaleTargetProduct:  "товар ID",
+		models.SaleTargetCategory: "категория ID",
+		models.SaleTargetBrand:    "бренд ID",
+	}
+	value := fmt.Sprintf("-%.0f%%", sale.DiscountPercent)
+	if sale.SalePrice > 0 {
+		value = fmt.Sprintf("цена %.2f руб.", sale.SalePrice)
+	}
+	status := "запланирована"
+	if sale.IsActive {
+		status = "идёт"
+	}
+	return fmt.Sprintf("Распродажа #%d (%s)\n%s %s: %s\nС %s по %s\n",
+		sale.ID, status, targets[sale.TargetType], sale.Target, value,
+		sale.StartsAt.Format("02.01.2006 15:04"), sale.EndsAt.Format("02.01.2006 15:04"))
+}
+
+func formatBundle(bundle models.Bundle) string { //вывод набора с составом
+	response := fmt.Sprintf("ID: %d\nНабор: %s\nЦена: %.2f руб.\nВ наличии: %d\n", bundle.ID, bundle.Name, bundle.Price, bundle.Available)
+	if bundle.Description != "" {
+		response += bundle.Description + "\n"
+	}
+	for _, item := range bundle.Items {
+		response += fmt.Sprintf("  • %s (%s) x%d\n", item.ProductName, item.Flavor, item.Quantity)
+	}
+	return response
+}
+
+func formatDuration(d time.Duration) string { //длительность для пользователя: 2 ч 30 мин
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	switch {
+	case hours > 0 && minutes > 0:
+		return fmt.Sprintf("%d ч %d мин", hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%d ч", hours)
+	case minutes > 0:
+		return fmt.Sprintf("%d мин", minutes)
+	default:
+		return fmt.Sprintf("%d сек", int(math.Ceil(d.Seconds())))
+	}
+}
+
+func formatSession(session models.Session, current bool) string { //вывод сессии
+	response := fmt.Sprintf("Сессия #%d", session.ID)
+	if current {
+		response += " (текущая)"
+	}
+	return response + fmt.Sprintf("\nЧат: %d\nTelegram ID: %d\nВход: %s\nАктивность: %s\nИстекает: %s\n",
+		session.ChatID, session.FromID, session.CreatedAt.Format("02.01.2006 15:04"),
+		session.LastSeenAt.Format("02.01.2006 15:04"), session.ExpiresAt.Format("02.01.2006 15:04"))
+}
+
+func showSessions(ctx context.Context, bot *tgbotapi.BotAPI, ChatID, FromID int64, MessageID int, userID int64) { //список сессий с кнопками завершения
+	sessions, err := sessionStore.UserSessions(ctx, userID)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(ChatID, "Ошибка загрузки сессий"))
+		return
+	}
+	currentID := CurrentSessionID(ctx, ChatID, FromID)
+
+	response := "Активные сессии\n\n"
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, session := range sessions {
+		response += formatSession(session, session.ID == currentID) + "\n"
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("Завершить #%d", session.ID),
+			router.Callback("session", "revoke", userID, session.ID))))
+	}
+	if len(sessions) == 0 {
+		response = "Нет активных сессий"
+	} else if len(sessions) > 1 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"Завершить все", router.Callback("session", "revokeall", userID))))
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if MessageID != 0 {
+		msg := tgbotapi.NewEditMessageText(ChatID, MessageID, response)
+		if len(rows) > 0 {
+			msg.ReplyMarkup = &keyboard
+		}
+		bot.Send(msg)
+	} else {
+		msg := tgbotapi.NewMessage(ChatID, response)
+		if len(rows) > 0 {
+			msg.ReplyMarkup = keyboard
+		}
+		bot.Send(msg)
+	}
+}
+
+const (
+	maxNameLength    = 100
+	maxEmailLength   = 100
+	maxAddressLength = 500
+)
+
+var profileFields = map[string]string{ //поле профиля и подсказка для ввода
+	"name":    "Введите имя",
+	"email":   "Введите адрес электронной почты или «-», чтобы удалить",
+	"address": "Введите адрес доставки: город, улица, дом, квартира. «-» - удалить адрес",
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "вкл"
+	}
+	return "выкл"
+}
+
+func formatProfile(user *models.User, balance float64) string { //вывод профиля покупателя
+	phone, email, address := "не указан", "не указана", "не указан"
+	if user.Phone != "" {
+		phone = user.Phone
+		if user.PhoneVerified {
+			phone += " (подтверждён)"
+		}
+	}
+	if user.Email != "" {
+		email = user.Email
+	}
+	if user.Address != "" {
+		address = user.Address
+	}
+	return fmt.Sprintf("Профиль\n\nИмя: %s\nТелефон: %s\nПочта: %s\nАдрес доставки: %s\nБонусные баллы: %.2f\n\nУведомления\nО поступлении товара: %s\nО заказах: %s",
+		user.FirstName, phone, email, address, balance, onOff(user.NotifyRestock), onOff(user.NotifyOrders))
+}
+
+func showProfile(ctx context.Context, bot *tgbotapi.BotAPI, ChatID int64, MessageID int, user *models.User, loyaltyRepo *repo.LoyaltyRepo) { //профиль с кнопками изменения
+	balance, err := loyaltyRepo.Balance(ctx, user.ID)
+	if err != nil {
+		log.Printf("Ошибка загрузки баллов пользователя %d: %v", user.ID, err)
+	}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Имя", router.Callback("profile", "edit", "name")),
+			tgbotapi.NewInlineKeyboardButtonData("Телефон", router.Callback("profile", "edit", "phone")),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Почта", router.Callback("profile", "edit", "email")),
+			tgbotapi.NewInlineKeyboardButtonData("Адрес", router.Callback("profile", "edit", "address")),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("О поступлении: "+onOff(user.NotifyRestock), router.Callback("profile", "toggle", "restock")),
+			tgbotapi.NewInlineKeyboardButtonData("О заказах: "+onOff(user.NotifyOrders), router.Callback("profile", "toggle", "orders")),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("История баллов", router.Callback("profile", "points")),
+		),
+	)
+	if MessageID != 0 {
+		msg := tgbotapi.NewEditMessageText(ChatID, MessageID, formatProfile(user, balance))
+		msg.ReplyMarkup = &keyboard
+		bot.Send(msg)
+		return
+	}
+	msg := tgbotapi.NewMessage(ChatID, formatProfile(user, balance))
+	msg.ReplyMarkup = keyboard
+	bot.Send(msg)
+}
+
+func validateEmail(email string) error {
+	if len(email) > maxEmailLength {
+		return fmt.Errorf("адрес почты длиннее %d символов", maxEmailLength)
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return fmt.Errorf("некорректный адрес почты")
+	}
+	domain := email[strings.LastIndex(email, "@")+1:]
+	if !strings.Contains(domain, ".") || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return fmt.Errorf("некорректный домен почты")
+	}
+	return nil
+}
+
+func normalizePhone(phone string) string { //Telegram присылает номер без + в некоторых клиентах
+	phone = strings.TrimSpace(phone)
+	if !strings.HasPrefix(phone, "+") {
+		phone = "+" + phone
+	}
+	return phone
+}
+
+// saveProfileField проверяет и сохраняет значение поля профиля, введённое пользователем
+func saveProfileField(ctx context.Context, userRepo *repo.UserRepo, user *models.User, field, value string) error {
+	value = strings.TrimSpace(value)
+	switch field {
+	case "name":
+		if value == "" || utf8.RuneCountInString(value) > maxNameLength {
+			return fmt.Errorf("имя должно быть от 1 до %d символов", maxNameLength)
+		}
+		user.FirstName = value
+	case "email":
+		if value == "-" {
+			value = ""
+		} else if err := validateEmail(value); err != nil {
+			return err
 		}
+		user.Email = value
+	case "address":
+		if value == "-" {
+			value = ""
+		} else if utf8.RuneCountInString(value) > maxAddressLength {
+			return fmt.Errorf("адрес длиннее %d символов", maxAddressLength)
+		}
+		user.Address = value
+	default:
+		return fmt.Errorf("неизвестное поле %s", field)
+	}
+	return userRepo.UpdateProfile(ctx, user)
+}
+
+// collectPersonalData собирает всё, что магазин хранит о пользователе
+func collectPersonalData(ctx context.Context, userID int64, userRepo *repo.UserRepo, orderRepo *repo.OrderRepo, roleRepo *repo.RoleRepo,
+	subscriptionRepo *repo.SubscriptionRepo, loginAttemptRepo *repo.LoginAttemptRepo, loyaltyRepo *repo.LoyaltyRepo) (*models.PersonalData, error) {
+	user, err := userRepo.UserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := &models.PersonalData{ExportedAt: time.Now(), Profile: *user}
+
+	if data.Roles, err = roleRepo.UserRoles(ctx, userID); err != nil {
+		return nil, err
+	}
+	orders, err := orderRepo.UserOrder(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range orders {
+		items, err := orderRepo.OrderItems(ctx, order.ID)
+		if err != nil {
+			return nil, err
+		}
+		data.Orders = append(data.Orders, models.OrderWithItems{Order: order, Items: items})
+	}
+	if data.Sessions, err = sessionStore.SessionHistory(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.Subscriptions, err = subscriptionRepo.UserSubscriptions(ctx, userID); err != nil {
+		return nil, err
+	}
+	if data.LoginAttempts, err = loginAttemptRepo.UserAttempts(ctx, userID, user.TelegramID); err != nil {
+		return nil, err
+	}
+	if data.Loyalty, err = loyaltyRepo.History(ctx, userID, 0); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func parseBanDuration(value string) (time.Duration, error) { //срок блокировки: 30m, 12h, 7d; пусто или «-» - бессрочно
+	value = strings.TrimSpace(value)
+	if value == "" || value == "-" || value == "0" {
+		return 0, nil
+	}
+	if days, found := strings.CutSuffix(value, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("некорректный срок %s", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("некорректный срок %s", value)
+	}
+	return duration, nil
+}
+
+func formatBan(ban models.Ban) string { //вывод блокировки
+	until := "бессрочно"
+	if ban.ExpiresAt != nil {
+		until = "до " + ban.ExpiresAt.Format("02.01.2006 15:04")
+	}
+	reason := ban.Reason
+	if reason == "" {
+		reason = "не указана"
+	}
+	return fmt.Sprintf("Блокировка: %s\nПричина: %s\n", until, reason)
+}
 
-		if update.Message == nil {
-			continue
-		}
+var orderStatusNames = map[string]string{ //статусы заказа для покупателя
+	models.OrderNew:       "корзина",
+	models.OrderConfirmed: "подтверждён",
+	models.OrderShipped:   "передан в доставку",
+	models.OrderDelivered: "доставлен",
+	models.OrderCancelled: "отменён",
+}
 
-		userID := update.Message.From.ID
-		userName := update.Message.From.FirstName
-		if update.Message.From.UserName != "" {
-			userName = update.Message.From.UserName
-		}
-		var msg tgbotapi.MessageConfig
-		var action string
+func referralLink(bot *tgbotapi.BotAPI, code string) string { //ссылка-приглашение: t.me/bot?start=ref_CODE
+	return fmt.Sprintf("https://t.me/%s?start=ref_%s", bot.Self.UserName, code)
+}
 
-		if waitingProduct[update.Message.Chat.ID] && !update.Message.IsCommand() { //проверка на ожидание для возможности поиска товара 2м сообщением
-			searchQuery := update.Message.Text
-			action = "search product 2nd msg"
+// applyReferral регистрирует нового покупателя, пришедшего по ссылке приглашения, и связывает его с пригласившим.
+// Приглашение засчитывается только для Telegram, у которого ещё нет аккаунта
+func applyReferral(ctx context.Context, bot *tgbotapi.BotAPI, message *tgbotapi.Message, code string,
+	userRepo *repo.UserRepo, referralRepo *repo.ReferralRepo, settingRepo *repo.SettingRepo) {
+	referrer, err := referralRepo.ReferrerByCode(ctx, code)
+	if err != nil || referrer == nil {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Ссылка-приглашение недействительна"))
+		return
+	}
+	if referrer.TelegramID == message.From.ID {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Нельзя пригласить самого себя"))
+		return
+	}
+	if _, err := userRepo.SearchUserTGID(ctx, message.From.ID); err == nil {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Приглашение действует только для новых покупателей"))
+		return
+	}
+	user := newCustomer(message.From)
+	if err := userRepo.CreateUser(ctx, user); err != nil {
+		log.Printf("Ошибка регистрации приглашённого %d: %v", message.From.ID, err)
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Приглашение не засчитано: аккаунт уже существует"))
+		return
+	}
+	referral := &models.Referral{ReferrerID: referrer.ID, RefereeID: user.ID, RefereeTelegramID: message.From.ID}
+	if err := referralRepo.Link(ctx, referral); err != nil {
+		bot.Send(tgbotapi.NewMessage(message.Chat.ID, "Приглашение не засчитано: "+err.Error()))
+		return
+	}
+	bonus, _ := settingRepo.Setting(ctx, "referral_referee_bonus")
+	minOrder, _ := settingRepo.Setting(ctx, "referral_min_order")
+	bot.Send(tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf(
+		"Вы пришли по приглашению %s.\nПосле доставки первого заказа от %s руб. вы получите %s бонусов, а пригласивший - свой бонус.\nДля начисления подтвердите телефон в /profile",
+		referrer.FirstName, minOrder, bonus)))
+	bot.Send(tgbotapi.NewMessage(referrer.TelegramID,
+		fmt.Sprintf("По вашей ссылке зарегистрировался %s. Бонус начислится после доставки его первого заказа", message.From.FirstName)))
+}
 
-			products, err := productRepo.SearchProduct(searchQuery)
-			if err != nil {
-				log.Printf("Ошибка: %v", err)
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
-				bot.Send(msg)
-				return
-			} else if len(products) == 0 {
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" товаров не найдено")
-				bot.Send(msg)
-				return
-			} else {
-				response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
-				for _, product := range products {
-					response += formatProduct(product) + "\n"
-				}
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-				bot.Send(msg)
-			}
-			waitingProduct[update.Message.Chat.ID] = false // сбрасываем ожидание
-		} else if waitingUser[update.Message.Chat.ID] && !update.Message.IsCommand() { //проверка на ожидание для возможности поиска юзера 2м сообщением
-			searchQuery := update.Message.Text
-			action = "search user 2nd msg"
+// rewardReferral начисляет бонусы по приглашению пользователя, если условия выполнены, и сообщает обоим
+func rewardReferral(ctx context.Context, bot *tgbotapi.BotAPI, userRepo *repo.UserRepo, referralRepo *repo.ReferralRepo, userID int64) {
+	referral, err := referralRepo.Reward(ctx, userID)
+	if err != nil {
+		log.Printf("Ошибка начисления реферального бонуса пользователю %d: %v", userID, err)
+		return
+	}
+	if referral == nil || referral.Status != models.ReferralRewarded {
+		return
+	}
+	if referee, err := userRepo.UserByID(ctx, referral.RefereeID); err == nil {
+		bot.Send(tgbotapi.NewMessage(referee.TelegramID, fmt.Sprintf(
+			"Заказ #%d доставлен. Вам начислено %.2f бонусов за первый заказ по приглашению", referral.OrderID, referral.RefereeBonus)))
+	}
+	if referrer, err := userRepo.UserByID(ctx, referral.ReferrerID); err == nil {
+		bot.Send(tgbotapi.NewMessage(referrer.TelegramID, fmt.Sprintf(
+			"Приглашённый вами покупатель получил первый заказ. Вам начислено %.2f бонусов", referral.ReferrerBonus)))
+	}
+}
+
+// recordAudit записывает действие сотрудника в журнал; ошибка записи не отменяет само действие
+func recordAudit(ctx context.Context, auditRepo *repo.AuditRepo, actor *models.User, action, entity string, entityID interface{}, before, after interface{}) {
+	changes, err := models.AuditDiff(before, after)
+	if err != nil {
+		log.Printf("Ошибка сравнения полей для журнала: %v", err)
+		return
+	}
+	entry := &models.AuditEntry{Action: action, Entity: entity, EntityID: fmt.Sprint(entityID), Changes: changes}
+	if actor != nil {
+		entry.ActorID = actor.ID
+	}
+	if err := auditRepo.Record(ctx, entry); err != nil {
+		log.Printf("Действие %s не записано в журнал: %v", action, err)
+	}
+}
 
-			users, err := userRepo.SearchUser(searchQuery)
+func parseAuditFilter(args string) (models.AuditFilter, error) { //фильтры вида entity=product id=5 admin=3
+	var filter models.AuditFilter
+	for _, part := range strings.Fields(args) {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return filter, fmt.Errorf("некорректный фильтр %s", part)
+		}
+		switch key {
+		case "entity":
+			filter.Entity = value
+		case "id":
+			filter.EntityID = value
+		case "admin":
+			actorID, err := strconv.ParseInt(value, 10, 64)
 			if err != nil {
-				log.Printf("Ошибка: %v", err)
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
-				bot.Send(msg)
-				return
-			} else if len(users) == 0 {
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" пользователей не найдено")
-				bot.Send(msg)
-				return
-			} else {
-				response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
-				for _, user := range users {
-					response += formatUser(user) + "\n"
-				}
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-				bot.Send(msg)
+				return filter, fmt.Errorf("admin должен быть ID пользователя")
 			}
-			waitingUser[update.Message.Chat.ID] = false // сбрасываем ожидание
-		} else if waitingCategory[update.Message.Chat.ID] && !update.Message.IsCommand() { //проверка на ожидание для возможности поиска категории 2м сообщением
-			searchQuery := update.Message.Text
-			action = "search category 2nd msg"
+			filter.ActorID = actorID
+		default:
+			return filter, fmt.Errorf("неизвестный фильтр %s", key)
+		}
+	}
+	return filter, nil
+}
 
-			categories, err := categoryRepo.SearchCategory(searchQuery)
+func auditPages(auditRepo *repo.AuditRepo, ChatID int64) (func(ctx context.Context) (int, error), func(ctx context.Context, limit, offset int) ([]interface{}, error)) { //подсчёт и страницы журнала по фильтру чата
+	filter := auditFilters.Get(ChatID)
+	return func(ctx context.Context) (int, error) { return auditRepo.CountEntries(ctx, filter) },
+		func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+			entries, err := auditRepo.PaginateEntries(ctx, filter, limit, offset)
 			if err != nil {
-				log.Printf("Ошибка: %v", err)
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Ошибка поиска")
-				bot.Send(msg)
-				return
-			} else if len(categories) == 0 {
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "По запросу: "+searchQuery+" категорий  не найдено")
-				bot.Send(msg)
-				return
-			} else {
-				response := "Результаты поиска по запросу: " + searchQuery + "\n\n"
-				for _, categories := range categories {
-					response += formatCategory(categories) + "\n"
-				}
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, response)
-				bot.Send(msg)
-			}
-			waitingCategory[update.Message.Chat.ID] = false // сбрасываем ожидание
-		} else if deleteFunc := waitingConfirm[update.Message.Chat.ID]; deleteFunc != nil {
-			confirm := update.Message.Text
-			if confirm == "+" {
-				if err := deleteFunc(); err != nil {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, fmt.Sprintf("Ошибка удаления: %v", err))
-					bot.Send(msg)
-					return
-				} else {
-					msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Успешное удаление!")
-					bot.Send(msg)
-				}
-				waitingConfirm[update.Message.Chat.ID] = nil
-			} else {
-				msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Отмена удаления")
-				waitingConfirm[update.Message.Chat.ID] = nil
-				bot.Send(msg)
+				return nil, err
 			}
-			continue
-		} else if update.Message.IsCommand() { //отбираем все после /
-			command := update.Message.Command()
+			return convertToInterfaceSlice(entries)
+		}
+}
 
-			if handler, ok := commandHandlers[command]; ok {
-				var user *models.User
-				var err error
-				if handler.AuthRequired {
-					token := GetTokenFromUpdate(update)
-					if token == "" {
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Сначала выполните /login")
-						bot.Send(msg)
-						continue
-					}
+func formatAuditValue(value interface{}) string { //значение поля для вывода, длинные строки обрезаются
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return "-"
+	case string:
+		text = v
+	default:
+		data, _ := json.Marshal(v)
+		text = string(data)
+	}
+	if runes := []rune(text); len(runes) > 60 {
+		text = string(runes[:60]) + "..."
+	}
+	return text
+}
 
-					user, err = AuthenticateUser(token, userRepo)
-					if err != nil {
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Токен недействителен. Выполните /login")
-						bot.Send(msg)
-						continue
-					}
-					if handler.AdminOnly && user.Role != "admin" {
-						msg := tgbotapi.NewMessage(update.Message.Chat.ID, "Доступ только для администраторов")
-						bot.Send(msg)
-						continue
-					}
-				}
-				handler.Handler(bot, update, user, userRepo)
-				continue
+func formatAuditEntry(data interface{}) string { //вывод записи журнала действий
+	entry := data.(models.AuditEntry)
+	response := fmt.Sprintf("#%d %s\nСотрудник ID %d: %s, %s ID %s\n",
+		entry.ID, entry.CreatedAt.Format("02.01.2006 15:04"), entry.ActorID, entry.Action, entry.Entity, entry.EntityID)
+	fields := make([]string, 0, len(entry.Changes))
+	for field := range entry.Changes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		change := entry.Changes[field]
+		response += fmt.Sprintf("  %s: %s → %s\n", field, formatAuditValue(change.Old), formatAuditValue(change.New))
+	}
+	return response
+}
 
-			}
-		} else {
-			action = update.Message.Text
-			msg = tgbotapi.NewMessage(update.Message.Chat.ID, "Недоступная команда. повторите попытку")
-			bot.Send(msg)
+const loyaltyHistoryLimit = 20 //сколько последних операций с баллами показывать
+
+var loyaltyKindNames = map[string]string{
+	models.LoyaltyAccrual:  "Начисление за заказ",
+	models.LoyaltyReferral: "Бонус за приглашение",
+	models.LoyaltyRefund:   "Возврат",
+	models.LoyaltyAdjust:   "Корректировка",
+	models.LoyaltyRedeem:   "Оплата заказа",
+	models.LoyaltyExpire:   "Сгорание",
+}
+
+func formatLoyaltyHistory(history []models.LoyaltyTransaction) string { //последние операции с баллами
+	if len(history) == 0 {
+		return "Операций с баллами пока нет"
+	}
+	response := "История баллов\n\n"
+	for _, transaction := range history {
+		response += fmt.Sprintf("%s %+.2f - %s", transaction.CreatedAt.Format("02.01.2006"),
+			transaction.Amount, loyaltyKindNames[transaction.Kind])
+		if transaction.Reason != "" {
+			response += ": " + transaction.Reason
 		}
-		/*если непонятно что ввелось можно обработать как введённое сообщение
-		if action == "" {
-		action = update.Message.Chat.ID
-		}*/
-		log.Printf("user_id: %d, username: %s, action: %s ", userID, userName, action) //лог введённой команды
+		if transaction.Remaining > 0 && transaction.ExpiresAt != nil {
+			response += fmt.Sprintf(" (%.2f сгорят %s)", transaction.Remaining, transaction.ExpiresAt.Format("02.01.2006"))
+		}
+		response += "\n"
 	}
+	return response
 }
 
-func formatUser(user models.User) string { // вывод юзера
-	roleText := "Покупатель"
-	if user.Role == "admin" {
-		roleText = "admin"
+func formatReferrerStats(stats models.ReferrerStats) string { //строка отчёта по пригласившим
+	return fmt.Sprintf("%s (ID %d): приглашено %d, с заказом %d, бонусов %.2f\n",
+		stats.FirstName, stats.UserID, stats.Invited, stats.Rewarded, stats.Bonus)
+}
+
+// blockedUpdate - true, если отправитель заблокирован. Он получает одно сообщение о блокировке, дальше бот не отвечает
+func blockedUpdate(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update, banRepo *repo.BanRepo) bool {
+	from := update.SentFrom()
+	if from == nil {
+		return false
 	}
-	return fmt.Sprintf("%s: %s(ID=%d)\nТелеграмм: %s (ID=%d)\nИмя: %s\nТелефон: %s\nПочта: %s\nДата регистрации: %s\n",
-		roleText, user.Username, user.ID, user.Username, user.TelegramID, user.FirstName,
-		user.Phone, user.Email, user.CreatedAt.Format("02.01.2006"))
+	ban, err := banRepo.ActiveBan(ctx, from.ID)
+	if err != nil {
+		log.Printf("Ошибка проверки блокировки %d: %v", from.ID, err)
+		return false
+	}
+	if ban == nil {
+		return false
+	}
+	if update.CallbackQuery != nil { //убираем часики на кнопке
+		bot.Send(tgbotapi.NewCallback(update.CallbackQuery.ID, ""))
+	}
+	if first, err := banRepo.MarkNotified(ctx, from.ID); err == nil && first {
+		bot.Send(tgbotapi.NewMessage(from.ID, "Ваш аккаунт заблокирован, бот не будет отвечать на сообщения.\n"+formatBan(*ban)))
+	}
+	log.Printf("user_id: %d, action: blocked_update", from.ID)
+	return true
 }
-func formatProduct(product models.Product) string { // вывод товара
-	return fmt.Sprintf("ID: %d\nНазвание: %s\nОписание: %s\nЦена: %.2f\nКоличество: %d\nКатегория ID: %d\nВес: %.2f\nВкус: %s\nБренд: %s\nПорций: %d\nАктивен: %v\nСоздан: %s\n\n",
-		product.ID, product.Name, product.Description, product.Price, product.Quantity,
-		product.Category_id, product.Weight, product.Flavor, product.Brand, product.Servings,
-		product.IsActive, product.CreatedAt.Format("02.01.2006 15:04"))
+
+func formatBrand(brand models.Brand) string { //вывод бренда
+	response := fmt.Sprintf("ID: %d\nБренд: %s\n", brand.ID, brand.Name)
+	if brand.Country != "" {
+		response += fmt.Sprintf("Страна: %s\n", brand.Country)
+	}
+	if brand.Description != "" {
+		response += brand.Description + "\n"
+	}
+	return response
 }
 
 func formatCategory(category models.Category) string { //вывод категории
@@ -1577,8 +4662,8 @@ func formatCategory(category models.Category) string { //вывод катего
 		category.Name, category.ID, category.Description, category.IsActive)
 }
 
-func formatOrder(order models.Order, userRepo *repo.UserRepo) string { //вывод заказа
-	users, err := userRepo.SearchUser(fmt.Sprintf("%d", order.UserID))
+func formatOrder(ctx context.Context, order models.Order, userRepo *repo.UserRepo) string { //вывод заказа
+	users, err := userRepo.SearchUser(ctx, fmt.Sprintf("%d", order.UserID))
 	if err != nil {
 		return fmt.Sprintf("Пользователь: %d не найден", order.UserID)
 	}
@@ -1588,6 +4673,25 @@ func formatOrder(order models.Order, userRepo *repo.UserRepo) string { //выв
 		order.ID, user.FirstName, order.UserID, order.Amount, order.Status, order.CreatedAt.Format("02.01.2006 15:04"))
 }
 
+func formatOrderDetails(ctx context.Context, order *models.Order, items []models.OrderItem, //заказ для администратора: наборы разложены на товары
+	components map[int][]models.BundleItem, userRepo *repo.UserRepo) string {
+	response := formatOrder(ctx, *order, userRepo) + "\n"
+	for _, item := range items {
+		sum := item.Price * float64(item.Quantity)
+		if item.BundleID == 0 {
+			response += fmt.Sprintf("Товар ID %d: %s (%s) %dшт. - %.2f руб.\n",
+				item.ProductID, item.ProductName, item.Flavor, item.Quantity, sum)
+			continue
+		}
+		response += fmt.Sprintf("Набор ID %d: %s %dшт. - %.2f руб.\n", item.BundleID, item.ProductName, item.Quantity, sum)
+		for _, component := range components[item.ID] {
+			response += fmt.Sprintf("  • Товар ID %d: %s (%s) %dшт.\n",
+				component.ProductID, component.ProductName, component.Flavor, component.Quantity)
+		}
+	}
+	return response
+}
+
 func formatOrderPagination(order models.Order) string {
 	return fmt.Sprintf("Заказ #%d\nПользователь ID: %d\nСумма: %.2f руб.\nСтатус: %s\nДата создания: %s\n",
 		order.ID, order.UserID, order.Amount, order.Status,
@@ -1598,7 +4702,7 @@ func FormatUserPaginaasdasdtion(user models.User) string {
 	return "hello"
 }
 
-func formatCart(order *models.Order, items []models.OrderItem, productRepo *repo.ProductRepo) string { //вывод корзины с товарами
+func formatCart(ctx context.Context, order *models.Order, items []models.OrderItem, productRepo *repo.ProductRepo) string { //вывод корзины с товарами
 	var response string
 	if order == nil {
 		return "Нет заказов!"
@@ -1611,12 +4715,19 @@ func formatCart(order *models.Order, items []models.OrderItem, productRepo *repo
 	for _, item := range items {
 		sum := item.Price * float64(item.Quantity)
 		total += sum
-		product, err := productRepo.SearchProduct(fmt.Sprintf("%d", item.ProductID))
-		productName := "Товар"
-		var flavor string
-		if err == nil && product != nil {
-			productName = product[0].Name
-			flavor = product[0].Flavor
+		productName := item.ProductName //снимок на момент покупки, товар мог быть изменён или архивирован
+		flavor := item.Flavor
+		if item.BundleID != 0 {
+			response += fmt.Sprintf("Набор: %s %dшт. - %.2f руб.\n", productName, item.Quantity, sum)
+			continue
+		}
+		if productName == "" {
+			productName = "Товар"
+			product, err := productRepo.SearchProduct(ctx, fmt.Sprintf("%d", item.ProductID))
+			if err == nil && len(product) > 0 {
+				productName = product[0].Name
+				flavor = product[0].Flavor
+			}
 		}
 
 		response += fmt.Sprintf("Товар: %s (%s) %dшт. - %.2f руб.\n",
@@ -1641,194 +4752,451 @@ func convertToInterfaceSlice(slice interface{}) ([]interface{}, error) { //ко
 	}
 	return result, nil
 }
-func handleCallback(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, productRepo *repo.ProductRepo, //мейн функция обработки нажатий на кнопки
-	categoryRepo *repo.CategoryRepo, userRepo *repo.UserRepo, orderRepo *repo.OrderRepo) {
 
-	ChatID := callback.Message.Chat.ID
-	MessageID := callback.Message.MessageID
-	data := callback.Data
+const InlineResultsLimit = 50 //максимум результатов inline-запроса в Telegram
 
-	if data == "users" || data == "cart" || data == "orders" || data == "buyproducts" || data == "create_order" ||
-		strings.HasPrefix(data, "buying_") ||
-		data == "confirm" || data == "cancell" {
+func handleInlineQuery(ctx context.Context, bot *tgbotapi.BotAPI, query *tgbotapi.InlineQuery, productRepo *repo.ProductRepo) { //поиск товаров через @bot запрос в любом чате
+	var products []models.Product
+	var err error
+	searchQuery := strings.TrimSpace(query.Query)
+	if searchQuery == "" {
+		products, err = productRepo.AllProducts(ctx)
+	} else {
+		products, err = productRepo.SearchProduct(ctx, searchQuery)
+	}
+	if err != nil {
+		log.Printf("Ошибка inline поиска: %v", err)
+		return
+	}
 
-		token := GetTokenFromUpdate(tgbotapi.Update{CallbackQuery: callback})
-		if token == "" {
-			msg := tgbotapi.NewMessage(ChatID, "Авторизуйтесь через /login")
-			bot.Send(msg)
-			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
-			return
+	results := []interface{}{}
+	for _, product := range products {
+		if !product.IsActive {
+			continue
 		}
-		if data == "users" || data == "search_user" {
-			user, err := AuthenticateUser(token, userRepo)
-			if err != nil || user.Role != "admin" {
-				msg := tgbotapi.NewMessage(ChatID, "Доступ только для администраторов")
-				bot.Send(msg)
-				bot.Send(tgbotapi.NewCallback(callback.ID, ""))
-				return
-			}
+		if len(results) == InlineResultsLimit {
+			break
 		}
+		article := tgbotapi.NewInlineQueryResultArticle(strconv.Itoa(product.ID),
+			fmt.Sprintf("%s (%s)", product.Name, product.Flavor), formatProductCard(product))
+		article.Description = fmt.Sprintf("%s руб. • %s • %.0f г", formatPrice(product), product.Flavor, product.Weight)
+		article.ThumbURL = product.ImageURL
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonURL("Купить",
+					fmt.Sprintf("https://t.me/%s?start=product_%d", bot.Self.UserName, product.ID)),
+			),
+		)
+		article.ReplyMarkup = &keyboard
+		results = append(results, article)
 	}
-	var msg tgbotapi.MessageConfig
-	var action string
-	if strings.HasPrefix(data, "category_") { //data - то какое значение под собой содержит та или иная кнопка
-		ID := strings.TrimPrefix(data, "category_")
-		categoryID, err := strconv.Atoi(ID) //конвертация строки в инт (аналог Int в питоне)
-		if err != nil {
-			log.Printf("Ошибка конвертации: %v", err)
-			return
-		}
-		SelectCategory[ChatID] = categoryID
 
-		action = fmt.Sprintf("select_category_%s", data) //аналог ф строки конвертирующей int->str
-		categories, err := categoryRepo.SearchCategory(fmt.Sprintf("%d", categoryID))
-		var categoryName string
-		if err == nil && len(categories) > 0 {
-			categoryName = categories[0].Name
-		} else {
-			categoryName = fmt.Sprintf("Категория %d", categoryID)
-		}
-		ShowPagination(bot, ChatID, MessageID, 1, //1 = начальная страница
-			func() (int, error) {
-				return productRepo.CountProductsByCategory(fmt.Sprintf("%d", categoryID))
-			},
-			func(limit, offset int) ([]interface{}, error) {
-				products, err := productRepo.PaginateProductsByCategory(fmt.Sprintf("%d", categoryID), limit, offset)
-				if err != nil {
-					return nil, err
-				}
-				return convertToInterfaceSlice(products)
-			},
-			func(data interface{}) string { return formatProduct(data.(models.Product)) },
-			fmt.Sprintf("Товары категории: %s", categoryName),
-			"buycategories",
-			true)
-
-		callbackConfig := tgbotapi.NewCallback(callback.ID, "")
-		bot.Send(callbackConfig)
-		log.Printf("user_id: %d, username: %s, action: %s", callback.From.ID, callback.From.FirstName, action)
-		return
+	inlineConfig := tgbotapi.InlineConfig{
+		InlineQueryID: query.ID,
+		Results:       results,
+		CacheTime:     30,
 	}
-	if strings.HasPrefix(data, "product_") { //нажатие по кнопке с ID в товарах
-		ID := strings.TrimPrefix(data, "product_")
-		productID, err := strconv.Atoi(ID)
-		if err != nil {
-			log.Printf("Ошибка конвертации: %v", err)
-			return
-		}
+	if _, err := bot.Request(inlineConfig); err != nil {
+		log.Printf("Ошибка ответа на inline запрос: %v", err)
+	}
+	log.Printf("user_id: %d, username: %s, action: inline_query %q, results: %d",
+		query.From.ID, query.From.FirstName, searchQuery, len(results))
+}
+
+func registerCallbacks(routes *router.Router, productRepo *repo.ProductRepo, //маршруты нажатий на кнопки, данные кнопки вида route:arg:arg
+	categoryRepo *repo.CategoryRepo, userRepo *repo.UserRepo, orderRepo *repo.OrderRepo, subscriptionRepo *repo.SubscriptionRepo,
+	recommendationRepo *repo.RecommendationRepo, bundleRepo *repo.BundleRepo, brandRepo *repo.BrandRepo, roleRepo *repo.RoleRepo,
+	loyaltyRepo *repo.LoyaltyRepo, auditRepo *repo.AuditRepo) {
+
+	routes.Callback(router.Route{ //category:<id> - товары категории
+		Name: "category",
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+			categoryID, err := ctx.Data.Int(0)
+			if err != nil {
+				log.Printf("Ошибка конвертации: %v", err)
+				return
+			}
+			if err := updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { shopping.CategoryID = categoryID }); err != nil {
+				router.Reply(ctx, conversationErrorText)
+				return
+			}
+
+			ctx.Action = fmt.Sprintf("select_category_%d", categoryID)
+			categories, err := categoryRepo.SearchCategory(ctx, fmt.Sprintf("%d", categoryID))
+			var categoryName string
+			if err == nil && len(categories) > 0 {
+				categoryName = categories[0].Name
+			} else {
+				categoryName = fmt.Sprintf("Категория %d", categoryID)
+			}
+			ShowPagination(ctx, bot, ChatID, MessageID, 1, //1 = начальная страница
+				func(ctx context.Context) (int, error) {
+					return productRepo.CountProductsByCategory(ctx, fmt.Sprintf("%d", categoryID))
+				},
+				func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					products, err := productRepo.PaginateProductsByCategory(ctx, fmt.Sprintf("%d", categoryID), limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(products)
+				},
+				func(data interface{}) string { return formatProduct(data.(models.Product)) },
+				fmt.Sprintf("Товары категории: %s", categoryName),
+				"buycategories",
+				true)
+
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+
+	routes.Callback(router.Route{ //brand:<id> - товары бренда
+		Name: "brand",
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+			brandID, err := ctx.Data.Int(0)
+			if err != nil {
+				log.Printf("Ошибка конвертации: %v", err)
+				return
+			}
+			if err := updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { shopping.BrandID = brandID }); err != nil {
+				router.Reply(ctx, conversationErrorText)
+				return
+			}
+
+			ctx.Action = fmt.Sprintf("select_brand_%d", brandID)
+			title := fmt.Sprintf("товары бренда %d", brandID)
+			brands, err := brandRepo.SearchBrand(ctx, fmt.Sprintf("%d", brandID))
+			if err == nil && len(brands) > 0 {
+				title = "товары бренда " + brands[0].Name
+				if brands[0].Country != "" {
+					title += fmt.Sprintf(" (%s)", brands[0].Country)
+				}
+			}
+			ShowPagination(ctx, bot, ChatID, MessageID, 1,
+				func(ctx context.Context) (int, error) { return productRepo.CountProductsByBrand(ctx, brandID) },
+				func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					products, err := productRepo.PaginateProductsByBrand(ctx, brandID, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(products)
+				},
+				func(data interface{}) string { return formatProduct(data.(models.Product)) },
+				title,
+				"buybrands",
+				true)
+
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+
+	routes.Callback(router.Route{ //bundle:<id> - карточка набора
+		Name: "bundle",
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+			bundleID, err := ctx.Data.Int(0)
+			if err != nil {
+				log.Printf("Ошибка конвертации: %v", err)
+				return
+			}
+
+			ctx.Action = fmt.Sprintf("select_bundle_%d", bundleID)
+			bundle, err := bundleRepo.SearchBundle(ctx, bundleID)
+			if err != nil || !bundle.IsActive {
+				router.Reply(ctx, "Набор не найден")
+				return
+			}
+
+			showBundle(ctx, bot, ChatID, MessageID, *bundle)
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+
+	routes.Callback(router.Route{ //product:<id> - карточка товара
+		Name: "product",
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+			productID, err := ctx.Data.Int(0)
+			if err != nil {
+				log.Printf("Ошибка конвертации: %v", err)
+				return
+			}
 
-		action = fmt.Sprintf("select_product_%s", data)
-		products, err := productRepo.SearchProduct(fmt.Sprintf("%d", productID))
-		if err != nil || len(products) == 0 {
-			msg = tgbotapi.NewMessage(ChatID, "Товар не найден")
-			bot.Send(msg)
-			return
-		}
+			ctx.Action = fmt.Sprintf("select_product_%d", productID)
+			products, err := productRepo.SearchProduct(ctx, fmt.Sprintf("%d", productID))
+			if err != nil || len(products) == 0 {
+				router.Reply(ctx, "Товар не найден")
+				return
+			}
 
-		product := products[0]
-		SelectProduct[ChatID] = productID
-		response := fmt.Sprintf("Выбран товар: %s (%s)\nЦена: %.2f руб.\nВыберите количество:", product.Name, product.Flavor, product.Price)
-		keyboard := CreateBuyingKeyboard(1) //создает клавиатуру покупки
-		editMsg := tgbotapi.NewEditMessageText(ChatID, MessageID, response)
-		editMsg.ReplyMarkup = &keyboard
-		bot.Send(editMsg)
-
-		callbackConfig := tgbotapi.NewCallback(callback.ID, "")
-		bot.Send(callbackConfig)
-		log.Printf("user_id: %d, username: %s, action: %s", callback.From.ID, callback.From.FirstName, action)
-		return
-	}
-	if strings.HasPrefix(data, "buying_") { //после выбора товара выбор количества
-		parts := strings.Split(data, "_")
-		if len(parts) < 3 {
-			log.Printf("Неверный формат покупки: %s", data)
-		}
-		var total_quantity int
+			showProduct(ctx, bot, ChatID, MessageID, products[0], productRepo, recommendationRepo)
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+
+	routes.Callback(router.Route{ //notify:<id> - подписка на поступление товара
+		Name:         "notify",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, user := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.User
+			productID, err := ctx.Data.Int(0)
+			if err != nil {
+				log.Printf("Ошибка конвертации: %v", err)
+				return
+			}
+			ctx.Action = fmt.Sprintf("subscribe_product_%d", productID)
+			answer := "Сообщим, когда товар появится в наличии"
+			if err := subscriptionRepo.Subscribe(ctx, user.ID, productID, ChatID); err != nil {
+				answer = "Ошибка подписки на товар"
+			}
+			bot.Send(tgbotapi.NewCallback(callback.ID, answer))
+		},
+	})
 
-		total_quantity, _ = strconv.Atoi(parts[2])
+	routes.Callback(router.Route{ //session:revoke:<user_id>:<session_id> и session:revokeall:<user_id>
+		Name:         "session",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID, user := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID, ctx.User
+			userID, err := ctx.Data.Int64(1)
+			if err != nil {
+				log.Printf("Ошибка конвертации: %v", err)
+				return
+			}
+			if user.ID != userID { //чужие сессии завершает только управляющий пользователями
+				if err := Authorize(ctx, roleRepo, user, models.PermUsersManage); err != nil {
+					bot.Send(tgbotapi.NewCallback(callback.ID, "Недостаточно прав доступа"))
+					return
+				}
+			}
 
-		switch parts[1] {
-		case "add":
-			total_quantity += 1
-			action = "buying_add_" + fmt.Sprintf("%d", total_quantity)
+			answer := "Сессия завершена"
+			switch ctx.Data.Arg(0) {
+			case "revokeall":
+				ctx.Action = fmt.Sprintf("revoke_all_sessions_%d", userID)
+				err = sessionStore.RevokeUserSessions(ctx, userID)
+				answer = "Все сессии завершены"
+			case "revoke":
+				sessionID, _ := ctx.Data.Int64(2)
+				ctx.Action = fmt.Sprintf("revoke_session_%d", sessionID)
+				err = sessionStore.RevokeSession(ctx, userID, sessionID)
+			}
+			if err != nil {
+				answer = fmt.Sprintf("Ошибка: %v", err)
+			}
+			bot.Send(tgbotapi.NewCallback(callback.ID, answer))
+			if CurrentSessionID(ctx, ChatID, callback.From.ID) == 0 { //текущая сессия завершена - показывать список больше нельзя
+				bot.Send(tgbotapi.NewEditMessageText(ChatID, MessageID, "Сессия завершена. Вход: /login"))
+			} else {
+				showSessions(ctx, bot, ChatID, callback.From.ID, MessageID, userID)
+			}
+		},
+	})
 
-		case "del":
-			total_quantity -= 1
-			action = "buying_del_" + fmt.Sprintf("%d", total_quantity)
-		case "quantity":
-			action = "buying_quantity_" + fmt.Sprintf("%d", total_quantity)
+	deleteCategory := func(ctx *router.Context) { //catmove:<id>:<target_id> - перенос товаров, catarch:<id> - архивация
+		bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+		categoryID, err := ctx.Data.Int(0)
+		if err != nil {
+			log.Printf("Ошибка конвертации: %v", err)
+			return
 		}
-		SelectQuantity[ChatID] = total_quantity
 		var response string
-		if productID, ok := SelectProduct[ChatID]; ok && productID > 0 {
-			products, err := productRepo.SearchProduct(fmt.Sprintf("%d", productID))
-			if err == nil && len(products) > 0 {
-				product := products[0]
-				response = fmt.Sprintf("Выбран товар: %s\nЦена: %.2f руб.\n\nК покупке: %d",
-					product.Name, product.Price, total_quantity)
+		if ctx.Route.Name == "catmove" {
+			targetID, err := ctx.Data.Int(1)
+			if err != nil {
+				log.Printf("Ошибка конвертации: %v", err)
+				return
+			}
+			ctx.Action = fmt.Sprintf("move_category_%d_to_%d", categoryID, targetID)
+			if err := categoryRepo.MoveAndDeleteCategory(ctx, categoryID, targetID); err != nil {
+				response = fmt.Sprintf("Ошибка удаления категории: %v", err)
 			} else {
-				response = fmt.Sprintf("К покупке: %d", total_quantity)
+				recordAudit(ctx, auditRepo, ctx.User, "delete_category", models.AuditCategory, categoryID,
+					map[string]interface{}{"deleted": false}, map[string]interface{}{"deleted": true, "products_moved_to": targetID})
+				response = fmt.Sprintf("Категория ID %d удалена, товары перенесены в категорию ID %d", categoryID, targetID)
 			}
 		} else {
-			response = fmt.Sprintf("К покупке: %d", total_quantity)
+			ctx.Action = fmt.Sprintf("archive_category_%d", categoryID)
+			if err := categoryRepo.ArchiveCategory(ctx, categoryID); err != nil {
+				response = fmt.Sprintf("Ошибка архивации категории: %v", err)
+			} else {
+				recordAudit(ctx, auditRepo, ctx.User, "delete_category", models.AuditCategory, categoryID, map[string]bool{"archived": false}, map[string]bool{"archived": true})
+				response = fmt.Sprintf("Категория ID %d и её товары перенесены в архив. Восстановить: /restore_category %d", categoryID, categoryID)
+			}
 		}
-
-		keyboard := CreateBuyingKeyboard(total_quantity)
-		msg1 := tgbotapi.NewEditMessageText(ChatID, MessageID, response)
-		msg1.ReplyMarkup = &keyboard
-		bot.Send(msg1)
-		callbackConfig := tgbotapi.NewCallback(callback.ID, "")
-		bot.Send(callbackConfig)
-		log.Printf("user_id: %d, username: %s, action: %s, quantity: %d",
-			callback.From.ID, callback.From.FirstName, action, total_quantity)
-		return
+		bot.Send(tgbotapi.NewEditMessageText(ChatID, MessageID, response))
+		bot.Send(tgbotapi.NewCallback(callback.ID, ""))
 	}
-	if data == "confirm" || data == "cancell" {
+	routes.Callback(router.Route{Name: "catmove", Permission: models.PermCatalogWrite, Handler: deleteCategory})
+	routes.Callback(router.Route{Name: "catarch", Permission: models.PermCatalogWrite, Handler: deleteCategory})
+
+	routes.Callback(router.Route{ //profile:show, profile:points, profile:edit:<поле>, profile:toggle:<restock|orders>
+		Name:         "profile",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+			account, err := userRepo.UserByID(ctx, ctx.User.ID)
+			if err != nil {
+				bot.Send(tgbotapi.NewCallback(callback.ID, "Пользователь не найден"))
+				return
+			}
+			switch ctx.Data.Arg(0) {
+			case "show":
+				showProfile(ctx, bot, ChatID, 0, account, loyaltyRepo)
+			case "points":
+				history, err := loyaltyRepo.History(ctx, account.ID, loyaltyHistoryLimit)
+				if err != nil {
+					bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка загрузки истории баллов"))
+					return
+				}
+				bot.Send(tgbotapi.NewMessage(ChatID, formatLoyaltyHistory(history)))
+			case "edit":
+				field := ctx.Data.Arg(1)
+				if field == "phone" {
+					if err := setConversation(ctx, ChatID, StateProfileInput, ProfilePayload{Field: field}); err != nil {
+						router.Reply(ctx, conversationErrorText)
+						return
+					}
+					keyboard := tgbotapi.NewReplyKeyboard(
+						tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButtonContact("Отправить мой номер")),
+						tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("Отмена")),
+					)
+					keyboard.OneTimeKeyboard = true
+					msg := tgbotapi.NewMessage(ChatID, "Нажмите «Отправить мой номер» - Telegram передаст номер вашего аккаунта")
+					msg.ReplyMarkup = keyboard
+					bot.Send(msg)
+				} else if prompt, ok := profileFields[field]; ok {
+					if err := setConversation(ctx, ChatID, StateProfileInput, ProfilePayload{Field: field}); err != nil {
+						prompt = conversationErrorText
+					}
+					bot.Send(tgbotapi.NewMessage(ChatID, prompt))
+				}
+			case "toggle":
+				switch ctx.Data.Arg(1) {
+				case "restock":
+					account.NotifyRestock = !account.NotifyRestock
+				case "orders":
+					account.NotifyOrders = !account.NotifyOrders
+				default:
+					bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+					return
+				}
+				if err := userRepo.UpdateProfile(ctx, account); err != nil {
+					bot.Send(tgbotapi.NewCallback(callback.ID, "Ошибка сохранения настроек"))
+					return
+				}
+				showProfile(ctx, bot, ChatID, MessageID, account, loyaltyRepo)
+			}
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
 
-		productID, hasProduct := SelectProduct[ChatID]
+	routes.Callback(router.Route{ //buying:<add|del|quantity>:<количество> - выбор количества к покупке
+		Name:         "buying",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+			total_quantity, err := ctx.Data.Int(1)
+			if err != nil {
+				log.Printf("Неверный формат покупки: %s", callback.Data)
+				return
+			}
 
-		if data == "confirm" && hasProduct { //обработка добавления товара в корзину с укаанным количеством
-			action = "confirm_purchase"
-			var quantity int = 1
-			if quantity < SelectQuantity[ChatID] {
-				quantity = SelectQuantity[ChatID]
+			switch ctx.Data.Arg(0) {
+			case "add":
+				total_quantity += 1
+			case "del":
+				total_quantity -= 1
 			}
-			fmt.Printf("quantity: %d", quantity)
-			users, err := userRepo.SearchUser(fmt.Sprintf("%d", ChatID))
-			if err != nil || len(users) == 0 {
-				msg = tgbotapi.NewMessage(ChatID, "Пользователь не найден")
+			ctx.Action = fmt.Sprintf("buying_%s_%d", ctx.Data.Arg(0), total_quantity)
+			var shopping ShoppingPayload
+			err = updateShopping(ctx, ChatID, func(payload *ShoppingPayload) {
+				payload.Quantity = total_quantity
+				shopping = *payload
+			})
+			if err != nil { //несохранённое количество не дойдёт до подтверждения покупки
+				router.Reply(ctx, conversationErrorText)
+				return
+			}
+			var response string
+			if shopping.ProductID > 0 {
+				products, err := productRepo.SearchProduct(ctx, fmt.Sprintf("%d", shopping.ProductID))
+				if err == nil && len(products) > 0 {
+					product := products[0]
+					response = fmt.Sprintf("Выбран товар: %s\nЦена: %.2f руб.\n\nК покупке: %d",
+						product.Name, product.CurrentPrice(), total_quantity)
+				} else {
+					response = fmt.Sprintf("К покупке: %d", total_quantity)
+				}
+			} else if shopping.BundleID > 0 {
+				bundle, err := bundleRepo.SearchBundle(ctx, shopping.BundleID)
+				if err == nil {
+					response = fmt.Sprintf("Выбран набор: %s\nЦена: %.2f руб.\n\nК покупке: %d",
+						bundle.Name, bundle.Price, total_quantity)
+				} else {
+					response = fmt.Sprintf("К покупке: %d", total_quantity)
+				}
 			} else {
-				user := users[0]
-				products, err := productRepo.SearchProduct(fmt.Sprintf("%d", productID))
+				response = fmt.Sprintf("К покупке: %d", total_quantity)
+			}
+
+			keyboard := CreateBuyingKeyboard(total_quantity)
+			msg1 := tgbotapi.NewEditMessageText(ChatID, MessageID, response)
+			msg1.ReplyMarkup = &keyboard
+			bot.Send(msg1)
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+
+	routes.Callback(router.Route{ //purchase:confirm - добавить выбранное в корзину, purchase:cancel - отмена выбора
+		Name:         "purchase",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID, user := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID, ctx.User
+			var msg tgbotapi.MessageConfig
+
+			shopping := shoppingState(ctx, ChatID)
+			productID, hasProduct := shopping.ProductID, shopping.ProductID > 0
+			bundleID, hasBundle := shopping.BundleID, shopping.BundleID > 0
+			confirm := ctx.Data.Arg(0) == "confirm"
+
+			if confirm && hasProduct { //обработка добавления товара в корзину с укаанным количеством
+				ctx.Action = "confirm_purchase"
+				var quantity int = 1
+				if quantity < shopping.Quantity {
+					quantity = shopping.Quantity
+				}
+				products, err := productRepo.SearchProduct(ctx, fmt.Sprintf("%d", productID))
 				if err != nil || len(products) == 0 {
 					msg = tgbotapi.NewMessage(ChatID, "Товар не найден")
 				} else {
 					product := products[0]
 
-					cart, err := orderRepo.DetailCart(int64(user.ID))
+					cart, err := orderRepo.DetailCart(ctx, int64(user.ID))
 					if err != nil {
 						msg = tgbotapi.NewMessage(ChatID, "Ошибка при работе с корзиной: "+err.Error())
 					} else if cart == nil {
-						order, err := orderRepo.CreateOrder(int64(user.ID))
+						order, err := orderRepo.CreateOrder(ctx, int64(user.ID))
 						if err != nil {
 							msg = tgbotapi.NewMessage(ChatID, "Ошибка создания заказа: "+err.Error())
 						} else {
-							err := orderRepo.AddItemToCart(order.ID, productID, quantity, product.Price)
+							err := orderRepo.AddItemToCart(ctx, order.ID, product, quantity)
 							if err != nil {
 								msg = tgbotapi.NewMessage(ChatID, "Ошибка добавления товара в корзину: "+err.Error())
 							} else {
 								msg = tgbotapi.NewMessage(ChatID,
 									fmt.Sprintf("Товар добавлен в корзину\n\nЗаказ: #%d\nТовар: %s\nЦена товара: %.2f руб.\nКоличество: %d\nЦена: %.2f руб.",
-										order.ID, product.Name, product.Price, quantity,
-										product.Price*float64(quantity)))
+										order.ID, product.Name, product.CurrentPrice(), quantity,
+										product.CurrentPrice()*float64(quantity)))
 							}
 						}
 					} else {
-						err := orderRepo.AddItemToCart(cart.Order.ID, productID, quantity, product.Price) //добавление товара в существующую корзину
+						err := orderRepo.AddItemToCart(ctx, cart.Order.ID, product, quantity) //добавление товара в существующую корзину
 						if err != nil {
 							msg = tgbotapi.NewMessage(ChatID, "Ошибка добавления товара в корзину: "+err.Error())
 						} else {
-							updatedCart, err := orderRepo.DetailCart(int64(user.ID))
+							updatedCart, err := orderRepo.DetailCart(ctx, int64(user.ID))
 							if err != nil {
 								msg = tgbotapi.NewMessage(ChatID, "Ошибка получения обновленной корзины: "+err.Error())
 							} else {
@@ -1838,10 +5206,11 @@ func handleCallback(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, prod
 								}
 								msg1 := tgbotapi.NewMessage(ChatID,
 									fmt.Sprintf("Товар добавлен в корзину\n\nЗаказ: #%d\nТовар: %s (%s)\nЦена товара: %.2f руб.\nКоличество: %d\nСумма за товар: %.2f руб.\nСумма заказа: %.2f руб.",
-										cart.Order.ID, product.Name, product.Flavor, product.Price, quantity,
-										product.Price*float64(quantity), totalSum))
-								delete(SelectProduct, ChatID) //очищается выбранный товар
-								delete(buyingState, ChatID)   //очищается состояние покупки
+										cart.Order.ID, product.Name, product.Flavor, product.CurrentPrice(), quantity,
+										product.CurrentPrice()*float64(quantity), totalSum))
+								updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { //очищается выбранный товар и количество
+									shopping.ProductID, shopping.Quantity = 0, 0
+								})
 								answermsg := tgbotapi.NewMessage(ChatID, "Хотите выбрать ещё товары?")
 								keyboard := tgbotapi.NewInlineKeyboardMarkup(
 									tgbotapi.NewInlineKeyboardRow(
@@ -1856,328 +5225,384 @@ func handleCallback(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, prod
 						}
 					}
 				}
-			}
-			editMsg := tgbotapi.NewEditMessageReplyMarkup(
-				ChatID,
-				MessageID,
-				tgbotapi.NewInlineKeyboardMarkup(),
-			)
-			bot.Send(editMsg)
-
-		} else if data == "cancell" { //обработка кнопи отмены
-			action = "cancel_purchase"
-			delete(SelectProduct, ChatID)
-			delete(SelectCategory, ChatID)
-			delete(buyingState, ChatID)
-			delete(SelectQuantity, ChatID)
+				bot.Send(tgbotapi.NewEditMessageReplyMarkup(ChatID, MessageID, tgbotapi.NewInlineKeyboardMarkup()))
 
-			msg = tgbotapi.NewMessage(ChatID, "Отмена")
-
-			editMsg := tgbotapi.NewEditMessageReplyMarkup(
-				ChatID,
-				MessageID,
-				tgbotapi.NewInlineKeyboardMarkup(),
-			)
-			bot.Send(editMsg)
-		}
-
-		if msg.Text != "" {
-			bot.Send(msg)
-		}
-
-		callbackConfig := tgbotapi.NewCallback(callback.ID, "")
-		bot.Send(callbackConfig)
-		log.Printf("user_id: %d, username: %s, action: %s",
-			callback.From.ID, callback.From.FirstName, action)
-		return
-	}
+			} else if confirm && hasBundle { //набор добавляется в корзину одной позицией
+				ctx.Action = "confirm_bundle_purchase"
+				quantity := 1
+				if quantity < shopping.Quantity {
+					quantity = shopping.Quantity
+				}
+				msg = addBundleToCart(ctx, bot, ChatID, user, bundleID, quantity, orderRepo, bundleRepo)
+				bot.Send(tgbotapi.NewEditMessageReplyMarkup(ChatID, MessageID, tgbotapi.NewInlineKeyboardMarkup()))
+
+			} else if !confirm { //обработка кнопи отмены
+				ctx.Action = "cancel_purchase"
+				endConversationIn(ctx, ChatID, StateShopping)
+				msg = tgbotapi.NewMessage(ChatID, "Отмена")
+				bot.Send(tgbotapi.NewEditMessageReplyMarkup(ChatID, MessageID, tgbotapi.NewInlineKeyboardMarkup()))
+			}
 
-	if bot == nil || callback == nil || productRepo == nil || //обработка возможных ошибок из
-		categoryRepo == nil || userRepo == nil || orderRepo == nil {
-		log.Printf("Один из параметров nil")
-		return
-	}
+			if msg.Text != "" {
+				bot.Send(msg)
+			}
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
 
-	handlers := map[string]struct { //структура, которая принимает значения (функции) чтобы для каждого случая был персональный вывод. уменьшает написание кода, упрощает добавление
-		CountFunc      func() (int, error)                            //функция подсчёта товаров для пагинации
-		PaginationFunc func(limit, offset int) ([]interface{}, error) //пагинационная функция с лимитом данных и отступом offset
-		formatFunc     func(interface{}) string
-		title          string
-		showKeyboard   bool
-	}{
-		"products": {
-			CountFunc: productRepo.CountProducts,
-			PaginationFunc: func(limit, offset int) ([]interface{}, error) {
-				products, err := productRepo.PaginateProducts(limit, offset)
-				if err != nil {
-					return nil, err
-				}
-				return convertToInterfaceSlice(products)
-			},
-			formatFunc:   func(data interface{}) string { return formatProduct(data.(models.Product)) },
-			title:        "товары",
-			showKeyboard: false,
-		},
-		"buyproducts": {
-			CountFunc: productRepo.CountProducts,
-			PaginationFunc: func(limit, offset int) ([]interface{}, error) {
-				products, err := productRepo.PaginateProducts(limit, offset)
-				if err != nil {
-					return nil, err
-				}
-				return convertToInterfaceSlice(products)
+	pageSources := func(ChatID int64, user *models.User) map[string]pageSource { //источники данных пагинации для чата; user - nil, если маршрут без входа
+		auditCount, auditPaginate := auditPages(auditRepo, ChatID)
+		return map[string]pageSource{
+			"audit": {
+				CountFunc:      auditCount,
+				PaginationFunc: auditPaginate,
+				formatFunc:     formatAuditEntry,
+				title:          "записи журнала действий",
+				showKeyboard:   false,
 			},
-			formatFunc:   func(data interface{}) string { return formatProduct(data.(models.Product)) },
-			title:        "товары",
-			showKeyboard: true,
-		},
-		"users": {
-			CountFunc: userRepo.CountUsers,
-			PaginationFunc: func(limit, offset int) ([]interface{}, error) {
-				users, err := userRepo.PaginateUser(limit, offset)
-				if err != nil {
-					return nil, err
-				}
-				return convertToInterfaceSlice(users)
+			"products": {
+				CountFunc: productRepo.CountProducts,
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					products, err := productRepo.PaginateProducts(ctx, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(products)
+				},
+				formatFunc:   func(data interface{}) string { return formatProduct(data.(models.Product)) },
+				title:        "товары",
+				showKeyboard: false,
 			},
-			formatFunc:   func(data interface{}) string { return formatUser(data.(models.User)) },
-			title:        "пользователи",
-			showKeyboard: false,
-		},
-		"categories": {
-			CountFunc: categoryRepo.CountCategories,
-			PaginationFunc: func(limit, offset int) ([]interface{}, error) {
-				categories, err := categoryRepo.PaginateCategory(limit, offset)
-				if err != nil {
-					return nil, err
-				}
-				return convertToInterfaceSlice(categories)
+			"buyproducts": {
+				CountFunc: productRepo.CountProducts,
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					products, err := productRepo.PaginateProducts(ctx, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(products)
+				},
+				formatFunc:   func(data interface{}) string { return formatProduct(data.(models.Product)) },
+				title:        "товары",
+				showKeyboard: true,
 			},
-			formatFunc:   func(data interface{}) string { return formatCategory(data.(models.Category)) },
-			title:        "категории",
-			showKeyboard: false,
-		},
-		"orders": {
-			CountFunc: func() (int, error) {
-				UserID := callback.Message.Chat.ID
-				users, err := userRepo.SearchUser(fmt.Sprintf("%d", UserID))
-				if err != nil || len(users) == 0 {
-					return 0, err
-				}
-				user := users[0]
-				return orderRepo.CountUserOrders(int(user.ID))
+			"users": {
+				CountFunc: userRepo.CountUsers,
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					users, err := userRepo.PaginateUser(ctx, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(users)
+				},
+				formatFunc:   func(data interface{}) string { return formatUser(data.(models.User)) },
+				title:        "пользователи",
+				showKeyboard: false,
 			},
-			PaginationFunc: func(limit, offset int) ([]interface{}, error) {
-				UserID := callback.Message.Chat.ID
-				users, err := userRepo.SearchUser(fmt.Sprintf("%d", UserID))
-				if err != nil || len(users) == 0 {
-					return nil, err
-				}
-				user := users[0]
-				orders, err := orderRepo.PaginateUserOrders(int(user.ID), limit, offset)
-				if err != nil {
-					return nil, err
-				}
-				return convertToInterfaceSlice(orders)
+			"categories": {
+				CountFunc: categoryRepo.CountCategories,
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					categories, err := categoryRepo.PaginateCategory(ctx, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(categories)
+				},
+				formatFunc:   func(data interface{}) string { return formatCategory(data.(models.Category)) },
+				title:        "категории",
+				showKeyboard: false,
 			},
-			formatFunc:   func(data interface{}) string { return formatOrderPagination(data.(models.Order)) },
-			title:        "ваши заказы",
-			showKeyboard: false,
-		},
-		"buycategories": {
-			CountFunc: func() (int, error) {
-				if categoryID, ok := SelectCategory[ChatID]; ok { // если выбрана категория то показываем товары категории
-					return productRepo.CountProductsByCategory(fmt.Sprintf("%d", categoryID))
-				}
-				return categoryRepo.CountCategories() // иначе список категорий
+			"orders": {
+				CountFunc: func(ctx context.Context) (int, error) { //заказы вошедшего пользователя, а не владельца чата
+					if user == nil {
+						return 0, nil
+					}
+					return orderRepo.CountUserOrders(ctx, int(user.ID))
+				},
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					if user == nil {
+						return nil, nil
+					}
+					orders, err := orderRepo.PaginateUserOrders(ctx, int(user.ID), limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(orders)
+				},
+				formatFunc:   func(data interface{}) string { return formatOrderPagination(data.(models.Order)) },
+				title:        "ваши заказы",
+				showKeyboard: false,
 			},
-			PaginationFunc: func(limit, offset int) ([]interface{}, error) {
-				if categoryID, ok := SelectCategory[ChatID]; ok {
-					products, err := productRepo.PaginateProductsByCategory(
-						fmt.Sprintf("%d", categoryID), limit, offset)
+			"buybrands": {
+				CountFunc: func(ctx context.Context) (int, error) {
+					if brandID := shoppingState(ctx, ChatID).BrandID; brandID != 0 { // если выбран бренд то показываем его товары
+						return productRepo.CountProductsByBrand(ctx, brandID)
+					}
+					return brandRepo.CountBrands(ctx)
+				},
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					if brandID := shoppingState(ctx, ChatID).BrandID; brandID != 0 {
+						products, err := productRepo.PaginateProductsByBrand(ctx, brandID, limit, offset)
+						if err != nil {
+							return nil, err
+						}
+						return convertToInterfaceSlice(products)
+					}
+					brands, err := brandRepo.PaginateBrands(ctx, limit, offset)
 					if err != nil {
 						return nil, err
 					}
-					return convertToInterfaceSlice(products)
-				}
-				categories, err := categoryRepo.PaginateCategory(limit, offset)
-				if err != nil {
-					return nil, err
-				}
-				return convertToInterfaceSlice(categories)
+					return convertToInterfaceSlice(brands)
+				},
+				formatFunc: func(data interface{}) string {
+					switch v := data.(type) {
+					case models.Brand:
+						return formatBrand(v)
+					case models.Product:
+						return formatProduct(v)
+					default:
+						return fmt.Sprintf("%v", data)
+					}
+				},
+				title:        "бренды и товары",
+				showKeyboard: true,
 			},
-			formatFunc: func(data interface{}) string {
-				switch v := data.(type) {
-				case models.Category:
-					return formatCategory(v)
-				case models.Product:
-					return formatProduct(v)
-				default:
-					return fmt.Sprintf("%v", data)
-				}
+			"bundles": {
+				CountFunc: bundleRepo.CountBundles,
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					bundles, err := bundleRepo.PaginateBundles(ctx, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(bundles)
+				},
+				formatFunc:   func(data interface{}) string { return formatBundle(data.(models.Bundle)) },
+				title:        "наборы",
+				showKeyboard: true,
 			},
-			title:        "категории и товары",
-			showKeyboard: true,
-		},
-	}
-	for dataType, handler := range handlers { //обработка перелистывания страниц
-		if data == dataType ||
-			strings.HasPrefix(data, "prev_"+dataType+"_") ||
-			strings.HasPrefix(data, "next_"+dataType+"_") ||
-			strings.HasPrefix(data, "current_"+dataType+"_") {
-			var page int
-
-			if data == dataType { // изначально выводим 1 страницу
-				page = 1
-
-				action = "callback_" + dataType
-			} else { //а потом считаем
-				parts := strings.Split(data, "_")
-				currentPage, _ := strconv.Atoi(parts[2])
-
-				switch parts[0] {
-				case "current":
-					page = currentPage
-					action = "pagination_current_" + dataType
-				case "prev":
-					page = currentPage - 1
-					if page < 1 {
-						page = 1
+			"buycategories": {
+				CountFunc: func(ctx context.Context) (int, error) {
+					if categoryID := shoppingState(ctx, ChatID).CategoryID; categoryID != 0 { // если выбрана категория то показываем товары категории
+						return productRepo.CountProductsByCategory(ctx, fmt.Sprintf("%d", categoryID))
+					}
+					return categoryRepo.CountCategories(ctx) // иначе список категорий
+				},
+				PaginationFunc: func(ctx context.Context, limit, offset int) ([]interface{}, error) {
+					if categoryID := shoppingState(ctx, ChatID).CategoryID; categoryID != 0 {
+						products, err := productRepo.PaginateProductsByCategory(ctx,
+							fmt.Sprintf("%d", categoryID), limit, offset)
+						if err != nil {
+							return nil, err
+						}
+						return convertToInterfaceSlice(products)
+					}
+					categories, err := categoryRepo.PaginateCategory(ctx, limit, offset)
+					if err != nil {
+						return nil, err
+					}
+					return convertToInterfaceSlice(categories)
+				},
+				formatFunc: func(data interface{}) string {
+					switch v := data.(type) {
+					case models.Category:
+						return formatCategory(v)
+					case models.Product:
+						return formatProduct(v)
+					default:
+						return fmt.Sprintf("%v", data)
 					}
-					action = "pagination_prev_" + dataType
-				case "next":
-					page = currentPage + 1
-					action = "pagination_next_" + dataType
+				},
+				title:        "категории и товары",
+				showKeyboard: true,
+			},
+		}
+	}
+	for _, route := range []router.Route{ //<тип>[:<страница>] - без страницы открывается первая
+		{Name: "audit", Permission: models.PermAuditView},
+		{Name: "products"},
+		{Name: "buyproducts", AuthRequired: true},
+		{Name: "users", Permission: models.PermUsersManage},
+		{Name: "categories"},
+		{Name: "orders", AuthRequired: true},
+		{Name: "buybrands"},
+		{Name: "bundles"},
+		{Name: "buycategories"},
+	} {
+		route.Handler = func(ctx *router.Context) {
+			bot, callback, ChatID, MessageID := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.MessageID
+			dataType := ctx.Route.Name
+			source := pageSources(ChatID, ctx.User)[dataType]
+			page := 1
+			if len(ctx.Data.Args) == 0 { // изначально выводим 1 страницу
+				if dataType == "buybrands" && shoppingState(ctx, ChatID).BrandID != 0 { //из меню всегда открывается список брендов
+					updateShopping(ctx, ChatID, func(shopping *ShoppingPayload) { shopping.BrandID = 0 })
+				}
+				ctx.Action = "callback_" + dataType
+			} else {
+				if page, _ = ctx.Data.Int(0); page < 1 {
+					page = 1
 				}
+				ctx.Action = fmt.Sprintf("pagination_%s_page_%d", dataType, page)
 			}
 
-			ShowPagination(bot, ChatID, MessageID, page,
-				handler.CountFunc,
-				handler.PaginationFunc,
-				handler.formatFunc,
-				handler.title, dataType, dataType == "buyproducts" || dataType == "buycategories") //условие == || чтобы выводить доп клавиатуру выбора товара/категории
-
-			callbackConfig := tgbotapi.NewCallback(callback.ID, "")
-			bot.Send(callbackConfig)
-			log.Printf("user_id: %d, username: %s, action: %s_page_%d", callback.From.ID, callback.From.FirstName, action, page)
+			ShowPagination(ctx, bot, ChatID, MessageID, page,
+				source.CountFunc,
+				source.PaginationFunc,
+				source.formatFunc,
+				source.title, dataType, source.showKeyboard)
 
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
 		}
-
+		routes.Callback(route)
 	}
 
-	if data == "products" || data == "users" || data == "buyproducts" || data == "buycategories" || data == "orders" ||
-		strings.HasPrefix(data, "prev_") || strings.HasPrefix(data, "next_") || strings.HasPrefix(data, "current_") {
-		//пропускаем обработку пагинации во избежание возникновения ошибок ибо оно обработано уже
-	} else {
-		switch data {
-		case "search_product": //поиск товара
-			action = "callback_search_product"
-			msg1 := tgbotapi.NewMessage(ChatID, "Укажите название товара для поиска")
-			bot.Send(msg1)
-		case "search_category": //поиск категорий
-			action = "search_category"
-			waitingCategory[ChatID] = true // поднимаем флаг поиска
-			msg1 := tgbotapi.NewMessage(ChatID, "Введите запрос поиска категории:")
-			bot.Send(msg1)
-		case "create_order": //создание корзины
-			action = "create_order"
+	routes.Callback(router.Route{
+		Name: "search_product",
+		Handler: func(ctx *router.Context) {
+			ctx.Action = "callback_search_product"
+			if err := setConversation(ctx, ctx.ChatID, StateSearchProduct, nil); err != nil {
+				router.Reply(ctx, conversationErrorText)
+				return
+			}
+			router.Reply(ctx, "Укажите название товара для поиска")
+		},
+	})
 
-			users, err := userRepo.SearchUser(fmt.Sprintf("%d", callback.Message.Chat.ID))
-			if err != nil || users == nil {
-				msg = tgbotapi.NewMessage(ChatID, "Ошибка создания заказа. Пользователь не найден")
-			} else {
-				user := users[0]
-				order, err := orderRepo.CreateOrder(int64(user.ID))
-				if err != nil {
-					msg = tgbotapi.NewMessage(ChatID, "Ошибка создания заказа.")
-				} else {
-					response := fmt.Sprintf("Заказ создан\nНомер заказа: %d", order.ID)
-					msg1 := tgbotapi.NewMessage(ChatID, response)
-					bot.Send(msg1)
-
-					msg2 := tgbotapi.NewMessage(callback.Message.Chat.ID, "Где будем искать товары?") //варианты добавления товаров в корзину
-					keyboard := tgbotapi.NewInlineKeyboardMarkup(
-						tgbotapi.NewInlineKeyboardRow(
-							tgbotapi.NewInlineKeyboardButtonData("По категориям", "buycategories")),
-						tgbotapi.NewInlineKeyboardRow(
-							tgbotapi.NewInlineKeyboardButtonData("По товарам", "buyproducts")),
-						tgbotapi.NewInlineKeyboardRow(
-							tgbotapi.NewInlineKeyboardButtonData("Вывести ассортимент", "products")),
-					)
-					msg2.ReplyMarkup = keyboard
-					bot.Send(msg2)
-				}
+	routes.Callback(router.Route{
+		Name: "search_category",
+		Handler: func(ctx *router.Context) {
+			if err := setConversation(ctx, ctx.ChatID, StateSearchCategory, nil); err != nil { // ждём запрос поиска
+				router.Reply(ctx, conversationErrorText)
+				return
+			}
+			router.Reply(ctx, "Введите запрос поиска категории:")
+		},
+	})
+
+	routes.Callback(router.Route{
+		Name:       "search_user",
+		Permission: models.PermUsersManage,
+		Handler: func(ctx *router.Context) {
+			if err := setConversation(ctx, ctx.ChatID, StateSearchUser, nil); err != nil { // ждём запрос следующим сообщением
+				router.Reply(ctx, conversationErrorText)
+				return
 			}
-		case "confirm_order": //подтверждение заказа
-			action = "confirm_order"
-			user, err := userRepo.SearchUser(fmt.Sprintf("%d", callback.Message.Chat.ID))
-			userID := user[0].ID
+			router.Reply(ctx, "Укажите имя пользователя для поиска")
+		},
+	})
+
+	routes.Callback(router.Route{ //создание корзины
+		Name:         "create_order",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, user := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.User
+			order, err := orderRepo.CreateOrder(ctx, int64(user.ID))
 			if err != nil {
-				msg = tgbotapi.NewMessage(ChatID, "Нет пользователя!")
-			} else {
-				orderID, err := orderRepo.ConfirmOrder(userID)
-				if err != nil {
-					msg = tgbotapi.NewMessage(ChatID, "Ошибка подтверждения заказа")
-				} else {
-					msg = tgbotapi.NewMessage(ChatID, fmt.Sprintf("Заказ #%d успешно сформирован!", orderID))
-				}
+				router.Reply(ctx, "Ошибка создания заказа.")
+				return
+			}
+			bot.Send(tgbotapi.NewMessage(ChatID, fmt.Sprintf("Заказ создан\nНомер заказа: %d", order.ID)))
+
+			msg := tgbotapi.NewMessage(ChatID, "Где будем искать товары?") //варианты добавления товаров в корзину
+			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("По категориям", "buycategories")),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("По товарам", "buyproducts")),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Вывести ассортимент", "products")),
+			)
+			bot.Send(msg)
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+
+	routes.Callback(router.Route{ //confirm_order[:points] - подтверждение заказа, с оплатой части баллами
+		Name:         "confirm_order",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			usePoints := ctx.Data.Arg(0) == "points"
+			if usePoints {
+				ctx.Action = "confirm_order_points"
 			}
-		case "cart": //последний активный заказ
-			action = "cart"
-			users, err := userRepo.SearchUser(fmt.Sprintf("%d", callback.Message.Chat.ID))
+			orderID, points, err := orderRepo.ConfirmOrder(ctx, ctx.User.ID, usePoints)
 			if err != nil {
-				log.Printf("Error loading cart: %v", err)
-				msg = tgbotapi.NewMessage(ChatID, "Ошибка загрузки корзины!")
-			} else if users == nil {
-				msg = tgbotapi.NewMessage(ChatID, "Нет пользователя!")
+				router.Reply(ctx, "Ошибка подтверждения заказа: "+err.Error())
+			} else if points > 0 {
+				router.Reply(ctx, fmt.Sprintf("Заказ #%d успешно сформирован!\nОплачено баллами: %.2f", orderID, points))
 			} else {
-				user := users[0]
-				cart, err := orderRepo.DetailCart(int64(user.ID))
-				if err != nil {
-					log.Printf("Error loading cart: %v", err)
-					msg = tgbotapi.NewMessage(ChatID, "Ошибка загрузки корзины!")
-				} else if cart == nil {
-					msg = tgbotapi.NewMessage(ChatID, "Нет заказов!")
-				} else {
-					response := "Ваш заказ:\n\n"
-					if formatCart(&cart.Order, cart.Items, productRepo) == "Пустая корзина" {
-						msg1 := tgbotapi.NewMessage(ChatID, "Пустая корзина")
-						keyboard := tgbotapi.NewInlineKeyboardMarkup(
-							tgbotapi.NewInlineKeyboardRow(
-								tgbotapi.NewInlineKeyboardButtonData("Добавить товары", "buyproducts"),
-							),
-							tgbotapi.NewInlineKeyboardRow(
-								tgbotapi.NewInlineKeyboardButtonData("Вернуться на главную", "start"),
-							),
-						)
-						msg1.ReplyMarkup = keyboard
-						bot.Send(msg1)
-						return
-					} else {
-						response += formatCart(&cart.Order, cart.Items, productRepo)
-						msg = tgbotapi.NewMessage(ChatID, response)
-						keyboard := tgbotapi.NewInlineKeyboardMarkup(
-							tgbotapi.NewInlineKeyboardRow(
-								tgbotapi.NewInlineKeyboardButtonData("Подтвердить заказ", "confirm_order"),
-								tgbotapi.NewInlineKeyboardButtonData("Вернуться к покупкам", "buyproducts"),
-							))
-						msg.ReplyMarkup = keyboard
-					}
-				}
+				router.Reply(ctx, fmt.Sprintf("Заказ #%d успешно сформирован!", orderID))
 			}
-		case "help":
-			action = "command help"
-			msg = tgbotapi.NewMessage(ChatID,
-				"/start - начало\n/products - все товары\n/categories - все категории\n/search [product/user] [текст] - поиск товаров/пользователей\n/help - помощь\n/users - список пользователей")
-		case "start": //старт команда
-			action = "command start"
-			delete(SelectProduct, ChatID)
-			delete(SelectCategory, ChatID)
-			delete(buyingState, ChatID)
-			delete(SelectQuantity, ChatID)
-			msg = tgbotapi.NewMessage(ChatID, "Добро пожаловать в магазин спортивного питания!\n\nВыберите нужное действие:")
+		},
+	})
 
+	routes.Callback(router.Route{ //последний активный заказ
+		Name:         "cart",
+		AuthRequired: true,
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID, user := ctx.Bot, ctx.Callback, ctx.ChatID, ctx.User
+			cart, err := orderRepo.DetailCart(ctx, int64(user.ID))
+			if err != nil {
+				log.Printf("Error loading cart: %v", err)
+				router.Reply(ctx, "Ошибка загрузки корзины!")
+				return
+			}
+			if cart == nil {
+				router.Reply(ctx, "Нет заказов!")
+				return
+			}
+			if formatCart(ctx, &cart.Order, cart.Items, productRepo) == "Пустая корзина" {
+				msg := tgbotapi.NewMessage(ChatID, "Пустая корзина")
+				msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+					tgbotapi.NewInlineKeyboardRow(
+						tgbotapi.NewInlineKeyboardButtonData("Добавить товары", "buyproducts"),
+					),
+					tgbotapi.NewInlineKeyboardRow(
+						tgbotapi.NewInlineKeyboardButtonData("Вернуться на главную", "start"),
+					),
+				)
+				bot.Send(msg)
+				bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+				return
+			}
+			response := "Ваш заказ:\n\n" + formatCart(ctx, &cart.Order, cart.Items, productRepo)
+			suggestions, err := recommendationRepo.ForCart(ctx, cart.Order.ID, RecommendationsLimit) //допродажа перед подтверждением
+			if err != nil {
+				log.Printf("Ошибка загрузки рекомендаций корзины: %v", err)
+			}
+			text, rows := formatRecommendations("Часто покупают вместе с вашими товарами:", suggestions)
+			msg := tgbotapi.NewMessage(ChatID, response+text)
 			keyboard := tgbotapi.NewInlineKeyboardMarkup(
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Подтвердить заказ", "confirm_order"),
+					tgbotapi.NewInlineKeyboardButtonData("Вернуться к покупкам", "buyproducts"),
+				))
+			if balance, err := loyaltyRepo.Balance(ctx, int64(user.ID)); err == nil && balance > 0 {
+				msg.Text += fmt.Sprintf("\n\nБонусных баллов: %.2f", balance)
+				keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Оплатить часть баллами", router.Callback("confirm_order", "points"))))
+			}
+			keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, rows...)
+			msg.ReplyMarkup = keyboard
+			bot.Send(msg)
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+
+	routes.Callback(router.Route{
+		Name: "help",
+		Handler: func(ctx *router.Context) {
+			router.Reply(ctx, helpText(routes))
+		},
+	})
+
+	routes.Callback(router.Route{ //главное меню
+		Name: "start",
+		Handler: func(ctx *router.Context) {
+			bot, callback, ChatID := ctx.Bot, ctx.Callback, ctx.ChatID
+			endConversationIn(ctx, ChatID, StateShopping)
+			msg := tgbotapi.NewMessage(ChatID, "Добро пожаловать в магазин спортивного питания!\n\nВыберите нужное действие:")
+			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
 				tgbotapi.NewInlineKeyboardRow(
 					tgbotapi.NewInlineKeyboardButtonData("Все товары", "products"),
 					tgbotapi.NewInlineKeyboardButtonData("Поиск товаров", "search_product"),
@@ -2196,6 +5621,7 @@ func handleCallback(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, prod
 				),
 				tgbotapi.NewInlineKeyboardRow(
 					tgbotapi.NewInlineKeyboardButtonData("Помощь по командам", "help"),
+					tgbotapi.NewInlineKeyboardButtonData("Профиль", router.Callback("profile", "show")),
 				),
 				tgbotapi.NewInlineKeyboardRow(
 					tgbotapi.NewInlineKeyboardButtonData("Мои заказы", "orders"),
@@ -2203,15 +5629,22 @@ func handleCallback(bot *tgbotapi.BotAPI, callback *tgbotapi.CallbackQuery, prod
 				),
 				tgbotapi.NewInlineKeyboardRow(
 					tgbotapi.NewInlineKeyboardButtonData("Выбрать товар для покупки", "buyproducts"),
+					tgbotapi.NewInlineKeyboardButtonData("Наборы", "bundles"),
+				),
+				tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonData("Бренды", "buybrands"),
 				),
 			)
-			msg.ReplyMarkup = keyboard
+			bot.Send(msg)
+			bot.Send(tgbotapi.NewCallback(callback.ID, ""))
+		},
+	})
+}
 
-		default:
-		}
-		bot.Send(msg)
-		callbackConfig := tgbotapi.NewCallback(callback.ID, "")
-		bot.Send(callbackConfig)
-		log.Printf("user_id: %d, username: %s, action: %s ", callback.From.ID, callback.From.FirstName, action)
-	}
+type pageSource struct { //источник данных одного типа пагинации
+	CountFunc      func(ctx context.Context) (int, error)                              //функция подсчёта товаров для пагинации
+	PaginationFunc func(ctx context.Context, limit, offset int) ([]interface{}, error) //пагинационная функция с лимитом данных и отступом offset
+	formatFunc     func(interface{}) string
+	title          string
+	showKeyboard   bool
 }
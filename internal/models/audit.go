@@ -0,0 +1,79 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+// сущности журнала действий
+const (
+	AuditProduct  = "product"
+	AuditCategory = "category"
+	AuditBrand    = "brand"
+	AuditBundle   = "bundle"
+	AuditSale     = "sale"
+	AuditUser     = "user"
+	AuditOrder    = "order"
+	AuditSetting  = "setting"
+)
+
+type AuditChange struct { //значение поля до и после действия
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type AuditEntry struct { //запись журнала действий сотрудников
+	ID        int64                  `json:"id"`
+	ActorID   int64                  `json:"actor_id"` // 0 если сотрудник удалён из базы
+	Action    string                 `json:"action"`
+	Entity    string                 `json:"entity"`
+	EntityID  string                 `json:"entity_id"`
+	Changes   map[string]AuditChange `json:"changes"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+type AuditFilter struct { //отбор записей журнала, пустые поля не учитываются
+	Entity   string
+	EntityID string
+	ActorID  int64
+}
+
+// AuditDiff - поля, значения которых отличаются у before и after. Поля берутся по json-тегам,
+// поэтому скрытые от выгрузок (пароль, секрет второго фактора) в журнал не попадают.
+// nil вместо before или after - создание или удаление сущности
+func AuditDiff(before, after interface{}) (map[string]AuditChange, error) {
+	oldFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(map[string]AuditChange)
+	for field, value := range newFields {
+		if old, ok := oldFields[field]; !ok || !reflect.DeepEqual(old, value) {
+			changes[field] = AuditChange{Old: oldFields[field], New: value}
+		}
+	}
+	for field, old := range oldFields {
+		if _, ok := newFields[field]; !ok {
+			changes[field] = AuditChange{Old: old}
+		}
+	}
+	return changes, nil
+}
+
+func auditFields(value interface{}) (map[string]interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = json.Unmarshal(data, &fields) //для nil-указателя fields остаётся пустым
+	return fields, err
+}
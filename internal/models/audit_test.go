@@ -0,0 +1,70 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAuditDiff(t *testing.T) {
+	before := &User{ID: 1, FirstName: "Иван", Role: "customer", Password: "old-hash", TOTPSecret: "AAAA"}
+	changed := *before
+	changed.FirstName = "Пётр"
+	changed.Role = "admin"
+	changed.Password = "new-hash"
+	changed.TOTPSecret = "BBBB"
+	var nilUser *User
+
+	tests := []struct {
+		name   string
+		before interface{}
+		after  interface{}
+		want   map[string]AuditChange
+	}{
+		{name: "без изменений", before: before, after: before, want: map[string]AuditChange{}},
+		{
+			name:   "изменённые поля, пароль и секрет скрыты",
+			before: before,
+			after:  &changed,
+			want: map[string]AuditChange{
+				"first_name": {Old: "Иван", New: "Пётр"},
+				"role":       {Old: "customer", New: "admin"},
+			},
+		},
+		{
+			name:   "создание",
+			before: nil,
+			after:  map[string]interface{}{"name": "Протеин", "price": 1500},
+			want: map[string]AuditChange{
+				"name":  {New: "Протеин"},
+				"price": {New: float64(1500)},
+			},
+		},
+		{
+			name:   "удаление",
+			before: map[string]interface{}{"name": "Протеин"},
+			after:  nil,
+			want:   map[string]AuditChange{"name": {Old: "Протеин"}},
+		},
+		{
+			name:   "nil-указатель как удаление",
+			before: map[string]interface{}{"name": "Протеин"},
+			after:  nilUser,
+			want:   map[string]AuditChange{"name": {Old: "Протеин"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AuditDiff(tt.before, tt.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("AuditDiff = %#v, ожидалось %#v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := AuditDiff(nil, func() {}); err == nil {
+		t.Fatal("AuditDiff для несериализуемого значения без ошибки")
+	}
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+type Ban struct { //блокировка пользователя по Telegram ID
+	TelegramID int64      `json:"telegram_id"`
+	UserID     int64      `json:"user_id"` // 0 если аккаунт удалён
+	Reason     string     `json:"reason"`
+	BlockedBy  int64      `json:"blocked_by"`
+	BlockedAt  time.Time  `json:"blocked_at"`
+	ExpiresAt  *time.Time `json:"expires_at"` // nil - бессрочная блокировка
+	Notified   bool       `json:"notified"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type Brand struct {
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Country     string     `json:"country"`
+	LogoURL     string     `json:"logo_url"`
+	IsActive    bool       `json:"is_active"`
+	ArchivedAt  *time.Time `json:"archived_at"` // nil если бренд в каталоге
+	CreatedAt   time.Time  `json:"created_at"`
+}
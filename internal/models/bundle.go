@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type Bundle struct { //набор из нескольких товаров по общей цене
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Price       float64      `json:"price"`
+	IsActive    bool         `json:"is_active"`
+	ArchivedAt  *time.Time   `json:"archived_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Available   int          `json:"available"` // сколько наборов можно собрать из остатков
+	Items       []BundleItem `json:"items"`
+}
+
+type BundleItem struct { //товар в составе набора
+	BundleID    int    `json:"bundle_id"`
+	ProductID   int    `json:"product_id"`
+	ProductName string `json:"product_name"`
+	Flavor      string `json:"flavor"`
+	Quantity    int    `json:"quantity"`
+	Stock       int    `json:"stock"` // остаток товара на складе
+}
@@ -3,9 +3,10 @@ package models
 import "time"
 
 type Category struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	IsActive    bool      `json:"is_active"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	CreatedAt   time.Time  `json:"created_at"`
+	IsActive    bool       `json:"is_active"`
+	ArchivedAt  *time.Time `json:"archived_at"` // nil если категория в каталоге
 }
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Conversation struct { //текущий шаг диалога в чате
+	ChatID    int64           `json:"chat_id"`
+	State     string          `json:"state"`
+	Payload   json.RawMessage `json:"payload"` // данные шага, тип зависит от состояния
+	ExpiresAt time.Time       `json:"expires_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+func (c Conversation) Expired() bool {
+	return !time.Now().Before(c.ExpiresAt)
+}
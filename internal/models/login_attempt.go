@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// причины записи в журнале попыток входа
+const (
+	LoginOK       = "ok"
+	LoginPassword = "password"   // неверный пароль
+	LoginTOTP     = "totp"       // неверный код второго фактора
+	LoginNotFound = "not_found"  // аккаунт не найден
+	LoginBlocked  = "blocked"    // попытка во время задержки, в счётчиках не учитывается
+	LoginReset    = "reset"      // пароль восстановлен по коду
+	LoginBadReset = "reset_code" // неверный код восстановления
+)
+
+type LoginAttempt struct { //попытка входа по паролю
+	ID         int64     `json:"id"`
+	TelegramID int64     `json:"telegram_id"`
+	UserID     int64     `json:"user_id"` // 0 если аккаунт не найден
+	ChatID     int64     `json:"chat_id"`
+	FromID     int64     `json:"from_id"`
+	Success    bool      `json:"success"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
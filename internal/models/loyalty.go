@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// виды операций с бонусными баллами
+const (
+	LoyaltyAccrual  = "accrual"  // процент от доставленного заказа
+	LoyaltyReferral = "referral" // бонус за приглашение
+	LoyaltyRefund   = "refund"   // возврат баллов отменённого заказа
+	LoyaltyAdjust   = "adjust"   // ручная корректировка сотрудником
+	LoyaltyRedeem   = "redeem"   // оплата части заказа
+	LoyaltyExpire   = "expire"   // сгорание по сроку
+)
+
+type LoyaltyTransaction struct { //запись журнала бонусных баллов
+	ID        int64      `json:"id"`
+	UserID    int64      `json:"user_id"`
+	Amount    float64    `json:"amount"`    // начисление > 0, списание < 0
+	Remaining float64    `json:"remaining"` // неизрасходованный остаток начисления
+	Kind      string     `json:"kind"`
+	OrderID   int        `json:"order_id"` // 0 если операция не связана с заказом
+	Reason    string     `json:"reason"`
+	CreatedBy int64      `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
@@ -0,0 +1,10 @@
+package models
+
+type Nutrition struct { //пищевая ценность одной порции товара
+	ProductID   int     `json:"product_id"`
+	ServingSize float64 `json:"serving_size"` // граммов в порции
+	Protein     float64 `json:"protein"`
+	Carbs       float64 `json:"carbs"`
+	Fat         float64 `json:"fat"`
+	Calories    float64 `json:"calories"`
+}
@@ -2,6 +2,21 @@ package models
 
 import "time"
 
+// статусы заказа
+const (
+	OrderNew       = "new" // корзина
+	OrderConfirmed = "confirmed"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled" // товары возвращены на склад, баллы - покупателю
+)
+
+// OrderTransitions - статусы, в которые сотрудник может перевести заказ из текущего
+var OrderTransitions = map[string][]string{
+	OrderConfirmed: {OrderShipped, OrderDelivered, OrderCancelled},
+	OrderShipped:   {OrderDelivered, OrderCancelled},
+}
+
 type Order struct {
 	ID        int       `json:"id"`
 	UserID    int64     `json:"user_id"`
@@ -11,11 +26,14 @@ type Order struct {
 }
 
 type OrderItem struct {
-	ID        int     `json:"id"`
-	OrderID   int     `json:"order_id"`
-	ProductID int     `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
+	ID          int     `json:"id"`
+	OrderID     int     `json:"order_id"`
+	ProductID   int     `json:"product_id"`
+	Quantity    int     `json:"quantity"`
+	Price       float64 `json:"price"`
+	ProductName string  `json:"product_name"` // снимок названия на момент покупки
+	Flavor      string  `json:"flavor"`       // снимок вкуса на момент покупки
+	BundleID    int     `json:"bundle_id"`    // 0 если позиция - отдельный товар
 }
 
 type OrderWithItems struct { //В БД она не появится т к содержит абсолютно всю информацию из имеющихся данных: структуррирует данные
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type PasswordReset struct { //запрос на восстановление пароля
+	ID          int64      `json:"id"`
+	UserID      int64      `json:"user_id"`
+	CodeHash    string     `json:"-"`
+	Attempts    int        `json:"attempts"`
+	RequestedIn int64      `json:"requested_in"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"` // nil пока код не использован
+}
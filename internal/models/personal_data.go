@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+type PersonalData struct { //выгрузка персональных данных пользователя по /my_data
+	ExportedAt    time.Time            `json:"exported_at"`
+	Profile       User                 `json:"profile"`
+	Roles         []string             `json:"roles"`
+	Orders        []OrderWithItems     `json:"orders"`
+	Sessions      []Session            `json:"sessions"`
+	Subscriptions []StockSubscription  `json:"stock_subscriptions"`
+	LoginAttempts []LoginAttempt       `json:"login_attempts"`
+	Loyalty       []LoyaltyTransaction `json:"loyalty_transactions"`
+}
@@ -3,16 +3,34 @@ package models
 import "time"
 
 type Product struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	Quantity    int       `json:"quantity"`
-	Category_id int       `json:"cateogry_id"`
-	Weight      float64   `json:"weight"`
-	Flavor      string    `json:"flavor"`
-	Brand       string    `json:"brand"`
-	Servings    int       `json:"servings"`
-	IsActive    bool      `json:"is_active"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Price       float64    `json:"price"`
+	Quantity    int        `json:"quantity"`
+	Category_id int        `json:"cateogry_id"`
+	Weight      float64    `json:"weight"`
+	Flavor      string     `json:"flavor"`
+	Brand       string     `json:"brand"`
+	Servings    int        `json:"servings"`
+	IsActive    bool       `json:"is_active"`
+	ImageURL    string     `json:"image_url"`
+	ArchivedAt  *time.Time `json:"archived_at"` // nil если товар в каталоге
+	CreatedAt   time.Time  `json:"created_at"`
+	SalePrice   float64    `json:"sale_price"` // цена по распродаже, 0 если распродажи нет
+}
+
+func (p Product) CurrentPrice() float64 { //цена, по которой товар продаётся сейчас
+	if p.SalePrice > 0 && p.SalePrice < p.Price {
+		return p.SalePrice
+	}
+	return p.Price
+}
+
+type PriceChange struct { //запись истории цен товара
+	ID        int       `json:"id"`
+	ProductID int       `json:"product_id"`
+	OldPrice  float64   `json:"old_price"`
+	NewPrice  float64   `json:"new_price"`
+	ChangedAt time.Time `json:"changed_at"`
 }
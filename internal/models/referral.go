@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// статусы приглашения
+const (
+	ReferralPending  = "pending"  // приглашённый ещё не получил доставленный заказ
+	ReferralRewarded = "rewarded" // бонусы начислены обоим
+	ReferralRejected = "rejected" // бонус не положен: злоупотребление
+)
+
+type Referral struct { //приглашение пользователя по реферальной ссылке
+	ID                int64      `json:"id"`
+	ReferrerID        int64      `json:"referrer_id"`
+	RefereeID         int64      `json:"referee_id"`
+	RefereeTelegramID int64      `json:"referee_telegram_id"`
+	Status            string     `json:"status"`
+	OrderID           int        `json:"order_id"` // 0 пока бонус не начислен
+	ReferrerBonus     float64    `json:"referrer_bonus"`
+	RefereeBonus      float64    `json:"referee_bonus"`
+	CreatedAt         time.Time  `json:"created_at"`
+	RewardedAt        *time.Time `json:"rewarded_at"`
+}
+
+type ReferrerStats struct { //строка отчёта по пригласившим
+	UserID    int64   `json:"user_id"`
+	FirstName string  `json:"first_name"`
+	Invited   int     `json:"invited"`
+	Rewarded  int     `json:"rewarded"`
+	Bonus     float64 `json:"bonus"`
+}
@@ -0,0 +1,22 @@
+package models
+
+// именованные разрешения, роли получают их через role_permissions
+const (
+	PermCatalogWrite = "catalog.write"
+	PermOrdersManage = "orders.manage"
+	PermUsersManage  = "users.manage"
+	PermReportsView  = "reports.view"
+	PermRolesManage  = "roles.manage"
+	PermSettings     = "settings.manage"
+	PermLoyalty      = "loyalty.manage"
+	PermAuditView    = "audit.view"
+)
+
+const RoleOwner = "owner" // роль владельца нельзя снять с последнего владельца
+
+type Role struct { //роль сотрудника с набором разрешений
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Priority    int      `json:"priority"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+const (
+	SaleTargetProduct  = "product"
+	SaleTargetCategory = "category"
+	SaleTargetBrand    = "brand"
+)
+
+type Sale struct { //запланированная распродажа товара, категории или бренда
+	ID              int       `json:"id"`
+	TargetType      string    `json:"target_type"`      // product, category, brand
+	Target          string    `json:"target"`           // ID товара, категории или бренда
+	SalePrice       float64   `json:"sale_price"`       // фиксированная цена, 0 если скидка в процентах
+	DiscountPercent float64   `json:"discount_percent"` // скидка в процентах, 0 если фиксированная цена
+	StartsAt        time.Time `json:"starts_at"`
+	EndsAt          time.Time `json:"ends_at"`
+	IsActive        bool      `json:"is_active"`
+	CreatedAt       time.Time `json:"created_at"`
+}
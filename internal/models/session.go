@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type Session struct { //сессия пользователя в чате
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	ChatID     int64      `json:"chat_id"`
+	FromID     int64      `json:"from_id"` // Telegram ID отправителя, вошедшего в чате
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"` // nil пока сессия не завершена
+}
+
+func (s Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
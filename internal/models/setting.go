@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+type Setting struct { //настройка магазина
+	Key         string    `json:"key"`
+	Value       string    `json:"value"`
+	Description string    `json:"description"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+type StockSubscription struct { //подписка на поступление товара
+	ID        int       `json:"id"`
+	UserID    int64     `json:"user_id"`
+	ProductID int       `json:"product_id"`
+	ChatID    int64     `json:"chat_id"` // куда отправлять уведомление
+	CreatedAt time.Time `json:"created_at"`
+}
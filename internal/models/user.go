@@ -10,6 +10,14 @@ type User struct {
 	Phone      string    `json:"phone"`
 	Email      string    `json:"email"`
 	Role       string    `json:"role"`
-	Password   string    `json:"password"`
+	Password   string    `json:"-"` // хэш пароля не попадает в выгрузки
 	CreatedAt  time.Time `json:"created_at"`
+
+	TOTPSecret  string `json:"-"`            // секрет второго фактора
+	TOTPEnabled bool   `json:"totp_enabled"` // вход по паролю требует код
+
+	Address       string `json:"delivery_address"` // адрес доставки по умолчанию
+	PhoneVerified bool   `json:"phone_verified"`   // телефон получен из контакта самого пользователя
+	NotifyRestock bool   `json:"notify_restock"`   // уведомления о поступлении товара
+	NotifyOrders  bool   `json:"notify_orders"`    // уведомления о статусе заказов
 }
@@ -0,0 +1,76 @@
+package polling
+
+import (
+	"context"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const retryDelay = 3 * time.Second //пауза после ошибки getUpdates, как у GetUpdatesChan
+
+// Updates - long polling как bot.GetUpdatesChan, но канал закрывается сразу после отмены ctx,
+// а не после очередного запроса, который может ждать до timeout секунд
+func Updates(ctx context.Context, bot *tgbotapi.BotAPI, timeout int) tgbotapi.UpdatesChannel {
+	ch := make(chan tgbotapi.Update, bot.Buffer)
+	config := tgbotapi.NewUpdate(0)
+	config.Timeout = timeout
+
+	type result struct {
+		updates []tgbotapi.Update
+		err     error
+	}
+	go func() {
+		defer func() { confirm(bot, config.Offset) }()
+		defer close(ch) //закрывается первым: обработчики не ждут подтверждения
+		for {
+			done := make(chan result, 1)
+			go func(config tgbotapi.UpdateConfig) { //запрос не умеет отмену, при остановке его ответ просто не читается
+				updates, err := bot.GetUpdates(config)
+				done <- result{updates, err}
+			}(config)
+
+			var res result
+			select {
+			case <-ctx.Done():
+				return
+			case res = <-done:
+			}
+			if res.err != nil {
+				log.Printf("Ошибка получения обновлений, повтор через %s: %v", retryDelay, res.err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(retryDelay):
+				}
+				continue
+			}
+			for _, update := range res.updates {
+				if update.UpdateID < config.Offset {
+					continue
+				}
+				select {
+				case ch <- update:
+					config.Offset = update.UpdateID + 1 //подтверждаются только переданные обработчикам
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+// confirm подтверждает Telegram полученные обновления: без этого после перезапуска они придут повторно.
+// Запрос с новым offset заодно прерывает незавершённый long polling
+func confirm(bot *tgbotapi.BotAPI, offset int) {
+	if offset == 0 {
+		return
+	}
+	config := tgbotapi.NewUpdate(offset)
+	config.Limit = 1
+	if _, err := bot.GetUpdates(config); err != nil {
+		log.Printf("Ошибка подтверждения обновлений: %v", err)
+	}
+}
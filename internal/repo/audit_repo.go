@@ -0,0 +1,99 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"strings"
+)
+
+type AuditRepo struct {
+	db *sql.DB
+}
+
+func NewAuditRepo(db *sql.DB) *AuditRepo {
+	return &AuditRepo{db: db}
+}
+
+func (r *AuditRepo) Record(ctx context.Context, entry *models.AuditEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO audit_log (actor_id, action, entity, entity_id, changes)
+		VALUES (NULLIF($1, 0), $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		entry.ActorID, entry.Action, entry.Entity, entry.EntityID, changes,
+	).Scan(&entry.ID, &entry.CreatedAt)
+	if err != nil {
+		log.Printf("Ошибка записи в журнал действий: %v", err)
+		return err
+	}
+	return nil
+}
+
+func auditWhere(filter models.AuditFilter) (string, []interface{}) { //условие отбора по фильтру
+	var conditions []string
+	var args []interface{}
+	if filter.Entity != "" {
+		args = append(args, filter.Entity)
+		conditions = append(conditions, fmt.Sprintf("entity = $%d", len(args)))
+	}
+	if filter.EntityID != "" {
+		args = append(args, filter.EntityID)
+		conditions = append(conditions, fmt.Sprintf("entity_id = $%d", len(args)))
+	}
+	if filter.ActorID != 0 {
+		args = append(args, filter.ActorID)
+		conditions = append(conditions, fmt.Sprintf("actor_id = $%d", len(args)))
+	}
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+func (r *AuditRepo) PaginateEntries(ctx context.Context, filter models.AuditFilter, limit, offset int) ([]models.AuditEntry, error) {
+	where, args := auditWhere(filter)
+	query := fmt.Sprintf(`
+		SELECT id, COALESCE(actor_id, 0), action, entity, entity_id, changes, created_at
+		FROM audit_log
+		%s
+		ORDER BY created_at DESC, id DESC
+		LIMIT $%d OFFSET $%d`, where, len(args)+1, len(args)+2)
+
+	rows, err := r.db.QueryContext(ctx, query, append(args, limit, offset)...)
+	if err != nil {
+		log.Printf("Ошибка загрузки журнала действий: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var changes []byte
+		err := rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.Entity,
+			&entry.EntityID, &changes, &entry.CreatedAt)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r *AuditRepo) CountEntries(ctx context.Context, filter models.AuditFilter) (int, error) { //подсчёт записей для пагинации
+	where, args := auditWhere(filter)
+	var count int
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM audit_log `+where, args...).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"time"
+)
+
+type BanRepo struct {
+	db *sql.DB
+}
+
+func NewBanRepo(db *sql.DB) *BanRepo {
+	return &BanRepo{db: db}
+}
+
+const banColumns = `SELECT telegram_id, COALESCE(user_id, 0), reason, COALESCE(blocked_by, 0), blocked_at, expires_at, notified FROM bans`
+
+func scanBan(scanner interface{ Scan(...interface{}) error }, ban *models.Ban) error {
+	return scanner.Scan(
+		&ban.TelegramID, &ban.UserID, &ban.Reason, &ban.BlockedBy,
+		&ban.BlockedAt, &ban.ExpiresAt, &ban.Notified,
+	)
+}
+
+// Ban блокирует Telegram ID; повторная блокировка заменяет срок и причину. duration 0 - бессрочно
+func (r *BanRepo) Ban(ctx context.Context, ban *models.Ban, duration time.Duration) error {
+	query := `
+		INSERT INTO bans (telegram_id, user_id, reason, blocked_by, expires_at)
+		VALUES ($1, NULLIF($2, 0), $3, NULLIF($4, 0),
+			CASE WHEN $5::float8 > 0 THEN NOW() + $5::float8 * INTERVAL '1 second' END)
+		ON CONFLICT (telegram_id) DO UPDATE
+		SET user_id = EXCLUDED.user_id, reason = EXCLUDED.reason, blocked_by = EXCLUDED.blocked_by,
+		    blocked_at = NOW(), expires_at = EXCLUDED.expires_at, notified = false
+		RETURNING blocked_at, expires_at`
+	err := r.db.QueryRowContext(ctx,
+		query, ban.TelegramID, ban.UserID, ban.Reason, ban.BlockedBy, duration.Seconds(),
+	).Scan(&ban.BlockedAt, &ban.ExpiresAt)
+	if err != nil {
+		log.Printf("Ошибка блокировки пользователя: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *BanRepo) Unban(ctx context.Context, TelegramID int64) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM bans WHERE telegram_id = $1`, TelegramID)
+	if err != nil {
+		log.Printf("Ошибка разблокировки пользователя: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("пользователь с Telegram ID %d не заблокирован", TelegramID)
+	}
+	return nil
+}
+
+// ActiveBan - действующая блокировка, nil если её нет или срок истёк
+func (r *BanRepo) ActiveBan(ctx context.Context, TelegramID int64) (*models.Ban, error) {
+	var ban models.Ban
+	row := r.db.QueryRowContext(ctx, banColumns+`
+		WHERE telegram_id = $1 AND (expires_at IS NULL OR expires_at > NOW())`, TelegramID)
+	if err := scanBan(row, &ban); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &ban, nil
+}
+
+// MarkNotified отмечает, что пользователь получил сообщение о блокировке. true - если отметка поставлена этим вызовом
+func (r *BanRepo) MarkNotified(ctx context.Context, TelegramID int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `UPDATE bans SET notified = true WHERE telegram_id = $1 AND NOT notified`, TelegramID)
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, _ := result.RowsAffected()
+	return rowsAffected > 0, nil
+}
+
+func (r *BanRepo) ActiveBans(ctx context.Context) ([]models.Ban, error) {
+	rows, err := r.db.QueryContext(ctx, banColumns+`
+		WHERE expires_at IS NULL OR expires_at > NOW()
+		ORDER BY blocked_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bans []models.Ban
+	for rows.Next() {
+		var ban models.Ban
+		if err := scanBan(rows, &ban); err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
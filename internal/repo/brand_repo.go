@@ -0,0 +1,237 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"strings"
+	"time"
+)
+
+type BrandRepo struct {
+	db *sql.DB
+}
+
+func NewBrandRepo(db *sql.DB) *BrandRepo {
+	return &BrandRepo{db: db}
+}
+
+const brandColumns = `SELECT id, name, COALESCE(description, ''), COALESCE(country, ''), COALESCE(logo_url, ''),
+	is_active, created_at, archived_at
+	FROM brands`
+
+func scanBrands(rows *sql.Rows) ([]models.Brand, error) {
+	var brands []models.Brand
+	for rows.Next() {
+		var brand models.Brand
+		err := rows.Scan(
+			&brand.ID, &brand.Name, &brand.Description, &brand.Country, &brand.LogoURL,
+			&brand.IsActive, &brand.CreatedAt, &brand.ArchivedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		brands = append(brands, brand)
+	}
+	return brands, nil
+}
+
+func (r *BrandRepo) CreateBrand(ctx context.Context, brand *models.Brand) error {
+	query := `
+		INSERT INTO brands (name, description, country, logo_url, is_active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`
+	err := r.db.QueryRowContext(ctx,
+		query, brand.Name, brand.Description, brand.Country,
+		brand.LogoURL, brand.IsActive).Scan(&brand.ID, &brand.CreatedAt)
+
+	if err != nil {
+		log.Printf("Ошибка создания бренда: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *BrandRepo) AllBrands(ctx context.Context) ([]models.Brand, error) {
+	rows, err := r.db.QueryContext(ctx, brandColumns+`
+		WHERE is_active = true AND archived_at IS NULL
+		ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanBrands(rows)
+}
+
+func (r *BrandRepo) SearchBrand(ctx context.Context, query string) ([]models.Brand, error) {
+	rows, err := r.db.QueryContext(ctx, brandColumns+`
+		WHERE (name ILIKE '%' || $1 || '%'
+		OR description ILIKE '%' || $1 || '%'
+		OR country ILIKE '%' || $1 || '%'
+		OR id::text = $1)
+		AND archived_at IS NULL
+		ORDER BY id`, query)
+	if err != nil {
+		log.Printf("Ошибка: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+	return scanBrands(rows)
+}
+
+func (r *BrandRepo) BrandByName(ctx context.Context, name string) (*models.Brand, error) { //точное совпадение без учёта регистра
+	rows, err := r.db.QueryContext(ctx, brandColumns+`
+		WHERE LOWER(name) = LOWER($1) AND archived_at IS NULL`, strings.TrimSpace(name))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	brands, err := scanBrands(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(brands) == 0 {
+		return nil, fmt.Errorf("бренд %s не найден", name)
+	}
+	return &brands[0], nil
+}
+
+// UpdateBrand меняет бренд и название бренда у его товаров
+func (r *BrandRepo) UpdateBrand(ctx context.Context, brand *models.Brand) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE brands
+		SET name = $2, description = $3, country = $4, logo_url = $5, is_active = $6
+		WHERE id = $1`,
+		brand.ID, brand.Name, brand.Description, brand.Country, brand.LogoURL, brand.IsActive)
+	if err != nil {
+		log.Printf("Ошибка обновления бренда: %v", err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE products SET brand = $2 WHERE brand_id = $1`, brand.ID, brand.Name)
+	if err != nil {
+		log.Printf("Ошибка обновления бренда товаров: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// ArchiveBrand архивирует бренд вместе с его товарами одной транзакцией
+func (r *BrandRepo) ArchiveBrand(ctx context.Context, brandID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var archivedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		UPDATE brands SET was_active = is_active, archived_at = NOW(), is_active = false
+		WHERE id = $1 AND archived_at IS NULL
+		RETURNING archived_at`, brandID).Scan(&archivedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("бренд с ID %d не найден", brandID)
+		}
+		log.Printf("Ошибка архивации бренда: %v", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products SET was_active = is_active, archived_at = $2, is_active = false
+		WHERE brand_id = $1 AND archived_at IS NULL`, brandID, archivedAt)
+	if err != nil {
+		log.Printf("Ошибка архивации товаров бренда: %v", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM order_items
+		USING orders, products
+		WHERE order_items.order_id = orders.id AND orders.status = 'new'
+		  AND order_items.product_id = products.id AND products.brand_id = $1`, brandID)
+	if err != nil {
+		log.Printf("Ошибка удаления товаров бренда из корзин: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestoreBrand восстанавливает бренд и товары, архивированные вместе с ним, с прежней видимостью.
+// Товары архивной категории остаются в архиве
+func (r *BrandRepo) RestoreBrand(ctx context.Context, brandID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var archivedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT archived_at FROM brands
+		WHERE id = $1 AND archived_at IS NOT NULL
+		FOR UPDATE`, brandID).Scan(&archivedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("бренд с ID %d не найден в архиве", brandID)
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE brands SET archived_at = NULL, is_active = COALESCE(was_active, true), was_active = NULL
+		WHERE id = $1`, brandID)
+	if err != nil {
+		log.Printf("Ошибка восстановления бренда: %v", err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products SET archived_at = NULL, is_active = COALESCE(was_active, true), was_active = NULL
+		WHERE brand_id = $1 AND archived_at = $2
+		  AND NOT EXISTS (SELECT 1 FROM categories WHERE categories.id = products.category_id AND categories.archived_at IS NOT NULL)`,
+		brandID, archivedAt)
+	if err != nil {
+		log.Printf("Ошибка восстановления товаров бренда: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *BrandRepo) ArchivedBrands(ctx context.Context) ([]models.Brand, error) {
+	rows, err := r.db.QueryContext(ctx, brandColumns+`
+		WHERE archived_at IS NOT NULL
+		ORDER BY archived_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanBrands(rows)
+}
+
+func (r *BrandRepo) PaginateBrands(ctx context.Context, limit, offset int) ([]models.Brand, error) {
+	rows, err := r.db.QueryContext(ctx, brandColumns+`
+		WHERE is_active = true AND archived_at IS NULL
+		ORDER BY name ASC, id ASC
+		LIMIT $1 OFFSET $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanBrands(rows)
+}
+
+func (r *BrandRepo) CountBrands(ctx context.Context) (int, error) { //подсчёт брендов для пагинации
+	query := `SELECT COUNT(*) FROM brands WHERE is_active = true AND archived_at IS NULL`
+	var count int
+	err := r.db.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
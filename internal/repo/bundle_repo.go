@@ -0,0 +1,179 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+)
+
+type BundleRepo struct {
+	db *sql.DB
+}
+
+func NewBundleRepo(db *sql.DB) *BundleRepo {
+	return &BundleRepo{db: db}
+}
+
+// bundleColumns - поля набора и количество наборов, которое можно собрать из остатков.
+// Архивный или неактивный товар в составе делает набор недоступным
+const bundleColumns = `
+	SELECT bundles.id, bundles.name, COALESCE(bundles.description, ''), bundles.price,
+		bundles.is_active, bundles.created_at,
+		COALESCE(MIN(CASE
+			WHEN products.is_active AND products.archived_at IS NULL THEN products.quantity / bundle_items.quantity
+			ELSE 0
+		END), 0)
+	FROM bundles
+	LEFT JOIN bundle_items ON bundle_items.bundle_id = bundles.id
+	LEFT JOIN products ON products.id = bundle_items.product_id`
+
+func (r *BundleRepo) CreateBundle(ctx context.Context, bundle *models.Bundle) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO bundles (name, description, price, is_active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at`,
+		bundle.Name, bundle.Description, bundle.Price, bundle.IsActive).Scan(&bundle.ID, &bundle.CreatedAt)
+	if err != nil {
+		log.Printf("Ошибка создания набора: %v", err)
+		return err
+	}
+	for _, item := range bundle.Items {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO bundle_items (bundle_id, product_id, quantity)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (bundle_id, product_id) DO UPDATE SET quantity = bundle_items.quantity + $3`,
+			bundle.ID, item.ProductID, item.Quantity)
+		if err != nil {
+			log.Printf("Ошибка добавления товара %d в набор: %v", item.ProductID, err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *BundleRepo) SearchBundle(ctx context.Context, bundleID int) (*models.Bundle, error) { //набор с составом
+	query := bundleColumns + `
+		WHERE bundles.id = $1 AND bundles.archived_at IS NULL
+		GROUP BY bundles.id`
+	var bundle models.Bundle
+	err := r.db.QueryRowContext(ctx, query, bundleID).Scan(
+		&bundle.ID, &bundle.Name, &bundle.Description, &bundle.Price,
+		&bundle.IsActive, &bundle.CreatedAt, &bundle.Available,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("набор с ID %d не найден", bundleID)
+		}
+		return nil, err
+	}
+
+	bundle.Items, err = r.BundleItems(ctx, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
+
+func (r *BundleRepo) BundleItems(ctx context.Context, bundleID int) ([]models.BundleItem, error) {
+	query := `
+		SELECT bundle_items.bundle_id, bundle_items.product_id, products.name, products.flavor,
+			bundle_items.quantity, products.quantity
+		FROM bundle_items
+		JOIN products ON products.id = bundle_items.product_id
+		WHERE bundle_items.bundle_id = $1
+		ORDER BY bundle_items.product_id`
+
+	rows, err := r.db.QueryContext(ctx, query, bundleID)
+	if err != nil {
+		log.Printf("Ошибка загрузки состава набора: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.BundleItem
+	for rows.Next() {
+		var item models.BundleItem
+		err := rows.Scan(
+			&item.BundleID, &item.ProductID, &item.ProductName, &item.Flavor,
+			&item.Quantity, &item.Stock,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (r *BundleRepo) PaginateBundles(ctx context.Context, limit, offset int) ([]models.Bundle, error) {
+	query := bundleColumns + `
+		WHERE bundles.is_active = true AND bundles.archived_at IS NULL
+		GROUP BY bundles.id
+		ORDER BY bundles.created_at ASC, bundles.id ASC
+		LIMIT $1 OFFSET $2`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var bundles []models.Bundle
+	for rows.Next() {
+		var bundle models.Bundle
+		err := rows.Scan(
+			&bundle.ID, &bundle.Name, &bundle.Description, &bundle.Price,
+			&bundle.IsActive, &bundle.CreatedAt, &bundle.Available,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		bundles = append(bundles, bundle)
+	}
+	return bundles, nil
+}
+
+func (r *BundleRepo) CountBundles(ctx context.Context) (int, error) { //подсчёт наборов для пагинации
+	query := `SELECT COUNT(*) FROM bundles WHERE is_active = true AND archived_at IS NULL`
+	var count int
+	err := r.db.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
+
+func (r *BundleRepo) ArchiveBundle(ctx context.Context, bundleID int) error { //набор убирается из каталога, заказы с ним сохраняются
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE bundles SET archived_at = NOW(), is_active = false
+		WHERE id = $1 AND archived_at IS NULL`, bundleID)
+	if err != nil {
+		log.Printf("Ошибка архивации набора: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("набор с ID %d не найден", bundleID)
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM order_items
+		USING orders
+		WHERE order_items.order_id = orders.id AND orders.status = 'new' AND order_items.bundle_id = $1`, bundleID)
+	if err != nil {
+		log.Printf("Ошибка удаления набора из корзин: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,9 +1,12 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"project/internal/models"
+	"time"
 )
 
 type CategoryRepo struct {
@@ -14,12 +17,12 @@ func NewCategoryRepo(db *sql.DB) *CategoryRepo {
 	return &CategoryRepo{db: db}
 }
 
-func (r *CategoryRepo) CreateCategory(category *models.Category) error {
+func (r *CategoryRepo) CreateCategory(ctx context.Context, category *models.Category) error {
 	query := `
 		INSERT INTO categories (name, description, is_active)
 		VALUES ($1, $2, $3)
 		RETURNING id, created_at`
-	err := r.db.QueryRow(
+	err := r.db.QueryRowContext(ctx,
 		query, category.Name, category.Description,
 		category.IsActive).Scan(&category.ID, &category.CreatedAt)
 
@@ -30,13 +33,13 @@ func (r *CategoryRepo) CreateCategory(category *models.Category) error {
 	return nil
 }
 
-func (r *CategoryRepo) AllCategories() ([]models.Category, error) {
+func (r *CategoryRepo) AllCategories(ctx context.Context) ([]models.Category, error) {
 	query := `SELECT id, name, description, created_at, is_active
 		FROM categories 
 		WHERE is_active = true
 		ORDER BY id`
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -57,15 +60,16 @@ func (r *CategoryRepo) AllCategories() ([]models.Category, error) {
 	return categories, nil
 }
 
-func (r *CategoryRepo) SearchCategory(query string) ([]models.Category, error) {
+func (r *CategoryRepo) SearchCategory(ctx context.Context, query string) ([]models.Category, error) {
 	searchQuery := `
 	SELECT id, name, description, created_at, is_active
 	FROM categories 	
-	WHERE name ILIKE '%' || $1 || '%' 
+	WHERE (name ILIKE '%' || $1 || '%' 
 	OR description ILIKE '%' || $1 || '%'  
-	OR id::text = $1
+	OR id::text = $1)
+	AND archived_at IS NULL
 	ORDER BY id`
-	rows, err := r.db.Query(searchQuery, query)
+	rows, err := r.db.QueryContext(ctx, searchQuery, query)
 	if err != nil {
 		log.Panic("Ошибка: ", err)
 		return nil, err
@@ -87,12 +91,12 @@ func (r *CategoryRepo) SearchCategory(query string) ([]models.Category, error) {
 	return categories, nil
 }
 
-func (r *CategoryRepo) UpdateCategory(category *models.Category) error {
+func (r *CategoryRepo) UpdateCategory(ctx context.Context, category *models.Category) error {
 	query := `
 		update categories
 		set name = $2, description = $3, is_active = $4
 		WHERE id = $1`
-	_, err := r.db.Exec(
+	_, err := r.db.ExecContext(ctx,
 		query, category.ID, category.Name, category.Description,
 		category.IsActive,
 	)
@@ -103,19 +107,172 @@ func (r *CategoryRepo) UpdateCategory(category *models.Category) error {
 	return nil
 }
 
-func (r *CategoryRepo) DeleteCategory(categoryID int) error {
-	query := `DELETE FROM categories WHERE id = $1`
+// DeletionImpact - сколько активных товаров и открытых корзин затронет удаление категории
+func (r *CategoryRepo) DeletionImpact(ctx context.Context, categoryID int) (products int, carts int, err error) {
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM products
+			 WHERE category_id = $1 AND is_active = true AND archived_at IS NULL),
+			(SELECT COUNT(DISTINCT orders.id) FROM orders
+			 JOIN order_items ON order_items.order_id = orders.id
+			 JOIN products ON products.id = order_items.product_id
+			 WHERE orders.status = 'new' AND products.category_id = $1)`
+	err = r.db.QueryRowContext(ctx, query, categoryID).Scan(&products, &carts)
+	return products, carts, err
+}
+
+// MoveAndDeleteCategory переносит все товары категории в другую и удаляет категорию одной транзакцией
+func (r *CategoryRepo) MoveAndDeleteCategory(ctx context.Context, categoryID, targetID int) error {
+	if categoryID == targetID {
+		return fmt.Errorf("нельзя перенести товары в удаляемую категорию")
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := r.db.Exec(query, categoryID)
+	err = tx.QueryRowContext(ctx, `
+		SELECT id FROM categories WHERE id = $1 AND archived_at IS NULL
+		FOR UPDATE`, targetID).Scan(&targetID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("категория с ID %d не найдена", targetID)
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE products SET category_id = $2 WHERE category_id = $1`, categoryID, targetID)
+	if err != nil {
+		log.Printf("Ошибка переноса товаров категории: %v", err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM sales WHERE target_type = 'category' AND target = $1::text`, categoryID)
+	if err != nil {
+		log.Printf("Ошибка удаления распродаж категории: %v", err)
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `DELETE FROM categories WHERE id = $1`, categoryID)
 	if err != nil {
 		log.Printf("Ошибка удаления категории: %v", err)
 		return err
 	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("категория с ID %d не найдена", categoryID)
+	}
+	return tx.Commit()
+}
+
+// ArchiveCategory архивирует категорию вместе с её товарами одной транзакцией
+func (r *CategoryRepo) ArchiveCategory(ctx context.Context, categoryID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	return nil
+	var archivedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		UPDATE categories SET was_active = is_active, archived_at = NOW(), is_active = false
+		WHERE id = $1 AND archived_at IS NULL
+		RETURNING archived_at`, categoryID).Scan(&archivedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("категория с ID %d не найдена", categoryID)
+		}
+		log.Printf("Ошибка архивации категории: %v", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products SET was_active = is_active, archived_at = $2, is_active = false
+		WHERE category_id = $1 AND archived_at IS NULL`, categoryID, archivedAt)
+	if err != nil {
+		log.Printf("Ошибка архивации товаров категории: %v", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM order_items
+		USING orders, products
+		WHERE order_items.order_id = orders.id AND orders.status = 'new'
+		  AND order_items.product_id = products.id AND products.category_id = $1`, categoryID)
+	if err != nil {
+		log.Printf("Ошибка удаления товаров категории из корзин: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestoreCategory восстанавливает категорию и товары, архивированные вместе с ней, с прежней видимостью.
+// Товары архивного бренда остаются в архиве
+func (r *CategoryRepo) RestoreCategory(ctx context.Context, categoryID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var archivedAt time.Time
+	err = tx.QueryRowContext(ctx, `
+		SELECT archived_at FROM categories
+		WHERE id = $1 AND archived_at IS NOT NULL
+		FOR UPDATE`, categoryID).Scan(&archivedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("категория с ID %d не найдена в архиве", categoryID)
+		}
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE categories SET archived_at = NULL, is_active = COALESCE(was_active, true), was_active = NULL
+		WHERE id = $1`, categoryID)
+	if err != nil {
+		log.Printf("Ошибка восстановления категории: %v", err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE products SET archived_at = NULL, is_active = COALESCE(was_active, true), was_active = NULL
+		WHERE category_id = $1 AND archived_at = $2
+		  AND NOT EXISTS (SELECT 1 FROM brands WHERE brands.id = products.brand_id AND brands.archived_at IS NOT NULL)`,
+		categoryID, archivedAt)
+	if err != nil {
+		log.Printf("Ошибка восстановления товаров категории: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *CategoryRepo) ArchivedCategories(ctx context.Context) ([]models.Category, error) {
+	query := `SELECT id, name, description, created_at, is_active, archived_at
+		FROM categories
+		WHERE archived_at IS NOT NULL
+		ORDER BY archived_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categories []models.Category
+	for rows.Next() {
+		var category models.Category
+		err := rows.Scan(
+			&category.ID, &category.Name, &category.Description,
+			&category.CreatedAt, &category.IsActive, &category.ArchivedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, nil
 }
 
-func (r *CategoryRepo) PaginateCategory(limit, offset int) ([]models.Category, error) {
+func (r *CategoryRepo) PaginateCategory(ctx context.Context, limit, offset int) ([]models.Category, error) {
 	query := `
 	SELECT id, name, description, created_at, is_active
         FROM categories
@@ -123,7 +280,7 @@ func (r *CategoryRepo) PaginateCategory(limit, offset int) ([]models.Category, e
         ORDER BY created_at ASC, id ASC
         LIMIT $1 OFFSET $2`
 
-	rows, err := r.db.Query(query, limit, offset)
+	rows, err := r.db.QueryContext(ctx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
@@ -145,9 +302,9 @@ func (r *CategoryRepo) PaginateCategory(limit, offset int) ([]models.Category, e
 	return categories, nil
 }
 
-func (r *CategoryRepo) CountCategories() (int, error) { //подсчёт категорий для пагинации
+func (r *CategoryRepo) CountCategories(ctx context.Context) (int, error) { //подсчёт категорий для пагинации
 	query := `SELECT COUNT(*) FROM categories WHERE is_active = true`
 	var count int
-	err := r.db.QueryRow(query).Scan(&count)
+	err := r.db.QueryRowContext(ctx, query).Scan(&count)
 	return count, err
 }
@@ -0,0 +1,64 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"project/internal/models"
+)
+
+type ConversationRepo struct {
+	db *sql.DB
+}
+
+func NewConversationRepo(db *sql.DB) *ConversationRepo {
+	return &ConversationRepo{db: db}
+}
+
+// Conversation возвращает состояние диалога чата вместе с истёкшим; nil если диалога нет
+func (r *ConversationRepo) Conversation(ctx context.Context, chatID int64) (*models.Conversation, error) {
+	var conversation models.Conversation
+	var payload []byte
+	err := r.db.QueryRowContext(ctx, `
+		SELECT chat_id, state, payload, expires_at, updated_at FROM conversations WHERE chat_id = $1`,
+		chatID).Scan(&conversation.ChatID, &conversation.State, &payload, &conversation.ExpiresAt, &conversation.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		log.Printf("Ошибка загрузки состояния диалога чата %d: %v", chatID, err)
+		return nil, err
+	}
+	conversation.Payload = payload
+	return &conversation, nil
+}
+
+// SaveConversation заменяет состояние диалога чата: у чата одновременно только один диалог
+func (r *ConversationRepo) SaveConversation(ctx context.Context, conversation *models.Conversation) error {
+	payload := []byte(conversation.Payload)
+	if len(payload) == 0 {
+		payload = []byte("{}")
+	}
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO conversations (chat_id, state, payload, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (chat_id) DO UPDATE
+		SET state = EXCLUDED.state, payload = EXCLUDED.payload, expires_at = EXCLUDED.expires_at, updated_at = NOW()
+		RETURNING updated_at`,
+		conversation.ChatID, conversation.State, payload, conversation.ExpiresAt,
+	).Scan(&conversation.UpdatedAt)
+	if err != nil {
+		log.Printf("Ошибка сохранения состояния диалога чата %d: %v", conversation.ChatID, err)
+	}
+	return err
+}
+
+func (r *ConversationRepo) DeleteConversation(ctx context.Context, chatID int64) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM conversations WHERE chat_id = $1`, chatID)
+	return err
+}
+
+func (r *ConversationRepo) DeleteExpired(ctx context.Context) error { //очистка брошенных диалогов фоновой задачей
+	_, err := r.db.ExecContext(ctx, `DELETE FROM conversations WHERE expires_at < NOW() - INTERVAL '1 day'`)
+	return err
+}
@@ -0,0 +1,108 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"time"
+)
+
+type LoginAttemptRepo struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepo(db *sql.DB) *LoginAttemptRepo {
+	return &LoginAttemptRepo{db: db}
+}
+
+const loginAttemptsRetention = 90 * 24 * time.Hour // сколько хранится журнал попыток входа
+
+func (r *LoginAttemptRepo) RecordAttempt(ctx context.Context, attempt *models.LoginAttempt) error {
+	query := `
+		INSERT INTO login_attempts (telegram_id, user_id, chat_id, from_id, success, reason)
+		VALUES ($1, NULLIF($2, 0), $3, $4, $5, $6)
+		RETURNING id, created_at`
+	err := r.db.QueryRowContext(ctx,
+		query, attempt.TelegramID, attempt.UserID, attempt.ChatID,
+		attempt.FromID, attempt.Success, attempt.Reason).Scan(&attempt.ID, &attempt.CreatedAt)
+	if err != nil {
+		log.Printf("Ошибка записи попытки входа: %v", err)
+		return err
+	}
+	return nil
+}
+
+// AccountFailures - неудачные попытки входа в аккаунт подряд за окно и время с последней из них
+func (r *LoginAttemptRepo) AccountFailures(ctx context.Context, telegramID int64, window time.Duration) (int, time.Duration, error) {
+	return r.failures(ctx, "telegram_id", telegramID, window)
+}
+
+// ChatFailures - неудачные попытки входа из чата в любые аккаунты подряд за окно
+func (r *LoginAttemptRepo) ChatFailures(ctx context.Context, chatID int64, window time.Duration) (int, time.Duration, error) {
+	return r.failures(ctx, "chat_id", chatID, window)
+}
+
+func (r *LoginAttemptRepo) failures(ctx context.Context, column string, value int64, window time.Duration) (int, time.Duration, error) {
+	//успешный вход сбрасывает счётчик, попытки во время задержки его не продлевают
+	query := fmt.Sprintf(`
+		SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MAX(created_at)), 0)
+		FROM login_attempts
+		WHERE %[1]s = $1 AND NOT success AND reason <> $3
+		  AND created_at > NOW() - $2 * INTERVAL '1 second'
+		  AND created_at > COALESCE((
+			SELECT MAX(created_at) FROM login_attempts WHERE %[1]s = $1 AND success), '-infinity')`, column)
+
+	var count int
+	var seconds float64
+	err := r.db.QueryRowContext(ctx, query, value, window.Seconds(), models.LoginBlocked).Scan(&count, &seconds)
+	if err != nil {
+		log.Printf("Ошибка подсчёта попыток входа: %v", err)
+		return 0, 0, err
+	}
+	return count, time.Duration(seconds * float64(time.Second)), nil
+}
+
+// UserAttempts - попытки входа в аккаунт и попытки, сделанные из его Telegram, для выгрузки данных
+func (r *LoginAttemptRepo) UserAttempts(ctx context.Context, userID, TelegramID int64) ([]models.LoginAttempt, error) {
+	query := `
+		SELECT id, telegram_id, COALESCE(user_id, 0), chat_id, from_id, success, reason, created_at
+		FROM login_attempts
+		WHERE user_id = $1 OR telegram_id = $2 OR from_id = $2
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, TelegramID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []models.LoginAttempt
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		err := rows.Scan(
+			&attempt.ID, &attempt.TelegramID, &attempt.UserID, &attempt.ChatID,
+			&attempt.FromID, &attempt.Success, &attempt.Reason, &attempt.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, nil
+}
+
+func (r *LoginAttemptRepo) DeleteOld(ctx context.Context) error { //очистка журнала для фоновой задачи
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM login_attempts WHERE created_at < NOW() - $1 * INTERVAL '1 second'`,
+		loginAttemptsRetention.Seconds())
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		log.Printf("Удалено старых попыток входа: %d", rowsAffected)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"project/internal/models"
+)
+
+type LoyaltyRepo struct {
+	db *sql.DB
+}
+
+func NewLoyaltyRepo(db *sql.DB) *LoyaltyRepo {
+	return &LoyaltyRepo{db: db}
+}
+
+// Balance - действующие баллы пользователя: остатки начислений, срок которых не истёк
+func (r *LoyaltyRepo) Balance(ctx context.Context, userID int64) (float64, error) {
+	var balance float64
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(remaining), 0) FROM loyalty_transactions
+		WHERE user_id = $1 AND remaining > 0 AND (expires_at IS NULL OR expires_at > NOW())`,
+		userID).Scan(&balance)
+	return balance, err
+}
+
+func (r *LoyaltyRepo) History(ctx context.Context, userID int64, limit int) ([]models.LoyaltyTransaction, error) { //limit 0 - вся история
+	query := `
+		SELECT id, user_id, amount, remaining, kind, COALESCE(order_id, 0), COALESCE(reason, ''),
+			COALESCE(created_by, 0), created_at, expires_at
+		FROM loyalty_transactions
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT NULLIF($2, 0)`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.LoyaltyTransaction
+	for rows.Next() {
+		var transaction models.LoyaltyTransaction
+		err := rows.Scan(
+			&transaction.ID, &transaction.UserID, &transaction.Amount, &transaction.Remaining,
+			&transaction.Kind, &transaction.OrderID, &transaction.Reason,
+			&transaction.CreatedBy, &transaction.CreatedAt, &transaction.ExpiresAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		history = append(history, transaction)
+	}
+	return history, nil
+}
+
+// AccrueForOrder начисляет процент от доставленного заказа; повторно за тот же заказ не начисляет.
+// Возвращает 0, если начислять нечего
+func (r *LoyaltyRepo) AccrueForOrder(ctx context.Context, orderID int) (float64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	percent, err := settingFloat(ctx, tx, "loyalty_accrual_percent")
+	if err != nil {
+		return 0, err
+	}
+	var userID int64
+	var amount float64
+	err = tx.QueryRowContext(ctx, `SELECT user_id, amount FROM orders WHERE id = $1 AND status = $2`,
+		orderID, models.OrderDelivered).Scan(&userID, &amount)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	points := math.Floor(amount*percent) / 100
+	if points <= 0 {
+		return 0, nil
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM loyalty_transactions WHERE order_id = $1 AND kind = $2)`,
+		orderID, models.LoyaltyAccrual).Scan(&exists)
+	if err != nil || exists {
+		return 0, err
+	}
+	err = creditPoints(ctx, tx, userID, points, models.LoyaltyAccrual, orderID,
+		fmt.Sprintf("%.0f%% от заказа #%d", percent, orderID), 0)
+	if err != nil {
+		return 0, err
+	}
+	return points, tx.Commit()
+}
+
+// Adjust - ручная корректировка сотрудником: положительная сумма начисляет, отрицательная списывает
+func (r *LoyaltyRepo) Adjust(ctx context.Context, userID int64, amount float64, reason string, createdBy int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if amount > 0 {
+		err = creditPoints(ctx, tx, userID, amount, models.LoyaltyAdjust, 0, reason, createdBy)
+	} else {
+		_, err = debitPoints(ctx, tx, userID, -amount, false, models.LoyaltyAdjust, 0, reason, createdBy)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExpirePoints списывает остатки начислений с истёкшим сроком, одной записью на пользователя
+func (r *LoyaltyRepo) ExpirePoints(ctx context.Context) error { //для фоновой задачи
+	result, err := r.db.ExecContext(ctx, `
+		WITH expired AS (
+			SELECT id, user_id, remaining FROM loyalty_transactions
+			WHERE remaining > 0 AND expires_at <= NOW()
+			FOR UPDATE
+		), cleared AS (
+			UPDATE loyalty_transactions SET remaining = 0
+			FROM expired WHERE loyalty_transactions.id = expired.id
+		)
+		INSERT INTO loyalty_transactions (user_id, amount, kind, reason)
+		SELECT user_id, -SUM(remaining), $1, 'Истёк срок действия баллов'
+		FROM expired
+		GROUP BY user_id`, models.LoyaltyExpire)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		log.Printf("Сгорели баллы у пользователей: %d", rowsAffected)
+	}
+	return nil
+}
+
+// creditPoints начисляет баллы внутри транзакции; срок действия берётся из настроек, 0 - бессрочно
+func creditPoints(ctx context.Context, tx *sql.Tx, userID int64, amount float64, kind string, orderID int, reason string, createdBy int64) error {
+	amount = math.Round(amount*100) / 100
+	if amount <= 0 {
+		return nil
+	}
+	days, err := settingFloat(ctx, tx, "loyalty_expiry_days")
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loyalty_transactions (user_id, amount, remaining, kind, order_id, reason, created_by, expires_at)
+		VALUES ($1, $2, $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0),
+			CASE WHEN $7::float8 > 0 THEN NOW() + $7::float8 * INTERVAL '1 day' END)`,
+		userID, amount, kind, orderID, reason, createdBy, days)
+	if err != nil {
+		log.Printf("Ошибка начисления баллов пользователю %d: %v", userID, err)
+	}
+	return err
+}
+
+// debitPoints списывает баллы с начислений, которые сгорят раньше всего.
+// partial - списать сколько есть, но не больше amount; иначе при нехватке баллов ошибка.
+// Возвращает списанную сумму
+func debitPoints(ctx context.Context, tx *sql.Tx, userID int64, amount float64, partial bool,
+	kind string, orderID int, reason string, createdBy int64) (float64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, remaining FROM loyalty_transactions
+		WHERE user_id = $1 AND remaining > 0 AND (expires_at IS NULL OR expires_at > NOW())
+		ORDER BY expires_at NULLS LAST, id
+		FOR UPDATE`, userID)
+	if err != nil {
+		return 0, err
+	}
+	type credit struct { //остатки в копейках, чтобы не накапливать ошибку округления
+		id        int64
+		remaining int64
+	}
+	var credits []credit
+	var available int64
+	for rows.Next() {
+		var c credit
+		var remaining float64
+		if err := rows.Scan(&c.id, &remaining); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		c.remaining = int64(math.Round(remaining * 100))
+		available += c.remaining
+		credits = append(credits, c)
+	}
+	rows.Close()
+
+	need := int64(math.Round(amount * 100))
+	if need > available {
+		if !partial {
+			return 0, fmt.Errorf("недостаточно баллов: доступно %.2f", float64(available)/100)
+		}
+		need = available
+	}
+	if need <= 0 {
+		return 0, nil
+	}
+
+	left := need
+	for _, c := range credits {
+		if left == 0 {
+			break
+		}
+		take := min(left, c.remaining)
+		_, err := tx.ExecContext(ctx, `UPDATE loyalty_transactions SET remaining = remaining - $2 WHERE id = $1`,
+			c.id, float64(take)/100)
+		if err != nil {
+			return 0, err
+		}
+		left -= take
+	}
+	spent := float64(need) / 100
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO loyalty_transactions (user_id, amount, kind, order_id, reason, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, 0), $5, NULLIF($6, 0))`,
+		userID, -spent, kind, orderID, reason, createdBy)
+	if err != nil {
+		log.Printf("Ошибка списания баллов пользователя %d: %v", userID, err)
+		return 0, err
+	}
+	return spent, nil
+}
@@ -1,8 +1,11 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"math"
 	"project/internal/models"
 )
 
@@ -14,26 +17,26 @@ func NewOrderRepo(db *sql.DB) *OrderRepo {
 	return &OrderRepo{db: db}
 }
 
-func (r *OrderRepo) CreateOrder(userID int64) (*models.Order, error) {
+func (r *OrderRepo) CreateOrder(ctx context.Context, userID int64) (*models.Order, error) {
 	query := `
         INSERT INTO orders (user_id, status) 
         VALUES ($1, 'new') 
         RETURNING id, user_id, amount, status, created_at`
 
 	var order models.Order
-	err := r.db.QueryRow(query, userID).Scan(
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
 		&order.ID, &order.UserID, &order.Amount, &order.Status, &order.CreatedAt,
 	)
 	return &order, err
 }
 
-func (r *OrderRepo) AllOrders() ([]models.Order, error) {
+func (r *OrderRepo) AllOrders(ctx context.Context) ([]models.Order, error) {
 	query := `
 	SELECT orders.id, orders.user_id, orders.amount, orders.status, orders.created_at
 	FROM orders 
 		ORDER BY created_at DESC`
 
-	rows, err := r.db.Query(query)
+	rows, err := r.db.QueryContext(ctx, query)
 	if err != nil {
 		log.Printf("Ошибка скана: %v", err)
 		return nil, err
@@ -55,13 +58,15 @@ func (r *OrderRepo) AllOrders() ([]models.Order, error) {
 	return orders, nil
 }
 
-func (r *OrderRepo) OrderItems(orderID int) ([]models.OrderItem, error) {
+func (r *OrderRepo) OrderItems(ctx context.Context, orderID int) ([]models.OrderItem, error) {
 	query := `
-		SELECT id, order_id, product_id, quantity, price
+		SELECT id, order_id, COALESCE(product_id, 0), quantity, price,
+			COALESCE(product_name, ''), COALESCE(flavor, ''), COALESCE(bundle_id, 0)
 		FROM order_items 
-		WHERE order_id = $1`
+		WHERE order_id = $1
+		ORDER BY id`
 
-	rows, err := r.db.Query(query, orderID)
+	rows, err := r.db.QueryContext(ctx, query, orderID)
 	if err != nil {
 		log.Printf("Ошибка скана: %v", err)
 		return nil, err
@@ -73,7 +78,7 @@ func (r *OrderRepo) OrderItems(orderID int) ([]models.OrderItem, error) {
 		var item models.OrderItem
 		err := rows.Scan(
 			&item.ID, &item.OrderID, &item.ProductID, &item.Quantity,
-			&item.Price,
+			&item.Price, &item.ProductName, &item.Flavor, &item.BundleID,
 		)
 		if err != nil {
 			log.Printf("Ошибка скана: %v", err)
@@ -84,13 +89,57 @@ func (r *OrderRepo) OrderItems(orderID int) ([]models.OrderItem, error) {
 	return items, nil
 }
 
-func (r *OrderRepo) UserOrder(userID int64) ([]models.Order, error) {
+// OrderItemComponents - состав наборов заказа: снимок на момент подтверждения,
+// а для корзины - текущий состав набора. Ключ - ID позиции заказа
+func (r *OrderRepo) OrderItemComponents(ctx context.Context, orderID int) (map[int][]models.BundleItem, error) {
+	query := `
+		SELECT order_item_components.order_item_id, order_items.bundle_id, order_item_components.product_id,
+			COALESCE(order_item_components.product_name, ''), COALESCE(order_item_components.flavor, ''),
+			order_item_components.quantity
+		FROM order_item_components
+		JOIN order_items ON order_items.id = order_item_components.order_item_id
+		WHERE order_items.order_id = $1
+		UNION ALL
+		SELECT order_items.id, order_items.bundle_id, bundle_items.product_id,
+			products.name, products.flavor, bundle_items.quantity * order_items.quantity
+		FROM order_items
+		JOIN bundle_items ON bundle_items.bundle_id = order_items.bundle_id
+		JOIN products ON products.id = bundle_items.product_id
+		WHERE order_items.order_id = $1
+		  AND NOT EXISTS (SELECT 1 FROM order_item_components WHERE order_item_id = order_items.id)
+		ORDER BY 1, 3`
+
+	rows, err := r.db.QueryContext(ctx, query, orderID)
+	if err != nil {
+		log.Printf("Ошибка загрузки состава наборов заказа: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	components := make(map[int][]models.BundleItem)
+	for rows.Next() {
+		var itemID int
+		var component models.BundleItem
+		err := rows.Scan(
+			&itemID, &component.BundleID, &component.ProductID,
+			&component.ProductName, &component.Flavor, &component.Quantity,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		components[itemID] = append(components[itemID], component)
+	}
+	return components, nil
+}
+
+func (r *OrderRepo) UserOrder(ctx context.Context, userID int64) ([]models.Order, error) {
 	query := `
         SELECT id, user_id, amount, status, created_at
         FROM orders 
         WHERE user_id = $1
         ORDER BY created_at DESC`
-	rows, err := r.db.Query(query, userID)
+	rows, err := r.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -111,36 +160,154 @@ func (r *OrderRepo) UserOrder(userID int64) ([]models.Order, error) {
 	return orders, nil
 }
 
-func (r *OrderRepo) ConfirmOrder(userID int64) (int, error) {
+// ConfirmOrder подтверждает корзину и резервирует остатки товаров,
+// в том числе товаров из наборов. Если чего-то не хватает - заказ не подтверждается.
+// usePoints - оплатить баллами часть заказа в пределах лимита из настроек; возвращает списанные баллы
+func (r *OrderRepo) ConfirmOrder(ctx context.Context, userID int64, usePoints bool) (int, float64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
 	SearchQuery := `
         SELECT id 
         FROM orders 
         WHERE user_id = $1 AND status = 'new' 
         ORDER BY created_at DESC 
-        LIMIT 1`
+        LIMIT 1
+        FOR UPDATE`
 
 	var orderID int
-	err := r.db.QueryRow(SearchQuery, userID).Scan(&orderID)
+	err = tx.QueryRowContext(ctx, SearchQuery, userID).Scan(&orderID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			log.Printf("нет активных заказов (со статусом 'new')")
 		}
-		return 0, err
+		return 0, 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO order_item_components (order_item_id, product_id, product_name, flavor, quantity)
+		SELECT order_items.id, products.id, products.name, products.flavor, bundle_items.quantity * order_items.quantity
+		FROM order_items
+		JOIN bundle_items ON bundle_items.bundle_id = order_items.bundle_id
+		JOIN products ON products.id = bundle_items.product_id
+		WHERE order_items.order_id = $1`, orderID)
+	if err != nil {
+		log.Printf("Ошибка сохранения состава наборов: %v", err)
+		return 0, 0, err
+	}
+
+	required, productIDs, err := orderStock(ctx, tx, orderID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(productIDs) == 0 {
+		return 0, 0, fmt.Errorf("корзина пуста")
+	}
+
+	for _, productID := range productIDs { //списание по возрастанию ID, чтобы параллельные заказы не блокировали друг друга
+		var name string
+		err = tx.QueryRowContext(ctx, `
+			UPDATE products SET quantity = quantity - $2
+			WHERE id = $1 AND quantity >= $2 AND is_active = true AND archived_at IS NULL
+			RETURNING name`, productID, required[productID]).Scan(&name)
+		if err == sql.ErrNoRows {
+			tx.QueryRowContext(ctx, `SELECT name FROM products WHERE id = $1`, productID).Scan(&name)
+			return 0, 0, fmt.Errorf("недостаточно товара «%s» на складе", name)
+		}
+		if err != nil {
+			log.Printf("Ошибка резервирования товара %d: %v", productID, err)
+			return 0, 0, err
+		}
+	}
+
+	var total, points float64
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(quantity * price), 0) FROM order_items WHERE order_id = $1`, orderID).Scan(&total)
+	if err != nil {
+		return 0, 0, err
+	}
+	if usePoints {
+		maxPercent, err := settingFloat(ctx, tx, "loyalty_redeem_max_percent")
+		if err != nil {
+			return 0, 0, err
+		}
+		maxPercent = math.Max(0, math.Min(maxPercent, 100)) //значение из БД могло быть изменено в обход SetSetting
+		points, err = debitPoints(ctx, tx, userID, math.Min(total, math.Floor(total*maxPercent)/100), true,
+			models.LoyaltyRedeem, orderID, fmt.Sprintf("Оплата заказа #%d", orderID), 0)
+		if err != nil {
+			return 0, 0, err
+		}
 	}
 
 	UpdateQuery := `
         UPDATE orders 
-        SET status = 'confirmed' 
+        SET status = 'confirmed',
+            amount = $2,
+            points_used = $3
         WHERE id = $1`
-	_, err = r.db.Exec(UpdateQuery, orderID)
+	_, err = tx.ExecContext(ctx, UpdateQuery, orderID, total-points, points)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
-	return orderID, nil
+	return orderID, points, tx.Commit()
 }
 
-func (r *OrderRepo) DetailCart(userID int64) (*models.OrderWithItems, error) {
+// orderStock - сколько каждого товара занимает заказ: отдельные позиции и состав наборов.
+// ID отсортированы, чтобы параллельные транзакции блокировали товары в одном порядке
+func orderStock(ctx context.Context, tx *sql.Tx, orderID int) (map[int]int, []int, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT product_id, SUM(quantity) FROM (
+			SELECT product_id, quantity FROM order_items
+			WHERE order_id = $1 AND product_id IS NOT NULL
+			UNION ALL
+			SELECT order_item_components.product_id, order_item_components.quantity
+			FROM order_item_components
+			JOIN order_items ON order_items.id = order_item_components.order_item_id
+			WHERE order_items.order_id = $1
+		) required
+		GROUP BY product_id
+		ORDER BY product_id`, orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	required := make(map[int]int)
+	var productIDs []int
+	for rows.Next() {
+		var productID, quantity int
+		if err := rows.Scan(&productID, &quantity); err != nil {
+			return nil, nil, err
+		}
+		required[productID] = quantity
+		productIDs = append(productIDs, productID)
+	}
+	return required, productIDs, rows.Err()
+}
+
+// returnStock возвращает на склад товары подтверждённого заказа; результат - ID товаров, которые снова появились в наличии
+func returnStock(ctx context.Context, tx *sql.Tx, orderID int) ([]int, error) {
+	required, productIDs, err := orderStock(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	var restocked []int
+	for _, productID := range productIDs {
+		ok, err := addStock(ctx, tx, productID, required[productID])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			restocked = append(restocked, productID)
+		}
+	}
+	return restocked, nil
+}
+
+func (r *OrderRepo) DetailCart(ctx context.Context, userID int64) (*models.OrderWithItems, error) {
 	query := `
         SELECT id, user_id, amount, status, created_at
         FROM orders 
@@ -149,7 +316,7 @@ func (r *OrderRepo) DetailCart(userID int64) (*models.OrderWithItems, error) {
         LIMIT 1`
 
 	var order models.Order
-	err := r.db.QueryRow(query, userID).Scan(
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
 		&order.ID, &order.UserID, &order.Amount, &order.Status, &order.CreatedAt,
 	)
 
@@ -161,7 +328,7 @@ func (r *OrderRepo) DetailCart(userID int64) (*models.OrderWithItems, error) {
 		return nil, err
 	}
 
-	items, err := r.OrderItems(order.ID)
+	items, err := r.OrderItems(ctx, order.ID)
 	if err != nil {
 		log.Printf("Ошибка получения товаров корзины: %v", err)
 		return nil, err
@@ -175,23 +342,33 @@ func (r *OrderRepo) DetailCart(userID int64) (*models.OrderWithItems, error) {
 	return OrderWithItems, nil
 }
 
-func (r *OrderRepo) AddItemToCart(orderID, productID int, quantity int, price float64) error {
+func (r *OrderRepo) AddItemToCart(ctx context.Context, orderID int, product models.Product, quantity int) error {
 	query := `
-        INSERT INTO order_items (order_id, product_id, quantity, price)
-        VALUES ($1, $2, $3, $4)
+        INSERT INTO order_items (order_id, product_id, quantity, price, product_name, flavor)
+        VALUES ($1, $2, $3, $4, $5, $6)
         ON CONFLICT (order_id, product_id) 
         DO UPDATE SET quantity = order_items.quantity + $3`
-	_, err := r.db.Exec(query, orderID, productID, quantity, price)
+	_, err := r.db.ExecContext(ctx, query, orderID, product.ID, quantity, product.CurrentPrice(), product.Name, product.Flavor)
 	return err
 }
 
-func (r *OrderRepo) SearchOrder(orderID int) (*models.Order, error) {
+func (r *OrderRepo) AddBundleToCart(ctx context.Context, orderID int, bundle models.Bundle, quantity int) error {
+	query := `
+        INSERT INTO order_items (order_id, bundle_id, quantity, price, product_name)
+        VALUES ($1, $2, $3, $4, $5)
+        ON CONFLICT (order_id, bundle_id)
+        DO UPDATE SET quantity = order_items.quantity + $3`
+	_, err := r.db.ExecContext(ctx, query, orderID, bundle.ID, quantity, bundle.Price, bundle.Name)
+	return err
+}
+
+func (r *OrderRepo) SearchOrder(ctx context.Context, orderID int) (*models.Order, error) {
 	query := `
         SELECT id, user_id, amount, status, created_at
         FROM orders 
         WHERE id = $1`
 	var order models.Order
-	err := r.db.QueryRow(query, orderID).Scan(
+	err := r.db.QueryRowContext(ctx, query, orderID).Scan(
 		&order.ID, &order.UserID, &order.Amount,
 		&order.Status, &order.CreatedAt)
 
@@ -206,7 +383,7 @@ func (r *OrderRepo) SearchOrder(orderID int) (*models.Order, error) {
 	return &order, nil
 }
 
-func (r *OrderRepo) PaginateOrders(limit, offset int) ([]models.Order, error) {
+func (r *OrderRepo) PaginateOrders(ctx context.Context, limit, offset int) ([]models.Order, error) {
 	query := `
         SELECT id, user_id, amount, status, created_at
         FROM orders
@@ -214,7 +391,7 @@ func (r *OrderRepo) PaginateOrders(limit, offset int) ([]models.Order, error) {
         ORDER BY created_at ASC, id ASC
         LIMIT $1 OFFSET $2`
 
-	rows, err := r.db.Query(query, limit, offset) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, query, limit, offset) //query для SELECT
 	if err != nil {
 		return nil, err
 	}
@@ -235,14 +412,14 @@ func (r *OrderRepo) PaginateOrders(limit, offset int) ([]models.Order, error) {
 	return orders, nil
 }
 
-func (r *OrderRepo) CountOrders() (int, error) { //подсчёт заказов для пагинации
+func (r *OrderRepo) CountOrders(ctx context.Context) (int, error) { //подсчёт заказов для пагинации
 	query := `SELECT COUNT(*) FROM orders WHERE status = 'new'`
 	var count int
-	err := r.db.QueryRow(query).Scan(&count) //query для SELECT с 1 строкой
+	err := r.db.QueryRowContext(ctx, query).Scan(&count) //query для SELECT с 1 строкой
 	return count, err
 }
 
-func (r *OrderRepo) PaginateUserOrders(UserID, limit, offset int) ([]models.Order, error) {
+func (r *OrderRepo) PaginateUserOrders(ctx context.Context, UserID, limit, offset int) ([]models.Order, error) {
 	query := `
         SELECT id, user_id, amount, status, created_at
         FROM orders
@@ -250,7 +427,7 @@ func (r *OrderRepo) PaginateUserOrders(UserID, limit, offset int) ([]models.Orde
         ORDER BY created_at ASC, id ASC
         LIMIT $2 OFFSET $3`
 
-	rows, err := r.db.Query(query, UserID, limit, offset) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, query, UserID, limit, offset) //query для SELECT
 	if err != nil {
 		return nil, err
 	}
@@ -271,26 +448,79 @@ func (r *OrderRepo) PaginateUserOrders(UserID, limit, offset int) ([]models.Orde
 	return orders, nil
 }
 
-func (r *OrderRepo) CountUserOrders(UserID int) (int, error) { //подсчёт заказов пользователя для пагинации
+func (r *OrderRepo) CountUserOrders(ctx context.Context, UserID int) (int, error) { //подсчёт заказов пользователя для пагинации
 	query := `SELECT COUNT(*) FROM orders WHERE user_id = $1`
 	var count int
-	err := r.db.QueryRow(query, UserID).Scan(&count) //query для SELECT с 1 строкой
+	err := r.db.QueryRowContext(ctx, query, UserID).Scan(&count) //query для SELECT с 1 строкой
 	return count, err
 }
 
-func (r *OrderRepo) DeleteOrder(orderID int) error {
-	query := `DELETE From orders WHERE id = $1`
-
-	result, err := r.db.Exec(query, orderID)
+// DeleteOrder удаляет корзину или отменённый заказ. Оформленный заказ сначала отменяется через UpdateStatus:
+// при отмене товары возвращаются на склад, а баллы - покупателю, и удаление ничего не должно пересчитывать
+func (r *OrderRepo) DeleteOrder(ctx context.Context, orderID int) error {
+	query := `DELETE From orders WHERE id = $1 AND status IN ($2, $3)`
+	result, err := r.db.ExecContext(ctx, query, orderID, models.OrderNew, models.OrderCancelled)
 	if err != nil {
 		log.Printf("Ошибка удаления заказа: %v", err)
 		return err
 	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		log.Printf("заказ с ID %d не найден", orderID)
-		return err
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("заказ #%d не найден или не отменён", orderID)
 	}
 	return nil
 }
+
+// UpdateStatus переводит заказ в новый статус, если такой переход допустим. При отмене в той же транзакции
+// товары возвращаются на склад, а баллы - покупателю; restocked - ID товаров, которые снова появились в наличии
+func (r *OrderRepo) UpdateStatus(ctx context.Context, orderID int, status string) (order *models.Order, restocked []int, err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	order = &models.Order{}
+	var pointsUsed float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id, amount, status, created_at, points_used
+		FROM orders
+		WHERE id = $1
+		FOR UPDATE`, orderID).Scan(&order.ID, &order.UserID, &order.Amount, &order.Status, &order.CreatedAt, &pointsUsed)
+	if err == sql.ErrNoRows {
+		return nil, nil, fmt.Errorf("заказ с ID %d не найден", orderID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	allowed := false
+	for _, next := range models.OrderTransitions[order.Status] {
+		if next == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, nil, fmt.Errorf("заказ #%d нельзя перевести из статуса %s в %s", orderID, order.Status, status)
+	}
+
+	if status == models.OrderCancelled {
+		if restocked, err = returnStock(ctx, tx, orderID); err != nil {
+			return nil, nil, err
+		}
+		err = creditPoints(ctx, tx, order.UserID, pointsUsed, models.LoyaltyRefund, orderID,
+			fmt.Sprintf("Возврат баллов отменённого заказа #%d", orderID), 0)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = $2 WHERE id = $1`, orderID, status); err != nil {
+		log.Printf("Ошибка смены статуса заказа %d: %v", orderID, err)
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	order.Status = status
+	return order, restocked, nil
+}
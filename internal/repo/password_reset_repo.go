@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"project/internal/utils"
+	"time"
+)
+
+type PasswordResetRepo struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepo(db *sql.DB) *PasswordResetRepo {
+	return &PasswordResetRepo{db: db}
+}
+
+const (
+	PasswordResetTTL         = 15 * time.Minute // время жизни кода восстановления
+	passwordResetCooldown    = time.Minute      // новый код не чаще раза в минуту
+	passwordResetMaxAttempts = 5                // после стольких неверных вводов код перестаёт действовать
+)
+
+// CreateReset сохраняет хэш нового кода, предыдущие коды пользователя перестают действовать
+func (r *PasswordResetRepo) CreateReset(ctx context.Context, reset *models.PasswordReset, code string) error {
+	codeHash, err := utils.HashPassword(code)
+	if err != nil {
+		return fmt.Errorf("fail hash code: %v", err)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var recent bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM password_resets
+			WHERE user_id = $1 AND created_at > NOW() - $2 * INTERVAL '1 second')`,
+		reset.UserID, passwordResetCooldown.Seconds()).Scan(&recent)
+	if err != nil {
+		return err
+	}
+	if recent {
+		return fmt.Errorf("код уже отправлен, новый можно запросить через минуту")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE password_resets SET expires_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL AND expires_at > NOW()`, reset.UserID)
+	if err != nil {
+		log.Printf("Ошибка отзыва кодов восстановления: %v", err)
+		return err
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO password_resets (user_id, code_hash, requested_in, expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+		RETURNING id, created_at, expires_at`,
+		reset.UserID, codeHash, reset.RequestedIn, PasswordResetTTL.Seconds(),
+	).Scan(&reset.ID, &reset.CreatedAt, &reset.ExpiresAt)
+	if err != nil {
+		log.Printf("Ошибка создания кода восстановления: %v", err)
+		return err
+	}
+	reset.CodeHash = codeHash
+	return tx.Commit()
+}
+
+// ActiveReset - действующий код пользователя, nil если его нет
+func (r *PasswordResetRepo) ActiveReset(ctx context.Context, userID int64) (*models.PasswordReset, error) {
+	query := `
+		SELECT id, user_id, code_hash, attempts, requested_in, created_at, expires_at, used_at
+		FROM password_resets
+		WHERE user_id = $1 AND used_at IS NULL AND expires_at > NOW() AND attempts < $2
+		ORDER BY created_at DESC
+		LIMIT 1`
+	var reset models.PasswordReset
+	err := r.db.QueryRowContext(ctx, query, userID, passwordResetMaxAttempts).Scan(
+		&reset.ID, &reset.UserID, &reset.CodeHash, &reset.Attempts,
+		&reset.RequestedIn, &reset.CreatedAt, &reset.ExpiresAt, &reset.UsedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+func (r *PasswordResetRepo) FailAttempt(ctx context.Context, resetID int64) error { //неверный ввод кода
+	_, err := r.db.ExecContext(ctx, `UPDATE password_resets SET attempts = attempts + 1 WHERE id = $1`, resetID)
+	return err
+}
+
+// CompleteReset помечает код использованным и меняет пароль одной транзакцией
+func (r *PasswordResetRepo) CompleteReset(ctx context.Context, reset *models.PasswordReset, password string) error {
+	hashedPassword, err := utils.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("fail hash password: %v", err)
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE password_resets SET used_at = NOW()
+		WHERE id = $1 AND used_at IS NULL AND expires_at > NOW()`, reset.ID)
+	if err != nil {
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("код уже использован или истёк")
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE users SET password = $2 WHERE id = $1`, reset.UserID, hashedPassword)
+	if err != nil {
+		log.Printf("Ошибка смены пароля: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
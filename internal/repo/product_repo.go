@@ -1,8 +1,11 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
+	"math"
 	"project/internal/models"
 	"strconv"
 )
@@ -16,17 +19,18 @@ func NewProductRepo(db *sql.DB) *ProductRepo {
 	return &ProductRepo{db: db}
 }
 
-func (r *ProductRepo) CreateProduct(product *models.Product) error {
+func (r *ProductRepo) CreateProduct(ctx context.Context, product *models.Product) error {
 	query := `
 		INSERT INTO products (name, description, price, quantity, category_id, 
-			weight, flavor, brand, servings, is_active)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			weight, flavor, brand, servings, is_active, image_url, brand_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11,
+			(SELECT id FROM brands WHERE LOWER(name) = LOWER($8)))
 		RETURNING id, created_at`
-	err := r.db.QueryRow(
+	err := r.db.QueryRowContext(ctx,
 		query, product.Name, product.Description,
 		product.Price, product.Quantity, product.Category_id,
 		product.Weight, product.Flavor, product.Brand,
-		product.Servings, product.IsActive).Scan(&product.ID, &product.CreatedAt)
+		product.Servings, product.IsActive, product.ImageURL).Scan(&product.ID, &product.CreatedAt)
 
 	if err != nil {
 		log.Printf("Ошибка создания товара: %v", err)
@@ -35,14 +39,15 @@ func (r *ProductRepo) CreateProduct(product *models.Product) error {
 	return nil
 }
 
-func (r *ProductRepo) AllProducts() ([]models.Product, error) {
+func (r *ProductRepo) AllProducts(ctx context.Context) ([]models.Product, error) {
 	query := `SELECT id, name, description, price, quantity, category_id, 
-        weight, flavor, brand, servings, is_active, created_at
+        weight, flavor, brand, servings, is_active, created_at, COALESCE(image_url, ''),
+        COALESCE(sale_price, 0)
         FROM products 
         WHERE is_active = true
         ORDER BY id`
 
-	rows, err := r.db.Query(query) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, query) //query для SELECT
 	if err != nil {
 		log.Printf("Ошибка скана: %v", err)
 		return nil, err
@@ -55,7 +60,7 @@ func (r *ProductRepo) AllProducts() ([]models.Product, error) {
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
 			&product.Category_id, &product.Weight, &product.Flavor, &product.Brand, &product.Servings,
-			&product.IsActive, &product.CreatedAt,
+			&product.IsActive, &product.CreatedAt, &product.ImageURL, &product.SalePrice,
 		)
 		if err != nil {
 			log.Printf("Ошибка скана: %v", err)
@@ -66,17 +71,18 @@ func (r *ProductRepo) AllProducts() ([]models.Product, error) {
 	return products, nil
 }
 
-func (r *ProductRepo) ProductsByCategory(category interface{}) ([]models.Product, error) {
+func (r *ProductRepo) ProductsByCategory(ctx context.Context, category interface{}) ([]models.Product, error) {
 	query := `
         SELECT products.id, products.name, products.description, products.price, products.quantity, products.category_id, 
-               products.weight, products.flavor, products.brand, products.servings, products.is_active, products.created_at
+               products.weight, products.flavor, products.brand, products.servings, products.is_active, products.created_at,
+               COALESCE(products.image_url, ''), COALESCE(products.sale_price, 0)
         FROM products 
         JOIN categories ON products.category_id = categories.id
         WHERE products.is_active = true 
           AND (products.category_id::text ILIKE $1 OR categories.name ILIKE '%' || $1 || '%')
         ORDER BY products.id`
 
-	rows, err := r.db.Query(query, category) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, query, category) //query для SELECT
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +94,7 @@ func (r *ProductRepo) ProductsByCategory(category interface{}) ([]models.Product
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
 			&product.Category_id, &product.Weight, &product.Flavor, &product.Brand, &product.Servings,
-			&product.IsActive, &product.CreatedAt,
+			&product.IsActive, &product.CreatedAt, &product.ImageURL, &product.SalePrice,
 		)
 		if err != nil {
 			return nil, err
@@ -98,18 +104,20 @@ func (r *ProductRepo) ProductsByCategory(category interface{}) ([]models.Product
 	return products, nil
 }
 
-func (r *ProductRepo) SearchProduct(query string) ([]models.Product, error) {
+func (r *ProductRepo) SearchProduct(ctx context.Context, query string) ([]models.Product, error) {
 	searchQuery := `
 	SELECT id, name, description, price, quantity, category_id, 
-		weight, flavor, brand, servings, is_active, created_at
+		weight, flavor, brand, servings, is_active, created_at, COALESCE(image_url, ''),
+		COALESCE(sale_price, 0)
 	FROM products 	
-	WHERE name ILIKE '%' || $1 || '%' 
+	WHERE (name ILIKE '%' || $1 || '%' 
 	OR description ILIKE '%' || $1 || '%' 
 	OR flavor ILIKE '%' || $1 || '%' 
 	OR weight ILIKE $1 
-	OR id::text ILIKE $1
+	OR id::text ILIKE $1)
+	AND archived_at IS NULL
 	ORDER BY id`
-	rows, err := r.db.Query(searchQuery, query) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, searchQuery, query) //query для SELECT
 	if err != nil {
 		log.Printf("Ошибка: %v", err)
 		return nil, err
@@ -122,7 +130,7 @@ func (r *ProductRepo) SearchProduct(query string) ([]models.Product, error) {
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
 			&product.Category_id, &product.Weight, &product.Flavor, &product.Brand, &product.Servings,
-			&product.IsActive, &product.CreatedAt,
+			&product.IsActive, &product.CreatedAt, &product.ImageURL, &product.SalePrice,
 		)
 		if err != nil {
 			return nil, err
@@ -132,47 +140,237 @@ func (r *ProductRepo) SearchProduct(query string) ([]models.Product, error) {
 	return products, nil
 }
 
-func (r *ProductRepo) UpdateProduct(product *models.Product) error {
+// ProductByID - товар по точному ID, в том числе архивный
+func (r *ProductRepo) ProductByID(ctx context.Context, productID int) (*models.Product, error) {
+	query := `
+	SELECT id, name, description, price, quantity, category_id,
+		weight, flavor, brand, servings, is_active, created_at, COALESCE(image_url, ''),
+		COALESCE(sale_price, 0), archived_at
+	FROM products
+	WHERE id = $1`
+	var product models.Product
+	err := r.db.QueryRowContext(ctx, query, productID).Scan(
+		&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
+		&product.Category_id, &product.Weight, &product.Flavor, &product.Brand, &product.Servings,
+		&product.IsActive, &product.CreatedAt, &product.ImageURL, &product.SalePrice, &product.ArchivedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("товар с ID %d не найден", productID)
+		}
+		return nil, err
+	}
+	return &product, nil
+}
+
+// UpdateProduct сохраняет изменения товара; restocked - товар появился в наличии (было 0, стало больше)
+func (r *ProductRepo) UpdateProduct(ctx context.Context, product *models.Product) (restocked bool, err error) { //изменение цены сохраняется в истории цен
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var oldPrice float64
+	var oldQuantity int
+	err = tx.QueryRowContext(ctx, `SELECT price, quantity FROM products WHERE id = $1 FOR UPDATE`, product.ID).Scan(&oldPrice, &oldQuantity)
+	if err != nil {
+		log.Printf("Ошибка обновления товара: %v", err)
+		return false, err
+	}
+
 	query := `
 		update products 
 		set name = $2, description = $3, price = $4, quantity = $5,
 		category_id = $6, weight = $7, flavor = $8, brand = $9, 
-		servings = $10, is_active = $11
+		servings = $10, is_active = $11, image_url = $12,
+		brand_id = (SELECT id FROM brands WHERE LOWER(name) = LOWER($9))
 		WHERE id = $1`
-	_, err := r.db.Exec( //Exec для INSERT/UPDATE/DELETE
+	_, err = tx.ExecContext(ctx, //Exec для INSERT/UPDATE/DELETE
 		query, product.ID, product.Name, product.Description,
 		product.Price, product.Quantity, product.Category_id,
 		product.Weight, product.Flavor, product.Brand,
-		product.Servings, product.IsActive,
+		product.Servings, product.IsActive, product.ImageURL,
 	)
 	if err != nil {
 		log.Printf("Ошибка обновления товара: %v", err)
-		return err
+		return false, err
 	}
-	return nil
+
+	if math.Round(oldPrice*100) != math.Round(product.Price*100) { //цены в копейках, чтобы не сравнивать float
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO price_history (product_id, old_price, new_price)
+			VALUES ($1, $2, $3)`, product.ID, oldPrice, product.Price)
+		if err != nil {
+			log.Printf("Ошибка записи истории цен: %v", err)
+			return false, err
+		}
+	}
+	if product.SalePrice, err = applyProductSale(ctx, tx, product.ID); err != nil { //не ждём фоновую задачу распродаж
+		return false, err
+	}
+	return oldQuantity <= 0 && product.Quantity > 0, tx.Commit()
+}
+
+// addStock возвращает товар на склад внутри транзакции; true, если до возврата его не было в наличии
+func addStock(ctx context.Context, tx *sql.Tx, productID, quantity int) (bool, error) {
+	var restocked bool
+	err := tx.QueryRowContext(ctx, `
+		UPDATE products SET quantity = quantity + $2
+		WHERE id = $1
+		RETURNING quantity - $2 <= 0 AND quantity > 0`, productID, quantity).Scan(&restocked)
+	if err == sql.ErrNoRows { //товар удалён, возвращать некуда
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Ошибка возврата товара %d на склад: %v", productID, err)
+	}
+	return restocked, err
 }
 
-func (r *ProductRepo) DeleteProduct(productID int) error {
+func (r *ProductRepo) PriceHistory(ctx context.Context, productID int) ([]models.PriceChange, error) {
 	query := `
-        WITH deleted_order_items AS (DELETE FROM order_items WHERE product_id = $1)
-        DELETE FROM products WHERE id = $1`
-	_, err := r.db.Exec(query, productID) //Exec для INSERT/UPDATE/DELETE
+		SELECT id, product_id, old_price, new_price, changed_at
+		FROM price_history
+		WHERE product_id = $1
+		ORDER BY changed_at DESC, id DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []models.PriceChange
+	for rows.Next() {
+		var change models.PriceChange
+		err := rows.Scan(
+			&change.ID, &change.ProductID, &change.OldPrice, &change.NewPrice, &change.ChangedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+// ArchiveProduct убирает товар из каталога и открытых корзин. Подтверждённые заказы не меняются
+func (r *ProductRepo) ArchiveProduct(ctx context.Context, productID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("Ошибка удаления товара: %v", err)
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE products SET was_active = is_active, archived_at = NOW(), is_active = false
+        WHERE id = $1 AND archived_at IS NULL`, productID)
+	if err != nil {
+		log.Printf("Ошибка архивации товара: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("товар с ID %d не найден", productID)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        DELETE FROM order_items
+        USING orders
+        WHERE order_items.order_id = orders.id AND orders.status = 'new' AND order_items.product_id = $1`, productID)
+	if err != nil {
+		log.Printf("Ошибка удаления товара из корзин: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestoreProduct возвращает товар в каталог с видимостью, которая была до архивации.
+// Товар архивной категории или бренда не восстанавливается: сначала нужно восстановить их
+func (r *ProductRepo) RestoreProduct(ctx context.Context, productID int) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var categoryID, brandID sql.NullInt64
+	var categoryArchived, brandArchived bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT products.category_id, categories.archived_at IS NOT NULL,
+			products.brand_id, brands.archived_at IS NOT NULL
+		FROM products
+		LEFT JOIN categories ON categories.id = products.category_id
+		LEFT JOIN brands ON brands.id = products.brand_id
+		WHERE products.id = $1 AND products.archived_at IS NOT NULL
+		FOR UPDATE OF products`, productID).Scan(&categoryID, &categoryArchived, &brandID, &brandArchived)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("товар с ID %d не найден в архиве", productID)
+	}
+	if err != nil {
+		log.Printf("Ошибка восстановления товара: %v", err)
+		return err
+	}
+	if categoryArchived {
+		return fmt.Errorf("категория товара в архиве, сначала восстановите её: /restore_category %d", categoryID.Int64)
+	}
+	if brandArchived {
+		return fmt.Errorf("бренд товара в архиве, сначала восстановите его: /restore_brand %d", brandID.Int64)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE products SET archived_at = NULL, is_active = COALESCE(was_active, true), was_active = NULL
+        WHERE id = $1`, productID)
+	if err != nil {
+		log.Printf("Ошибка восстановления товара: %v", err)
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *ProductRepo) PaginateProducts(limit, offset int) ([]models.Product, error) {
+func (r *ProductRepo) ArchivedProducts(ctx context.Context) ([]models.Product, error) {
 	query := `
-        SELECT id, name, description, price, quantity, category_id, weight, flavor, servings, is_active, created_at
+        SELECT id, name, description, price, quantity, category_id, 
+            weight, flavor, brand, servings, is_active, created_at, COALESCE(image_url, ''),
+            COALESCE(sale_price, 0), archived_at
+        FROM products
+        WHERE archived_at IS NOT NULL
+        ORDER BY archived_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		err := rows.Scan(
+			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
+			&product.Category_id, &product.Weight, &product.Flavor, &product.Brand, &product.Servings,
+			&product.IsActive, &product.CreatedAt, &product.ImageURL, &product.SalePrice, &product.ArchivedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+func (r *ProductRepo) PaginateProducts(ctx context.Context, limit, offset int) ([]models.Product, error) {
+	query := `
+        SELECT id, name, description, price, quantity, category_id, weight, flavor, servings, is_active, created_at,
+            COALESCE(sale_price, 0)
         FROM products
         WHERE is_active = true
         ORDER BY created_at ASC, id ASC
         LIMIT $1 OFFSET $2`
 
-	rows, err := r.db.Query(query, limit, offset) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, query, limit, offset) //query для SELECT
 	if err != nil {
 		return nil, err
 	}
@@ -184,7 +382,7 @@ func (r *ProductRepo) PaginateProducts(limit, offset int) ([]models.Product, err
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
 			&product.Category_id, &product.Weight, &product.Flavor, &product.Servings,
-			&product.IsActive, &product.CreatedAt,
+			&product.IsActive, &product.CreatedAt, &product.SalePrice,
 		)
 		if err != nil {
 			log.Printf("Ошибка скана: %v", err)
@@ -195,25 +393,26 @@ func (r *ProductRepo) PaginateProducts(limit, offset int) ([]models.Product, err
 	return products, nil
 }
 
-func (r *ProductRepo) CountProducts() (int, error) { //подсчёт продуктов для пагинации
+func (r *ProductRepo) CountProducts(ctx context.Context) (int, error) { //подсчёт продуктов для пагинации
 	query := `SELECT COUNT(*) FROM products WHERE is_active = true`
 	var count int
-	err := r.db.QueryRow(query).Scan(&count) //query для SELECT с 1 строкой
+	err := r.db.QueryRowContext(ctx, query).Scan(&count) //query для SELECT с 1 строкой
 	return count, err
 }
 
-func (r *ProductRepo) PaginateProductsByCategory(categoryID string, limit, offset int) ([]models.Product, error) {
+func (r *ProductRepo) PaginateProductsByCategory(ctx context.Context, categoryID string, limit, offset int) ([]models.Product, error) {
 	id, err := strconv.Atoi(categoryID)
 	if err != nil {
 		return nil, err
 	}
 	query := `
-        SELECT id, name, description, price, quantity, category_id, weight, flavor, servings, is_active, created_at
+        SELECT id, name, description, price, quantity, category_id, weight, flavor, servings, is_active, created_at,
+            COALESCE(sale_price, 0)
         FROM products
         WHERE is_active = true AND category_id = $1
         ORDER BY created_at ASC, id ASC
         LIMIT $2 OFFSET $3`
-	rows, err := r.db.Query(query, id, limit, offset) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, query, id, limit, offset) //query для SELECT
 	if err != nil {
 		return nil, err
 	}
@@ -224,7 +423,73 @@ func (r *ProductRepo) PaginateProductsByCategory(categoryID string, limit, offse
 		err := rows.Scan(
 			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
 			&product.Category_id, &product.Weight, &product.Flavor, &product.Servings,
-			&product.IsActive, &product.CreatedAt,
+			&product.IsActive, &product.CreatedAt, &product.SalePrice,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+func (r *ProductRepo) SearchProductByBrand(ctx context.Context, query string, brandID int) ([]models.Product, error) { //поиск среди товаров бренда
+	searchQuery := `
+	SELECT id, name, description, price, quantity, category_id, 
+		weight, flavor, brand, servings, is_active, created_at, COALESCE(image_url, ''),
+		COALESCE(sale_price, 0)
+	FROM products 	
+	WHERE (name ILIKE '%' || $1 || '%' 
+	OR description ILIKE '%' || $1 || '%' 
+	OR flavor ILIKE '%' || $1 || '%' 
+	OR weight ILIKE $1 
+	OR id::text ILIKE $1)
+	AND brand_id = $2 AND archived_at IS NULL
+	ORDER BY id`
+	rows, err := r.db.QueryContext(ctx, searchQuery, query, brandID)
+	if err != nil {
+		log.Printf("Ошибка: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		err := rows.Scan(
+			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
+			&product.Category_id, &product.Weight, &product.Flavor, &product.Brand, &product.Servings,
+			&product.IsActive, &product.CreatedAt, &product.ImageURL, &product.SalePrice,
+		)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
+
+func (r *ProductRepo) PaginateProductsByBrand(ctx context.Context, brandID, limit, offset int) ([]models.Product, error) {
+	query := `
+        SELECT id, name, description, price, quantity, category_id, weight, flavor, servings, is_active, created_at,
+            COALESCE(sale_price, 0)
+        FROM products
+        WHERE is_active = true AND brand_id = $1
+        ORDER BY created_at ASC, id ASC
+        LIMIT $2 OFFSET $3`
+	rows, err := r.db.QueryContext(ctx, query, brandID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		err := rows.Scan(
+			&product.ID, &product.Name, &product.Description, &product.Price, &product.Quantity,
+			&product.Category_id, &product.Weight, &product.Flavor, &product.Servings,
+			&product.IsActive, &product.CreatedAt, &product.SalePrice,
 		)
 		if err != nil {
 			log.Printf("Ошибка скана: %v", err)
@@ -235,13 +500,57 @@ func (r *ProductRepo) PaginateProductsByCategory(categoryID string, limit, offse
 	return products, nil
 }
 
-func (r *ProductRepo) CountProductsByCategory(categoryID string) (int, error) {
+func (r *ProductRepo) CountProductsByBrand(ctx context.Context, brandID int) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM products WHERE is_active = true AND brand_id = $1`
+	err := r.db.QueryRowContext(ctx, query, brandID).Scan(&count)
+	return count, err
+}
+
+func (r *ProductRepo) CountProductsByCategory(ctx context.Context, categoryID string) (int, error) {
 	id, err := strconv.Atoi(categoryID)
 	if err != nil {
 		return 0, err
 	}
 	var count int
 	query := `SELECT COUNT(*) FROM products WHERE is_active = true AND category_id = $1`
-	err = r.db.QueryRow(query, id).Scan(&count) //query для SELECT с одной строкой
+	err = r.db.QueryRowContext(ctx, query, id).Scan(&count) //query для SELECT с одной строкой
 	return count, err
 }
+
+func (r *ProductRepo) Nutrition(ctx context.Context, productID int) (*models.Nutrition, error) {
+	query := `
+		SELECT product_id, serving_size, protein, carbs, fat, calories
+		FROM product_nutrition
+		WHERE product_id = $1`
+	var nutrition models.Nutrition
+	err := r.db.QueryRowContext(ctx, query, productID).Scan(
+		&nutrition.ProductID, &nutrition.ServingSize, &nutrition.Protein,
+		&nutrition.Carbs, &nutrition.Fat, &nutrition.Calories,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		log.Printf("Ошибка загрузки пищевой ценности: %v", err)
+		return nil, err
+	}
+	return &nutrition, nil
+}
+
+func (r *ProductRepo) SetNutrition(ctx context.Context, nutrition *models.Nutrition) error {
+	query := `
+		INSERT INTO product_nutrition (product_id, serving_size, protein, carbs, fat, calories)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (product_id)
+		DO UPDATE SET serving_size = $2, protein = $3, carbs = $4, fat = $5, calories = $6`
+	_, err := r.db.ExecContext(ctx, query,
+		nutrition.ProductID, nutrition.ServingSize, nutrition.Protein,
+		nutrition.Carbs, nutrition.Fat, nutrition.Calories,
+	)
+	if err != nil {
+		log.Printf("Ошибка сохранения пищевой ценности: %v", err)
+		return err
+	}
+	return nil
+}
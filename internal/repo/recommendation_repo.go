@@ -0,0 +1,104 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"project/internal/models"
+)
+
+type RecommendationRepo struct {
+	db *sql.DB
+}
+
+func NewRecommendationRepo(db *sql.DB) *RecommendationRepo {
+	return &RecommendationRepo{db: db}
+}
+
+// Refresh пересчитывает связи "часто покупают вместе" по подтверждённым заказам.
+// Запросы читают готовую таблицу, поэтому пересчёт выполняется фоновой задачей
+func (r *RecommendationRepo) Refresh(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM product_affinity`)
+	if err != nil {
+		log.Printf("Ошибка очистки рекомендаций: %v", err)
+		return err
+	}
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO product_affinity (product_id, related_id, score)
+		SELECT a.product_id, b.product_id, COUNT(DISTINCT a.order_id)
+		FROM order_items a
+		JOIN order_items b ON b.order_id = a.order_id AND b.product_id <> a.product_id
+		JOIN orders ON orders.id = a.order_id
+		WHERE orders.status NOT IN ('new', 'cancelled')
+		GROUP BY a.product_id, b.product_id`)
+	if err != nil {
+		log.Printf("Ошибка пересчёта рекомендаций: %v", err)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	pairs, _ := result.RowsAffected()
+	log.Printf("Рекомендации пересчитаны, пар товаров: %d", pairs)
+	return nil
+}
+
+func (r *RecommendationRepo) Related(ctx context.Context, productID, limit int) ([]models.Product, error) { //часто покупают вместе с товаром
+	query := `
+		SELECT products.id, products.name, products.flavor, products.price,
+			COALESCE(products.sale_price, 0), products.quantity
+		FROM product_affinity
+		JOIN products ON products.id = product_affinity.related_id
+		WHERE product_affinity.product_id = $1
+		  AND products.is_active = true AND products.archived_at IS NULL
+		ORDER BY product_affinity.score DESC, products.id
+		LIMIT $2`
+	return r.queryProducts(ctx, query, productID, limit)
+}
+
+func (r *RecommendationRepo) ForCart(ctx context.Context, orderID, limit int) ([]models.Product, error) { //рекомендации к товарам корзины, кроме уже добавленных
+	query := `
+		SELECT products.id, products.name, products.flavor, products.price,
+			COALESCE(products.sale_price, 0), products.quantity
+		FROM product_affinity
+		JOIN order_items ON order_items.product_id = product_affinity.product_id
+		JOIN products ON products.id = product_affinity.related_id
+		WHERE order_items.order_id = $1
+		  AND products.is_active = true AND products.archived_at IS NULL
+		  AND product_affinity.related_id NOT IN (
+			SELECT product_id FROM order_items WHERE order_id = $1 AND product_id IS NOT NULL)
+		GROUP BY products.id, products.name, products.flavor, products.price, products.sale_price, products.quantity
+		ORDER BY SUM(product_affinity.score) DESC, products.id
+		LIMIT $2`
+	return r.queryProducts(ctx, query, orderID, limit)
+}
+
+func (r *RecommendationRepo) queryProducts(ctx context.Context, query string, args ...interface{}) ([]models.Product, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Ошибка загрузки рекомендаций: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.Product
+	for rows.Next() {
+		var product models.Product
+		err := rows.Scan(
+			&product.ID, &product.Name, &product.Flavor, &product.Price,
+			&product.SalePrice, &product.Quantity,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, nil
+}
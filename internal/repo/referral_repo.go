@@ -0,0 +1,230 @@
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"strings"
+)
+
+type ReferralRepo struct {
+	db *sql.DB
+}
+
+func NewReferralRepo(db *sql.DB) *ReferralRepo {
+	return &ReferralRepo{db: db}
+}
+
+// ReferralCode - код пользователя для ссылки, создаётся при первом запросе
+func (r *ReferralRepo) ReferralCode(ctx context.Context, userID int64) (string, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return "", err
+		}
+		candidate := strings.ToLower(base32.StdEncoding.EncodeToString(raw))
+
+		var code string
+		err := r.db.QueryRowContext(ctx, `
+			UPDATE users SET referral_code = COALESCE(referral_code, $2)
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING referral_code`, userID, candidate).Scan(&code)
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("пользователь с ID %d не найден", userID)
+		}
+		if err == nil {
+			return code, nil
+		}
+		log.Printf("Ошибка создания реферального кода: %v", err) //совпадение кодов, пробуем другой
+	}
+	return "", fmt.Errorf("не удалось создать реферальный код")
+}
+
+// ReferrerByCode - владелец кода, nil если код не найден или аккаунт удалён
+func (r *ReferralRepo) ReferrerByCode(ctx context.Context, code string) (*models.User, error) {
+	query := `
+		SELECT id, telegram_id, username, COALESCE(first_name, '')
+		FROM users
+		WHERE referral_code = $1 AND deleted_at IS NULL`
+	var user models.User
+	err := r.db.QueryRowContext(ctx, query, strings.ToLower(code)).Scan(
+		&user.ID, &user.TelegramID, &user.Username, &user.FirstName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Link записывает приглашение; один Telegram можно пригласить только один раз
+func (r *ReferralRepo) Link(ctx context.Context, referral *models.Referral) error {
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO referrals (referrer_id, referee_id, referee_telegram_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+		RETURNING id, status, created_at`,
+		referral.ReferrerID, referral.RefereeID, referral.RefereeTelegramID,
+	).Scan(&referral.ID, &referral.Status, &referral.CreatedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("этот аккаунт Telegram уже был приглашён")
+	}
+	if err != nil {
+		log.Printf("Ошибка записи приглашения: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Reward начисляет бонусные баллы за первый доставленный заказ приглашённого от минимальной суммы.
+// Нужен подтверждённый телефон: он не должен совпадать с телефоном пригласившего
+// и других приглашённых, иначе приглашение отклоняется. Возвращает nil, если начислять пока нечего
+func (r *ReferralRepo) Reward(ctx context.Context, refereeID int64) (*models.Referral, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var referral models.Referral
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, referrer_id, referee_id, referee_telegram_id, status, created_at
+		FROM referrals
+		WHERE referee_id = $1 AND status = $2
+		FOR UPDATE`, refereeID, models.ReferralPending).Scan(
+		&referral.ID, &referral.ReferrerID, &referral.RefereeID,
+		&referral.RefereeTelegramID, &referral.Status, &referral.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	minOrder, err := settingFloat(ctx, tx, "referral_min_order")
+	if err != nil {
+		return nil, err
+	}
+	err = tx.QueryRowContext(ctx, `
+		SELECT id FROM orders
+		WHERE user_id = $1 AND status = $2 AND amount >= $3
+		ORDER BY created_at
+		LIMIT 1`, refereeID, models.OrderDelivered, minOrder).Scan(&referral.OrderID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var phone string
+	var verified bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(phone, ''), COALESCE(phone_verified, false) FROM users WHERE id = $1`,
+		refereeID).Scan(&phone, &verified)
+	if err != nil {
+		return nil, err
+	}
+	if !verified || phone == "" {
+		return nil, nil
+	}
+
+	var duplicate bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM users WHERE id = $1 AND phone = $2)
+			OR EXISTS (
+				SELECT 1 FROM referrals
+				JOIN users ON users.id = referrals.referee_id
+				WHERE referrals.id <> $3 AND users.phone = $2)`,
+		referral.ReferrerID, phone, referral.ID).Scan(&duplicate)
+	if err != nil {
+		return nil, err
+	}
+	if duplicate {
+		_, err = tx.ExecContext(ctx, `UPDATE referrals SET status = $2, order_id = $3 WHERE id = $1`,
+			referral.ID, models.ReferralRejected, referral.OrderID)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Приглашение %d отклонено: телефон приглашённого %d уже встречался", referral.ID, refereeID)
+		referral.Status = models.ReferralRejected
+		return &referral, tx.Commit()
+	}
+
+	if referral.ReferrerBonus, err = settingFloat(ctx, tx, "referral_referrer_bonus"); err != nil {
+		return nil, err
+	}
+	if referral.RefereeBonus, err = settingFloat(ctx, tx, "referral_referee_bonus"); err != nil {
+		return nil, err
+	}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE referrals
+		SET status = $2, order_id = $3, referrer_bonus = $4, referee_bonus = $5, rewarded_at = NOW()
+		WHERE id = $1
+		RETURNING rewarded_at`,
+		referral.ID, models.ReferralRewarded, referral.OrderID,
+		referral.ReferrerBonus, referral.RefereeBonus).Scan(&referral.RewardedAt)
+	if err != nil {
+		log.Printf("Ошибка начисления реферального бонуса: %v", err)
+		return nil, err
+	}
+	err = creditPoints(ctx, tx, referral.ReferrerID, referral.ReferrerBonus, models.LoyaltyReferral, 0,
+		fmt.Sprintf("Приглашённый покупатель получил заказ #%d", referral.OrderID), 0)
+	if err != nil {
+		return nil, err
+	}
+	err = creditPoints(ctx, tx, referral.RefereeID, referral.RefereeBonus, models.LoyaltyReferral, referral.OrderID,
+		"Первый заказ по приглашению", 0)
+	if err != nil {
+		return nil, err
+	}
+	referral.Status = models.ReferralRewarded
+	return &referral, tx.Commit()
+}
+
+func (r *ReferralRepo) Stats(ctx context.Context, userID int64) (models.ReferrerStats, error) {
+	stats := models.ReferrerStats{UserID: userID}
+	err := r.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE status = $2),
+			COALESCE(SUM(referrer_bonus), 0)
+		FROM referrals
+		WHERE referrer_id = $1`, userID, models.ReferralRewarded).Scan(
+		&stats.Invited, &stats.Rewarded, &stats.Bonus)
+	return stats, err
+}
+
+// TopReferrers - пользователи с наибольшим числом приглашённых, сделавших доставленный заказ
+func (r *ReferralRepo) TopReferrers(ctx context.Context, limit int) ([]models.ReferrerStats, error) {
+	query := `
+		SELECT users.id, COALESCE(users.first_name, ''), COUNT(*),
+			COUNT(*) FILTER (WHERE referrals.status = $2),
+			COALESCE(SUM(referrals.referrer_bonus), 0)
+		FROM referrals
+		JOIN users ON users.id = referrals.referrer_id
+		GROUP BY users.id
+		ORDER BY 4 DESC, 3 DESC
+		LIMIT $1`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, models.ReferralRewarded)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var top []models.ReferrerStats
+	for rows.Next() {
+		var stats models.ReferrerStats
+		err := rows.Scan(&stats.UserID, &stats.FirstName, &stats.Invited, &stats.Rewarded, &stats.Bonus)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		top = append(top, stats)
+	}
+	return top, nil
+}
@@ -0,0 +1,182 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"strings"
+)
+
+type RoleRepo struct {
+	db *sql.DB
+}
+
+func NewRoleRepo(db *sql.DB) *RoleRepo {
+	return &RoleRepo{db: db}
+}
+
+// HasPermission - есть ли у пользователя разрешение хотя бы через одну из его ролей
+func (r *RoleRepo) HasPermission(ctx context.Context, userID int64, permission string) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_roles
+			JOIN role_permissions ON role_permissions.role = user_roles.role
+			WHERE user_roles.user_id = $1 AND role_permissions.permission = $2)`
+	var ok bool
+	err := r.db.QueryRowContext(ctx, query, userID, permission).Scan(&ok)
+	return ok, err
+}
+
+func (r *RoleRepo) UserPermissions(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT DISTINCT role_permissions.permission FROM user_roles
+		JOIN role_permissions ON role_permissions.role = user_roles.role
+		WHERE user_roles.user_id = $1
+		ORDER BY 1`
+	return r.queryStrings(ctx, query, userID)
+}
+
+func (r *RoleRepo) UserRoles(ctx context.Context, userID int64) ([]string, error) {
+	query := `
+		SELECT user_roles.role FROM user_roles
+		JOIN roles ON roles.name = user_roles.role
+		WHERE user_roles.user_id = $1
+		ORDER BY roles.priority DESC`
+	return r.queryStrings(ctx, query, userID)
+}
+
+func (r *RoleRepo) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+func (r *RoleRepo) AllRoles(ctx context.Context) ([]models.Role, error) {
+	query := `
+		SELECT roles.name, COALESCE(roles.description, ''), roles.priority,
+			COALESCE(STRING_AGG(role_permissions.permission, ',' ORDER BY role_permissions.permission), '')
+		FROM roles
+		LEFT JOIN role_permissions ON role_permissions.role = roles.name
+		GROUP BY roles.name
+		ORDER BY roles.priority DESC`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		var permissions string
+		err := rows.Scan(&role.Name, &role.Description, &role.Priority, &permissions)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		if permissions != "" {
+			role.Permissions = strings.Split(permissions, ",")
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+// GrantRole назначает роль и обновляет основную роль пользователя в users.role
+func (r *RoleRepo) GrantRole(ctx context.Context, userID int64, role string, grantedBy int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM roles WHERE name = $1)`, role).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("роль %s не найдена", role)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_roles (user_id, role, granted_by)
+		SELECT id, $2, $3 FROM users WHERE id = $1
+		ON CONFLICT (user_id, role) DO NOTHING`, userID, role, grantedBy)
+	if err != nil {
+		log.Printf("Ошибка назначения роли: %v", err)
+		return err
+	}
+	if err := syncPrimaryRole(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeRole снимает роль; последнего владельца магазина оставить без роли нельзя
+func (r *RoleRepo) RevokeRole(ctx context.Context, userID int64, role string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if role == models.RoleOwner { //блокируем владельцев, чтобы два снятия не прошли одновременно
+		var owners int
+		err = tx.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM (
+				SELECT user_id FROM user_roles WHERE role = $1 FOR UPDATE) AS owners`, models.RoleOwner).Scan(&owners)
+		if err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return fmt.Errorf("нельзя снять роль с последнего владельца")
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = $1 AND role = $2`, userID, role)
+	if err != nil {
+		log.Printf("Ошибка снятия роли: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("у пользователя %d нет роли %s", userID, role)
+	}
+	if err := syncPrimaryRole(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func syncPrimaryRole(ctx context.Context, tx *sql.Tx, userID int64) error { //users.role - роль с наибольшим приоритетом или покупатель
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users SET role = COALESCE((
+			SELECT roles.name FROM user_roles
+			JOIN roles ON roles.name = user_roles.role
+			WHERE user_roles.user_id = $1
+			ORDER BY roles.priority DESC
+			LIMIT 1), 'user')
+		WHERE id = $1`, userID)
+	if err != nil {
+		log.Printf("Ошибка обновления роли пользователя: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("пользователь с ID %d не найден", userID)
+	}
+	return nil
+}
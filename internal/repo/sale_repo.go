@@ -0,0 +1,152 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+)
+
+type SaleRepo struct {
+	db *sql.DB
+}
+
+func NewSaleRepo(db *sql.DB) *SaleRepo {
+	return &SaleRepo{db: db}
+}
+
+// bestSalePrices - лучшая цена по активным распродажам для каждого товара.
+// Распродажа не может поднять цену выше базовой
+const bestSalePrices = `
+	WITH best AS (
+		SELECT products.id, MIN(CASE
+			WHEN sales.sale_price IS NOT NULL THEN sales.sale_price
+			ELSE ROUND(products.price * (100 - sales.discount_percent) / 100, 2)
+		END) AS price
+		FROM products
+		JOIN sales ON sales.is_active AND (
+			(sales.target_type = 'product' AND sales.target = products.id::text) OR
+			(sales.target_type = 'category' AND sales.target = products.category_id::text) OR
+			(sales.target_type = 'brand' AND sales.target = products.brand_id::text))
+		GROUP BY products.id, products.price
+		HAVING MIN(CASE
+			WHEN sales.sale_price IS NOT NULL THEN sales.sale_price
+			ELSE ROUND(products.price * (100 - sales.discount_percent) / 100, 2)
+		END) < products.price
+	)`
+
+func (r *SaleRepo) CreateSale(ctx context.Context, sale *models.Sale) error {
+	var salePrice, discountPercent interface{} //NULL для неиспользуемого вида скидки
+	if sale.SalePrice > 0 {
+		salePrice = sale.SalePrice
+	}
+	if sale.DiscountPercent > 0 {
+		discountPercent = sale.DiscountPercent
+	}
+	query := `
+		INSERT INTO sales (target_type, target, sale_price, discount_percent, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at`
+	err := r.db.QueryRowContext(ctx, query,
+		sale.TargetType, sale.Target, salePrice, discountPercent,
+		sale.StartsAt, sale.EndsAt).Scan(&sale.ID, &sale.CreatedAt)
+	if err != nil {
+		log.Printf("Ошибка создания распродажи: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *SaleRepo) UpcomingSales(ctx context.Context) ([]models.Sale, error) { //активные и запланированные распродажи
+	query := `
+		SELECT id, target_type, target, COALESCE(sale_price, 0), COALESCE(discount_percent, 0),
+			starts_at, ends_at, is_active, created_at
+		FROM sales
+		WHERE ends_at > NOW()
+		ORDER BY starts_at, id`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sales []models.Sale
+	for rows.Next() {
+		var sale models.Sale
+		err := rows.Scan(
+			&sale.ID, &sale.TargetType, &sale.Target, &sale.SalePrice, &sale.DiscountPercent,
+			&sale.StartsAt, &sale.EndsAt, &sale.IsActive, &sale.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		sales = append(sales, sale)
+	}
+	return sales, nil
+}
+
+func (r *SaleRepo) DeleteSale(ctx context.Context, saleID int) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM sales WHERE id = $1`, saleID)
+	if err != nil {
+		log.Printf("Ошибка удаления распродажи: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("распродажа с ID %d не найдена", saleID)
+	}
+	return nil
+}
+
+// applyProductSale пересчитывает цену по распродаже одного товара после изменения его цены, категории или бренда
+func applyProductSale(ctx context.Context, tx *sql.Tx, productID int) (float64, error) {
+	var salePrice float64
+	err := tx.QueryRowContext(ctx, bestSalePrices+`
+		UPDATE products SET sale_price = (SELECT price FROM best WHERE best.id = products.id)
+		WHERE id = $1
+		RETURNING COALESCE(sale_price, 0)`, productID).Scan(&salePrice)
+	if err != nil {
+		log.Printf("Ошибка пересчёта цены распродажи товара %d: %v", productID, err)
+	}
+	return salePrice, err
+}
+
+// ApplySales включает и выключает распродажи по времени и пересчитывает цены товаров.
+// Вызывается фоновой задачей и после изменения распродаж
+func (r *SaleRepo) ApplySales(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE sales SET is_active = (NOW() >= starts_at AND NOW() < ends_at)
+		WHERE is_active <> (NOW() >= starts_at AND NOW() < ends_at)`)
+	if err != nil {
+		log.Printf("Ошибка переключения распродаж: %v", err)
+		return err
+	}
+	if switched, _ := result.RowsAffected(); switched > 0 {
+		log.Printf("Распродаж включено/выключено: %d", switched)
+	}
+
+	_, err = tx.ExecContext(ctx, bestSalePrices+`
+		UPDATE products SET sale_price = NULL
+		WHERE sale_price IS NOT NULL AND id NOT IN (SELECT id FROM best)`)
+	if err != nil {
+		log.Printf("Ошибка сброса цен распродаж: %v", err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, bestSalePrices+`
+		UPDATE products SET sale_price = best.price
+		FROM best
+		WHERE products.id = best.id AND products.sale_price IS DISTINCT FROM best.price`)
+	if err != nil {
+		log.Printf("Ошибка установки цен распродаж: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,159 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"time"
+)
+
+type SessionRepo struct {
+	db *sql.DB
+}
+
+func NewSessionRepo(db *sql.DB) *SessionRepo {
+	return &SessionRepo{db: db}
+}
+
+const sessionColumns = `SELECT id, user_id, chat_id, COALESCE(from_id, 0), created_at, last_seen_at, expires_at, revoked_at FROM sessions`
+
+func scanSessions(rows *sql.Rows) ([]models.Session, error) {
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(
+			&session.ID, &session.UserID, &session.ChatID, &session.FromID, &session.CreatedAt,
+			&session.LastSeenAt, &session.ExpiresAt, &session.RevokedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
+// CreateSession открывает сессию, предыдущая сессия этого отправителя в чате завершается
+func (r *SessionRepo) CreateSession(ctx context.Context, session *models.Session) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE chat_id = $1 AND from_id = $2 AND revoked_at IS NULL`, session.ChatID, session.FromID)
+	if err != nil {
+		log.Printf("Ошибка завершения сессии чата: %v", err)
+		return err
+	}
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO sessions (user_id, chat_id, from_id, expires_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, last_seen_at`,
+		session.UserID, session.ChatID, session.FromID, session.ExpiresAt).Scan(&session.ID, &session.CreatedAt, &session.LastSeenAt)
+	if err != nil {
+		log.Printf("Ошибка создания сессии: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *SessionRepo) Session(ctx context.Context, sessionID int64) (*models.Session, error) {
+	rows, err := r.db.QueryContext(ctx, sessionColumns+` WHERE id = $1`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions, err := scanSessions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("сессия %d не найдена", sessionID)
+	}
+	return &sessions[0], nil
+}
+
+func (r *SessionRepo) ChatSession(ctx context.Context, chatID, fromID int64) (*models.Session, error) { //активная сессия отправителя в чате, nil если её нет
+	rows, err := r.db.QueryContext(ctx, sessionColumns+`
+		WHERE chat_id = $1 AND from_id = $2 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY id DESC
+		LIMIT 1`, chatID, fromID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sessions, err := scanSessions(rows)
+	if err != nil || len(sessions) == 0 {
+		return nil, err
+	}
+	return &sessions[0], nil
+}
+
+func (r *SessionRepo) Touch(ctx context.Context, sessionID int64, expiresAt time.Time) error { //продление сессии при активности
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE sessions SET last_seen_at = NOW(), expires_at = $2
+		WHERE id = $1 AND revoked_at IS NULL`, sessionID, expiresAt)
+	return err
+}
+
+// SessionHistory - все сессии пользователя, включая завершённые, для выгрузки данных
+func (r *SessionRepo) SessionHistory(ctx context.Context, userID int64) ([]models.Session, error) {
+	rows, err := r.db.QueryContext(ctx, sessionColumns+`
+		WHERE user_id = $1
+		ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSessions(rows)
+}
+
+func (r *SessionRepo) UserSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	rows, err := r.db.QueryContext(ctx, sessionColumns+`
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_seen_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanSessions(rows)
+}
+
+func (r *SessionRepo) RevokeSession(ctx context.Context, userID, sessionID int64) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionID, userID)
+	if err != nil {
+		log.Printf("Ошибка завершения сессии: %v", err)
+		return err
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("сессия %d не найдена", sessionID)
+	}
+	return nil
+}
+
+func (r *SessionRepo) RevokeChatSessions(ctx context.Context, chatID, fromID int64) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE sessions SET revoked_at = NOW()
+		WHERE chat_id = $1 AND from_id = $2 AND revoked_at IS NULL`, chatID, fromID)
+	return err
+}
+
+func (r *SessionRepo) RevokeUserSessions(ctx context.Context, userID int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+func (r *SessionRepo) DeleteExpired(ctx context.Context) error { //очистка старых сессий фоновой задачей
+	_, err := r.db.ExecContext(ctx, `
+		DELETE FROM sessions
+		WHERE expires_at < NOW() - INTERVAL '30 days' OR revoked_at < NOW() - INTERVAL '30 days'`)
+	return err
+}
@@ -0,0 +1,89 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"project/internal/models"
+	"strconv"
+)
+
+type SettingRepo struct {
+	db *sql.DB
+}
+
+func NewSettingRepo(db *sql.DB) *SettingRepo {
+	return &SettingRepo{db: db}
+}
+
+func (r *SettingRepo) AllSettings(ctx context.Context) ([]models.Setting, error) {
+	query := `
+		SELECT key, value, COALESCE(description, ''), updated_at
+		FROM settings
+		ORDER BY key`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []models.Setting
+	for rows.Next() {
+		var setting models.Setting
+		err := rows.Scan(&setting.Key, &setting.Value, &setting.Description, &setting.UpdatedAt)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		settings = append(settings, setting)
+	}
+	return settings, nil
+}
+
+func (r *SettingRepo) Setting(ctx context.Context, key string) (string, error) {
+	var value string
+	err := r.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = $1`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("настройка %s не найдена", key)
+	}
+	return value, err
+}
+
+var percentSettings = map[string]bool{ //настройки в процентах от суммы заказа
+	"loyalty_accrual_percent":    true,
+	"loyalty_redeem_max_percent": true,
+}
+
+// SetSetting меняет значение существующей настройки; числовые настройки остаются числами, проценты - от 0 до 100
+func (r *SettingRepo) SetSetting(ctx context.Context, key, value string) error {
+	current, err := r.Setting(ctx, key)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseFloat(current, 64); err == nil {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || number < 0 {
+			return fmt.Errorf("значение %s должно быть неотрицательным числом", key)
+		}
+		if percentSettings[key] && number > 100 {
+			return fmt.Errorf("значение %s - процент, от 0 до 100", key)
+		}
+	}
+	_, err = r.db.ExecContext(ctx, `UPDATE settings SET value = $2, updated_at = NOW() WHERE key = $1`, key, value)
+	if err != nil {
+		log.Printf("Ошибка изменения настройки %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+func settingFloat(ctx context.Context, tx *sql.Tx, key string) (float64, error) { //числовая настройка внутри транзакции
+	var value float64
+	err := tx.QueryRowContext(ctx, `SELECT value::numeric FROM settings WHERE key = $1`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("настройка %s не найдена", key)
+	}
+	return value, err
+}
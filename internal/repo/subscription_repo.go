@@ -0,0 +1,118 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"project/internal/models"
+)
+
+type SubscriptionRepo struct {
+	db *sql.DB
+}
+
+func NewSubscriptionRepo(db *sql.DB) *SubscriptionRepo {
+	return &SubscriptionRepo{db: db}
+}
+
+func (r *SubscriptionRepo) Subscribe(ctx context.Context, userID int64, productID int, chatID int64) error {
+	query := `
+		INSERT INTO stock_subscriptions (user_id, product_id, chat_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, product_id)
+		DO UPDATE SET chat_id = $3`
+	_, err := r.db.ExecContext(ctx, query, userID, productID, chatID)
+	if err != nil {
+		log.Printf("Ошибка подписки на товар: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *SubscriptionRepo) Unsubscribe(ctx context.Context, userID int64, productID int) error {
+	query := `DELETE FROM stock_subscriptions WHERE user_id = $1 AND product_id = $2`
+	_, err := r.db.ExecContext(ctx, query, userID, productID)
+	return err
+}
+
+// PopSubscribers удаляет подписки на товар и возвращает их.
+// Удаление и выборка выполняются одним запросом, поэтому каждая подписка срабатывает один раз.
+// Подписки пользователей, отключивших уведомления о поступлении, удаляются без возврата
+func (r *SubscriptionRepo) PopSubscribers(ctx context.Context, productID int) ([]models.StockSubscription, error) {
+	query := `
+		WITH popped AS (
+			DELETE FROM stock_subscriptions
+			WHERE product_id = $1
+			RETURNING id, user_id, product_id, chat_id, created_at)
+		SELECT popped.id, popped.user_id, popped.product_id, popped.chat_id, popped.created_at
+		FROM popped
+		JOIN users ON users.id = popped.user_id
+		WHERE COALESCE(users.notify_restock, true)`
+
+	rows, err := r.db.QueryContext(ctx, query, productID)
+	if err != nil {
+		log.Printf("Ошибка выборки подписок: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []models.StockSubscription
+	for rows.Next() {
+		var subscription models.StockSubscription
+		err := rows.Scan(
+			&subscription.ID, &subscription.UserID, &subscription.ProductID,
+			&subscription.ChatID, &subscription.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// RestoreSubscription возвращает подписку, снятую PopSubscribers, если уведомление не доставлено.
+// Дата подписки сохраняется; если пользователь успел подписаться заново, остаётся новая подписка
+func (r *SubscriptionRepo) RestoreSubscription(ctx context.Context, subscription models.StockSubscription) error {
+	query := `
+		INSERT INTO stock_subscriptions (user_id, product_id, chat_id, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, product_id) DO NOTHING`
+	_, err := r.db.ExecContext(ctx, query,
+		subscription.UserID, subscription.ProductID, subscription.ChatID, subscription.CreatedAt)
+	if err != nil {
+		log.Printf("Ошибка восстановления подписки на товар %d: %v", subscription.ProductID, err)
+		return err
+	}
+	return nil
+}
+
+func (r *SubscriptionRepo) UserSubscriptions(ctx context.Context, userID int64) ([]models.StockSubscription, error) {
+	query := `
+		SELECT id, user_id, product_id, chat_id, created_at
+		FROM stock_subscriptions
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscriptions []models.StockSubscription
+	for rows.Next() {
+		var subscription models.StockSubscription
+		err := rows.Scan(
+			&subscription.ID, &subscription.UserID, &subscription.ProductID,
+			&subscription.ChatID, &subscription.CreatedAt,
+		)
+		if err != nil {
+			log.Printf("Ошибка скана: %v", err)
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -17,7 +18,7 @@ func NewUserRepo(db *sql.DB) *UserRepo {
 	return &UserRepo{db: db}
 }
 
-func (r *UserRepo) CreateUser(user *models.User, password ...string) error {
+func (r *UserRepo) CreateUser(ctx context.Context, user *models.User, password ...string) error {
 	var hashedPassoword string
 	var err error
 	if len(password) > 0 && password[0] != "" {
@@ -30,7 +31,7 @@ func (r *UserRepo) CreateUser(user *models.User, password ...string) error {
 		INSERT INTO users (telegram_id, username, first_name, phone, email, role, password)
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id, created_at`
-	err = r.db.QueryRow( //query для SELECT с 1 строкой
+	err = r.db.QueryRowContext(ctx, //query для SELECT с 1 строкой
 		query, user.TelegramID, user.Username,
 		user.FirstName, user.Phone, user.Email,
 		user.Role, hashedPassoword).Scan(&user.ID, &user.CreatedAt)
@@ -42,14 +43,15 @@ func (r *UserRepo) CreateUser(user *models.User, password ...string) error {
 	return nil
 }
 
-func (r *UserRepo) SearchUserTGID(TelegramID int64) (*models.User, error) {
+func (r *UserRepo) SearchUserTGID(ctx context.Context, TelegramID int64) (*models.User, error) {
 	searchQuery := `
-        SELECT id, telegram_id, username, first_name, phone, email, role, password, created_at
+        SELECT id, telegram_id, username, first_name, phone, email, role, COALESCE(password, ''), created_at,
+            COALESCE(totp_secret, ''), COALESCE(totp_enabled, false)
         FROM users 
         WHERE telegram_id = $1
         LIMIT 1`
 
-	rows, err := r.db.Query(searchQuery, TelegramID)
+	rows, err := r.db.QueryContext(ctx, searchQuery, TelegramID)
 	if err != nil {
 		return nil, fmt.Errorf("err of search: %v", err)
 	}
@@ -62,6 +64,7 @@ func (r *UserRepo) SearchUserTGID(TelegramID int64) (*models.User, error) {
 	err = rows.Scan(
 		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
 		&user.Phone, &user.Email, &user.Role, &user.Password, &user.CreatedAt,
+		&user.TOTPSecret, &user.TOTPEnabled,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("scan error: %w", err)
@@ -69,13 +72,39 @@ func (r *UserRepo) SearchUserTGID(TelegramID int64) (*models.User, error) {
 
 	return &user, nil
 }
-func (r *UserRepo) AllUsers() ([]models.User, error) {
+func (r *UserRepo) UserByID(ctx context.Context, userID int64) (*models.User, error) { //пользователь с паролем, вторым фактором и профилем
+	query := `
+        SELECT id, telegram_id, username, COALESCE(first_name, ''), COALESCE(phone, ''), COALESCE(email, ''),
+            role, COALESCE(password, ''), created_at,
+            COALESCE(totp_secret, ''), COALESCE(totp_enabled, false),
+            COALESCE(delivery_address, ''), COALESCE(phone_verified, false),
+            COALESCE(notify_restock, true), COALESCE(notify_orders, true)
+        FROM users
+        WHERE id = $1`
+	var user models.User
+	err := r.db.QueryRowContext(ctx, query, userID).Scan(
+		&user.ID, &user.TelegramID, &user.Username, &user.FirstName,
+		&user.Phone, &user.Email, &user.Role, &user.Password, &user.CreatedAt,
+		&user.TOTPSecret, &user.TOTPEnabled,
+		&user.Address, &user.PhoneVerified, &user.NotifyRestock, &user.NotifyOrders,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("scan error: %w", err)
+	}
+	return &user, nil
+}
+
+func (r *UserRepo) AllUsers(ctx context.Context) ([]models.User, error) {
 	query := `
 		SELECT id, telegram_id, username, first_name, phone, email, role, created_at
 		FROM users 
+		WHERE deleted_at IS NULL
 		ORDER BY created_at DESC`
 
-	rows, err := r.db.Query(query) //SELECT
+	rows, err := r.db.QueryContext(ctx, query) //SELECT
 	if err != nil {
 		log.Printf("Ошибка скана: %v", err)
 		return nil, err
@@ -98,7 +127,7 @@ func (r *UserRepo) AllUsers() ([]models.User, error) {
 	return users, nil
 }
 
-func (r *UserRepo) SearchUser(query string) ([]models.User, error) {
+func (r *UserRepo) SearchUser(ctx context.Context, query string) ([]models.User, error) {
 	searchQuery := `
         SELECT id, telegram_id, username, first_name, phone, email, role, created_at
         FROM users 
@@ -108,7 +137,7 @@ func (r *UserRepo) SearchUser(query string) ([]models.User, error) {
            OR first_name ILIKE '%' || $1 || '%'
         ORDER BY created_at DESC`
 
-	rows, err := r.db.Query(searchQuery, query)
+	rows, err := r.db.QueryContext(ctx, searchQuery, query)
 	if err != nil {
 		log.Panic("Ошибка: ", err)
 		return nil, err
@@ -130,7 +159,7 @@ func (r *UserRepo) SearchUser(query string) ([]models.User, error) {
 	return users, nil
 }
 
-func (r *UserRepo) UpdateUser(user *models.User, updatePassword ...bool) error {
+func (r *UserRepo) UpdateUser(ctx context.Context, user *models.User, updatePassword ...bool) error {
 	var args []interface{}
 	var query string
 
@@ -139,7 +168,8 @@ func (r *UserRepo) UpdateUser(user *models.User, updatePassword ...bool) error {
 		query = `
 			UPDATE users
 			SET telegram_id = $2, username = $3, first_name = $4, 
-			    phone = $5, email = $6, role = $7, password = $8
+			    phone = $5, email = $6, role = $7, password = $8,
+			    phone_verified = (phone_verified AND phone = $5)
 			WHERE id = $1`
 		args = []interface{}{
 			user.ID, user.TelegramID, user.Username, user.FirstName,
@@ -149,14 +179,15 @@ func (r *UserRepo) UpdateUser(user *models.User, updatePassword ...bool) error {
 		query = `
 			UPDATE users
 			SET telegram_id = $2, username = $3, first_name = $4, 
-			    phone = $5, email = $6, role = $7
+			    phone = $5, email = $6, role = $7,
+			    phone_verified = (phone_verified AND phone = $5)
 			WHERE id = $1`
 		args = []interface{}{
 			user.ID, user.TelegramID, user.Username, user.FirstName,
 			user.Phone, user.Email, user.Role,
 		}
 	}
-	_, err := r.db.Exec(query, args...)
+	_, err := r.db.ExecContext(ctx, query, args...)
 	if err != nil {
 		log.Printf("Ошибка обновления пользователя: %v", err)
 		return err
@@ -164,38 +195,133 @@ func (r *UserRepo) UpdateUser(user *models.User, updatePassword ...bool) error {
 	return nil
 }
 
-func (r *UserRepo) UpdatePassword(userID int, NewPassword string) error {
+// UpdateProfile сохраняет поля, которые пользователь меняет сам в /profile
+func (r *UserRepo) UpdateProfile(ctx context.Context, user *models.User) error {
+	query := `
+		UPDATE users
+		SET first_name = $2, email = $3, delivery_address = $4,
+		    notify_restock = $5, notify_orders = $6
+		WHERE id = $1`
+	_, err := r.db.ExecContext(ctx,
+		query, user.ID, user.FirstName, user.Email, user.Address,
+		user.NotifyRestock, user.NotifyOrders,
+	)
+	if err != nil {
+		log.Printf("Ошибка обновления профиля: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) SetPhone(ctx context.Context, userID int64, phone string, verified bool) error { //телефон из контакта Telegram
+	query := "UPDATE users SET phone = $2, phone_verified = $3 WHERE id = $1"
+	_, err := r.db.ExecContext(ctx, query, userID, phone, verified)
+	if err != nil {
+		log.Printf("Ошибка сохранения телефона: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (r *UserRepo) UpdatePassword(ctx context.Context, userID int, NewPassword string) error {
 	hashedPassowrd, err := utils.HashPassword(NewPassword)
 	if err != nil {
 		return fmt.Errorf("error with hash password: %v", err)
 	}
 	query := "UPDATE users SET password = $1 WHERE id = $2"
-	_, err = r.db.Exec(query, hashedPassowrd, userID)
+	_, err = r.db.ExecContext(ctx, query, hashedPassowrd, userID)
 	if err != nil {
 		return fmt.Errorf("error update password: %v", err)
 	}
 	return nil
 }
 
-func (r *UserRepo) DeleteUser(userID int) error {
-	query := `WITH deleted_orders AS (DELETE FROM orders WHERE user_id = $1)
-				DELETE FROM users WHERE id = $1`
-	_, err := r.db.Exec(query, userID) // ISERT/UPDATE/DELETE
+func (r *UserRepo) SetTOTP(ctx context.Context, userID int64, secret string, enabled bool) error { //настройка второго фактора
+	query := "UPDATE users SET totp_secret = $2, totp_enabled = $3 WHERE id = $1"
+	_, err := r.db.ExecContext(ctx, query, userID, secret, enabled)
 	if err != nil {
-		log.Printf("Ошибка удаления пользователя: %v", err)
+		log.Printf("Ошибка настройки второго фактора: %v", err)
 		return err
 	}
 	return nil
 }
 
-func (r *UserRepo) PaginateUser(limit, offset int) ([]models.User, error) {
+// AnonymizeUser удаляет персональные данные пользователя, оформленные заказы остаются для учёта.
+// Telegram ID освобождается: при следующем сообщении из этого Telegram будет создан новый покупатель
+func (r *UserRepo) AnonymizeUser(ctx context.Context, userID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var TelegramID int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT telegram_id FROM users WHERE id = $1 AND deleted_at IS NULL
+		FOR UPDATE`, userID).Scan(&TelegramID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("пользователь с ID %d не найден", userID)
+		}
+		return err
+	}
+
+	var lastOwner bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM user_roles WHERE user_id = $1 AND role = $2)
+		   AND (SELECT COUNT(*) FROM user_roles WHERE role = $2) <= 1`, userID, models.RoleOwner).Scan(&lastOwner)
+	if err != nil {
+		return err
+	}
+	if lastOwner {
+		return fmt.Errorf("нельзя удалить последнего владельца магазина")
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET telegram_id = -id, username = 'deleted_' || id, first_name = 'Удалённый пользователь',
+		    phone = '', email = '', password = NULL, role = 'user',
+		    totp_secret = '', totp_enabled = false, delivery_address = '', phone_verified = false,
+		    notify_restock = false, notify_orders = false, referral_code = NULL, deleted_at = NOW()
+		WHERE id = $1`, userID)
+	if err != nil {
+		log.Printf("Ошибка обезличивания пользователя: %v", err)
+		return err
+	}
+
+	cleanup := []string{ //корзина не является оформленным заказом и удаляется
+		`DELETE FROM orders WHERE user_id = $1 AND status = 'new'`,
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM stock_subscriptions WHERE user_id = $1`,
+		`DELETE FROM user_roles WHERE user_id = $1`,
+		`DELETE FROM password_resets WHERE user_id = $1`,
+		//в журнале действий остаётся, кто и что сделал с аккаунтом, но не значения его полей
+		`UPDATE audit_log SET changes = '{}' WHERE entity = 'user' AND entity_id = $1::text`,
+	}
+	for _, query := range cleanup {
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			log.Printf("Ошибка удаления данных пользователя: %v", err)
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM login_attempts WHERE user_id = $1 OR telegram_id = $2 OR from_id = $2`, userID, TelegramID)
+	if err != nil {
+		log.Printf("Ошибка удаления журнала входов пользователя: %v", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *UserRepo) PaginateUser(ctx context.Context, limit, offset int) ([]models.User, error) {
 	query := `
         SELECT id, telegram_id, username, first_name, phone, email, role, created_at
         FROM users
+        WHERE deleted_at IS NULL
         ORDER BY created_at ASC, id ASC
         LIMIT $1 OFFSET $2`
 
-	rows, err := r.db.Query(query, limit, offset) //query для SELECT
+	rows, err := r.db.QueryContext(ctx, query, limit, offset) //query для SELECT
 	if err != nil {
 		return nil, err
 	}
@@ -217,9 +343,9 @@ func (r *UserRepo) PaginateUser(limit, offset int) ([]models.User, error) {
 	return users, nil
 }
 
-func (r *UserRepo) CountUsers() (int, error) { //подсчёт юзеров для пагинации
-	query := `SELECT COUNT(*) FROM users`
+func (r *UserRepo) CountUsers(ctx context.Context) (int, error) { //подсчёт юзеров для пагинации
+	query := `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL`
 	var count int
-	err := r.db.QueryRow(query).Scan(&count) //query для SELECT c 1 строкой
+	err := r.db.QueryRowContext(ctx, query).Scan(&count) //query для SELECT c 1 строкой
 	return count, err
 }
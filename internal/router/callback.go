@@ -0,0 +1,74 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxCallbackData - ограничение Telegram на данные кнопки в байтах
+const MaxCallbackData = 64
+
+const callbackSeparator = ":"
+
+// CallbackData - данные кнопки вида route:arg:arg
+type CallbackData struct {
+	Route string
+	Args  []string
+}
+
+// EncodeCallback собирает данные кнопки; аргументы - строки и целые числа
+func EncodeCallback(route string, args ...interface{}) (string, error) {
+	parts := []string{route}
+	for _, arg := range args {
+		var part string
+		switch v := arg.(type) {
+		case string:
+			part = v
+		case int:
+			part = strconv.Itoa(v)
+		case int64:
+			part = strconv.FormatInt(v, 10)
+		default:
+			return "", fmt.Errorf("неподдерживаемый аргумент кнопки %T", arg)
+		}
+		if strings.Contains(part, callbackSeparator) {
+			return "", fmt.Errorf("аргумент кнопки содержит %q: %s", callbackSeparator, part)
+		}
+		parts = append(parts, part)
+	}
+	data := strings.Join(parts, callbackSeparator)
+	if len(data) > MaxCallbackData {
+		return "", fmt.Errorf("данные кнопки длиннее %d байт: %s", MaxCallbackData, data)
+	}
+	return data, nil
+}
+
+// Callback - EncodeCallback для кнопок, собранных в коде: ошибка означает ошибку программиста
+func Callback(route string, args ...interface{}) string {
+	data, err := EncodeCallback(route, args...)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+func DecodeCallback(data string) CallbackData {
+	parts := strings.Split(data, callbackSeparator)
+	return CallbackData{Route: parts[0], Args: parts[1:]}
+}
+
+func (d CallbackData) Arg(i int) string { //пустая строка, если аргумента нет
+	if i < len(d.Args) {
+		return d.Args[i]
+	}
+	return ""
+}
+
+func (d CallbackData) Int(i int) (int, error) {
+	return strconv.Atoi(d.Arg(i))
+}
+
+func (d CallbackData) Int64(i int) (int64, error) {
+	return strconv.ParseInt(d.Arg(i), 10, 64)
+}
@@ -0,0 +1,83 @@
+package router
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeCallback(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		args    []interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "без аргументов", route: "cart", want: "cart"},
+		{name: "числа и строки", route: "page", args: []interface{}{"products", 3, int64(-100500)}, want: "page:products:3:-100500"},
+		{name: "ровно 64 байта", route: "r", args: []interface{}{strings.Repeat("x", MaxCallbackData-2)}, want: "r:" + strings.Repeat("x", MaxCallbackData-2)},
+		{name: "длиннее 64 байт", route: "r", args: []interface{}{strings.Repeat("x", MaxCallbackData-1)}, wantErr: true},
+		{name: "кириллица считается в байтах", route: "s", args: []interface{}{strings.Repeat("я", 32)}, wantErr: true},
+		{name: "разделитель в аргументе", route: "search", args: []interface{}{"a:b"}, wantErr: true},
+		{name: "неподдерживаемый тип", route: "price", args: []interface{}{1.5}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EncodeCallback(tt.route, tt.args...)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("EncodeCallback(%q, %v) = %q, ожидалась ошибка", tt.route, tt.args, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("EncodeCallback(%q, %v) = %q, %v; ожидалось %q", tt.route, tt.args, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallbackPanicsOnInvalidData(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Callback не паникует на данных длиннее 64 байт")
+		}
+	}()
+	Callback("r", strings.Repeat("x", MaxCallbackData))
+}
+
+func TestDecodeCallback(t *testing.T) {
+	tests := []struct {
+		data  string
+		route string
+		args  []string
+	}{
+		{data: "cart", route: "cart", args: []string{}},
+		{data: "product:42", route: "product", args: []string{"42"}},
+		{data: "page:orders:2", route: "page", args: []string{"orders", "2"}},
+		{data: "buy::5", route: "buy", args: []string{"", "5"}},
+	}
+	for _, tt := range tests {
+		got := DecodeCallback(tt.data)
+		if got.Route != tt.route || strings.Join(got.Args, "|") != strings.Join(tt.args, "|") || len(got.Args) != len(tt.args) {
+			t.Fatalf("DecodeCallback(%q) = %+v, ожидалось %s %v", tt.data, got, tt.route, tt.args)
+		}
+	}
+}
+
+func TestCallbackDataArgs(t *testing.T) {
+	data := DecodeCallback(Callback("user", int64(9000000000), "abc"))
+
+	if id, err := data.Int64(0); err != nil || id != 9000000000 {
+		t.Fatalf("Int64(0) = %d, %v", id, err)
+	}
+	if _, err := data.Int(1); err == nil {
+		t.Fatal("Int(1) для нечислового аргумента без ошибки")
+	}
+	if arg := data.Arg(5); arg != "" {
+		t.Fatalf("Arg(5) = %q, ожидалась пустая строка", arg)
+	}
+	if _, err := data.Int(5); err == nil {
+		t.Fatal("Int(5) для отсутствующего аргумента без ошибки")
+	}
+}
@@ -0,0 +1,140 @@
+package router
+
+import (
+	"log"
+	"project/internal/models"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Reply отвечает в чат и, для кнопки, снимает с неё индикатор загрузки
+func Reply(ctx *Context, text string) {
+	if text != "" {
+		ctx.Bot.Send(tgbotapi.NewMessage(ctx.ChatID, text))
+	}
+	if ctx.Callback != nil {
+		ctx.Bot.Request(tgbotapi.NewCallback(ctx.Callback.ID, ""))
+	}
+}
+
+// Recover не даёт ошибке в одном обработчике остановить воркер обновлений
+func Recover() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			defer func() {
+				if err := recover(); err != nil {
+					log.Printf("Паника при обработке %s: %v\n%s", ctx.Action, err, debug.Stack())
+					Reply(ctx, "Внутренняя ошибка, попробуйте позже")
+				}
+			}()
+			next(ctx)
+		}
+	}
+}
+
+// Logger пишет в лог пользователя и действие после обработки
+func Logger() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			next(ctx)
+			if from := ctx.From(); from != nil {
+				log.Printf("user_id: %d, username: %s, action: %s", from.ID, from.FirstName, ctx.Action)
+			}
+		}
+	}
+}
+
+// RateLimit ограничивает число команд и нажатий чата: не больше limit за window
+func RateLimit(limit int, window time.Duration) Middleware {
+	var mu sync.Mutex
+	type counter struct {
+		start time.Time
+		count int
+	}
+	counters := make(map[int64]*counter)
+	lastCleanup := time.Now()
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			now := time.Now()
+			mu.Lock()
+			if now.Sub(lastCleanup) > window { //счётчики закончившихся окон больше не нужны
+				for chatID, c := range counters {
+					if now.Sub(c.start) > window {
+						delete(counters, chatID)
+					}
+				}
+				lastCleanup = now
+			}
+			c, ok := counters[ctx.ChatID]
+			if !ok || now.Sub(c.start) > window {
+				c = &counter{start: now}
+				counters[ctx.ChatID] = c
+			}
+			c.count++
+			limited := c.count > limit
+			notify := c.count == limit+1 //предупреждаем один раз за окно
+			mu.Unlock()
+
+			if limited {
+				ctx.Action += " rate_limited"
+				if notify {
+					Reply(ctx, "Слишком много запросов, подождите немного")
+				} else {
+					Reply(ctx, "")
+				}
+				return
+			}
+			next(ctx)
+		}
+	}
+}
+
+// Authenticator проверяет пользователя и права; реализуется пакетом обработчиков
+type Authenticator interface {
+	Authenticate(ctx *Context) (*models.User, error)
+	Authorize(ctx *Context, user *models.User, permission string) error
+	Deny(ctx *Context, err error) // ответ пользователю при отказе; нехватка прав приходит как *PermissionError
+}
+
+// PermissionError - пользователь вошёл, но проверка разрешения не пройдена
+type PermissionError struct {
+	Permission string
+	Err        error
+}
+
+func (e *PermissionError) Error() string {
+	return e.Permission + ": " + e.Err.Error()
+}
+
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
+
+// Auth пропускает к маршрутам с AuthRequired или Permission только вошедших пользователей с нужным разрешением
+func Auth(auth Authenticator) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(ctx *Context) {
+			route := ctx.Route
+			if route == nil || (!route.AuthRequired && route.Permission == "") {
+				next(ctx)
+				return
+			}
+			user, err := auth.Authenticate(ctx)
+			if err == nil {
+				if authErr := auth.Authorize(ctx, user, route.Permission); authErr != nil {
+					err = &PermissionError{Permission: route.Permission, Err: authErr}
+				}
+			}
+			if err != nil {
+				ctx.Action += " denied"
+				auth.Deny(ctx, err)
+				return
+			}
+			ctx.User = user
+			next(ctx)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"context"
+	"project/internal/models"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Context - обновление, которое обрабатывает маршрут. Встроенный context.Context ограничивает
+// время обработки, поэтому *Context передаётся в репозитории как есть
+type Context struct {
+	context.Context
+	Bot       *tgbotapi.BotAPI
+	Update    tgbotapi.Update
+	Route     *Route
+	ChatID    int64
+	MessageID int                     // сообщение с нажатой кнопкой
+	Callback  *tgbotapi.CallbackQuery // nil для команд
+	Data      CallbackData            // разобранные данные кнопки
+	User      *models.User            // заполняется Auth для маршрутов с AuthRequired
+	Action    string                  // действие для лога, по умолчанию имя маршрута
+}
+
+func (c *Context) From() *tgbotapi.User {
+	return c.Update.SentFrom()
+}
+
+type HandlerFunc func(ctx *Context)
+
+// Middleware оборачивает обработчик: авторизация, лог, ограничение частоты и т.д.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+type Route struct {
+	Name         string // команда без / или имя маршрута кнопки
+	Args         string // формат аргументов для /help
+	Description  string // описание для /help; пусто - команда не показывается
+	AuthRequired bool
+	Permission   string // разрешение, которое нужно для маршрута; пусто - доступно всем
+	Handler      HandlerFunc
+}
+
+type Router struct {
+	commands   map[string]*Route
+	callbacks  map[string]*Route
+	order      []*Route // команды в порядке регистрации для /help
+	middleware []Middleware
+	NotFound   HandlerFunc // кнопка без маршрута, например из сообщения до обновления бота
+}
+
+func New() *Router {
+	return &Router{commands: make(map[string]*Route), callbacks: make(map[string]*Route)}
+}
+
+// Use добавляет middleware; первое добавленное выполняется первым
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+func (r *Router) Command(route Route) {
+	if _, ok := r.commands[route.Name]; ok {
+		panic("команда зарегистрирована повторно: " + route.Name)
+	}
+	r.commands[route.Name] = &route
+	r.order = append(r.order, &route)
+}
+
+func (r *Router) Callback(route Route) {
+	if _, ok := r.callbacks[route.Name]; ok {
+		panic("маршрут кнопки зарегистрирован повторно: " + route.Name)
+	}
+	r.callbacks[route.Name] = &route
+}
+
+// Commands - зарегистрированные команды в порядке регистрации
+func (r *Router) Commands() []Route {
+	commands := make([]Route, 0, len(r.order))
+	for _, route := range r.order {
+		commands = append(commands, *route)
+	}
+	return commands
+}
+
+// HandleCommand выполняет команду из сообщения; false, если такой команды нет
+func (r *Router) HandleCommand(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update) bool {
+	route, ok := r.commands[update.Message.Command()]
+	if !ok {
+		return false
+	}
+	r.serve(&Context{
+		Context: ctx,
+		Bot:     bot,
+		Update:  update,
+		Route:   route,
+		ChatID:  update.Message.Chat.ID,
+		Action:  route.Name,
+	}, route.Handler)
+	return true
+}
+
+// HandleCallback выполняет маршрут нажатой кнопки, неизвестные данные передаются NotFound
+func (r *Router) HandleCallback(ctx context.Context, bot *tgbotapi.BotAPI, update tgbotapi.Update) {
+	callback := update.CallbackQuery
+	if callback.Message == nil { //кнопка inline-сообщения без чата
+		bot.Request(tgbotapi.NewCallback(callback.ID, ""))
+		return
+	}
+	data := DecodeCallback(callback.Data)
+	routeCtx := &Context{
+		Context:   ctx,
+		Bot:       bot,
+		Update:    update,
+		ChatID:    callback.Message.Chat.ID,
+		MessageID: callback.Message.MessageID,
+		Callback:  callback,
+		Data:      data,
+		Action:    callback.Data,
+	}
+	route, ok := r.callbacks[data.Route]
+	if !ok {
+		if r.NotFound != nil {
+			r.serve(routeCtx, r.NotFound)
+		}
+		return
+	}
+	routeCtx.Route = route
+	r.serve(routeCtx, route.Handler)
+}
+
+func (r *Router) serve(ctx *Context, handler HandlerFunc) {
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	handler(ctx)
+}
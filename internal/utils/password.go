@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MinPasswordLength = 8
+	maxPasswordBytes  = 72 // bcrypt учитывает только первые 72 байта
+)
+
+// CheckPasswordStrength - минимальные требования к паролю, текст ошибки показывается пользователю
+func CheckPasswordStrength(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("пароль должен быть не короче %d символов", MinPasswordLength)
+	}
+	if len(password) > maxPasswordBytes {
+		return fmt.Errorf("пароль длиннее %d байт", maxPasswordBytes)
+	}
+	if strings.TrimSpace(password) != password {
+		return fmt.Errorf("пароль не должен начинаться или заканчиваться пробелом")
+	}
+	var letter, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !letter || !digit {
+		return fmt.Errorf("пароль должен содержать буквы и цифры")
+	}
+	return nil
+}
+
+func GenerateNumericCode(digits int) (string, error) { //одноразовый код из цифр
+	max := big.NewInt(1)
+	for i := 0; i < digits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
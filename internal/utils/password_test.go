@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{name: "буквы и цифры", password: "secret42", ok: true},
+		{name: "кириллица", password: "пароль2024", ok: true},
+		{name: "пробел внутри", password: "my pass 1", ok: true},
+		{name: "короткий", password: "abc123", ok: false},
+		{name: "7 символов кириллицей, 14 байт", password: "пароль1", ok: false},
+		{name: "только буквы", password: "password", ok: false},
+		{name: "только цифры", password: "12345678", ok: false},
+		{name: "пробел в начале", password: " secret42", ok: false},
+		{name: "пробел в конце", password: "secret42 ", ok: false},
+		{name: "72 байта", password: strings.Repeat("a", 71) + "1", ok: true},
+		{name: "длиннее 72 байт", password: strings.Repeat("я", 36) + "1", ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckPasswordStrength(tt.password)
+			if (err == nil) != tt.ok {
+				t.Fatalf("CheckPasswordStrength(%q) = %v, ожидалось ok=%v", tt.password, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTOTPCode(t *testing.T) { //тестовый вектор RFC 6238 для SHA1, последние 6 цифр
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(secret, time.Unix(tt.unix, 0))
+		if err != nil || got != tt.want {
+			t.Errorf("TOTPCode(%d) = %s, %v; ожидалось %s", tt.unix, got, err, tt.want)
+		}
+	}
+	if _, err := TOTPCode("не base32", time.Now()); err == nil {
+		t.Error("TOTPCode с неверным секретом без ошибки")
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := func(shift time.Duration) string {
+		c, err := TOTPCode(secret, time.Now().Add(shift))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		ok     bool
+	}{
+		{name: "текущий код", secret: secret, code: code(0), ok: true},
+		{name: "пробелы вокруг", secret: secret, code: " " + code(0) + "\n", ok: true},
+		{name: "строчный секрет", secret: strings.ToLower(secret), code: code(0), ok: true},
+		{name: "предыдущий период", secret: secret, code: code(-totpPeriod * time.Second), ok: true},
+		{name: "код через 3 периода", secret: secret, code: code(3 * totpPeriod * time.Second), ok: false},
+		{name: "короткий код", secret: secret, code: code(0)[:5], ok: false},
+		{name: "пустой код", secret: secret, code: "", ok: false},
+		{name: "неверный секрет", secret: "не base32", code: "123456", ok: false},
+	}
+	for _, tt := range tests {
+		if got := CheckTOTP(tt.secret, tt.code); got != tt.ok {
+			t.Errorf("%s: CheckTOTP(%q) = %v, ожидалось %v", tt.name, tt.code, got, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// SecretHeader - заголовок, в котором Telegram передаёт secret_token вебхука
+const SecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+const updatesBuffer = 100 //как у GetUpdatesChan
+
+var secretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`) //допустимый secret_token по документации Telegram
+
+// Config - настройки приёма обновлений через вебхук
+type Config struct {
+	URL        string // публичный https адрес, который регистрируется в Telegram
+	Listen     string // адрес HTTP сервера, например :8443
+	Secret     string // secret_token, сверяется с заголовком каждого запроса
+	CertFile   string // сертификат и ключ TLS; пустые - TLS завершает обратный прокси
+	KeyFile    string
+	UploadCert bool // самоподписанный сертификат передаётся Telegram при регистрации
+}
+
+// Server принимает обновления от Telegram и отдаёт их в канал, как GetUpdatesChan
+type Server struct {
+	bot     *tgbotapi.BotAPI
+	cfg     Config
+	server  *http.Server
+	updates chan tgbotapi.Update
+	close   sync.Once
+
+	mu       sync.Mutex
+	stopped  bool
+	stop     chan struct{}  // закрывается при остановке: зависшие запросы перестают ждать обработчиков
+	inflight sync.WaitGroup // запросы, которые могут писать в updates
+}
+
+func NewServer(bot *tgbotapi.BotAPI, cfg Config) (*Server, error) {
+	webhookURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("неверный адрес вебхука: %w", err)
+	}
+	if webhookURL.Scheme != "https" {
+		return nil, errors.New("Telegram принимает только https адрес вебхука")
+	}
+	if !secretPattern.MatchString(cfg.Secret) {
+		return nil, errors.New("секрет вебхука: 1-256 символов A-Z, a-z, 0-9, _ и -")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("для TLS нужны и сертификат, и ключ")
+	}
+	if cfg.UploadCert && cfg.CertFile == "" {
+		return nil, errors.New("не указан сертификат для передачи Telegram")
+	}
+	path := webhookURL.Path //прокси может переписывать путь, но по умолчанию он совпадает с публичным
+	if path == "" {
+		path = "/"
+	}
+
+	s := &Server{
+		bot:     bot,
+		cfg:     cfg,
+		updates: make(chan tgbotapi.Update, updatesBuffer),
+		stop:    make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, s)
+	s.server = &http.Server{Addr: cfg.Listen, Handler: mux}
+	return s, nil
+}
+
+func (s *Server) Updates() tgbotapi.UpdatesChannel {
+	return s.updates
+}
+
+// Start открывает порт и регистрирует вебхук; ошибки после запуска сервера только логируются
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	go func() {
+		var err error
+		if s.cfg.CertFile != "" {
+			err = s.server.ServeTLS(listener, s.cfg.CertFile, s.cfg.KeyFile)
+		} else {
+			err = s.server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("Ошибка сервера вебхука: %v", err)
+		}
+	}()
+
+	if err := s.register(); err != nil {
+		s.server.Close()
+		return fmt.Errorf("ошибка регистрации вебхука: %w", err)
+	}
+	log.Printf("Вебхук зарегистрирован: %s, сервер слушает %s", s.cfg.URL, s.cfg.Listen)
+	return nil
+}
+
+func (s *Server) register() error { //WebhookConfig библиотеки не умеет secret_token, поэтому запрос собирается вручную
+	params := tgbotapi.Params{"url": s.cfg.URL}
+	params.AddNonEmpty("secret_token", s.cfg.Secret)
+	if s.cfg.UploadCert {
+		files := []tgbotapi.RequestFile{{Name: "certificate", Data: tgbotapi.FilePath(s.cfg.CertFile)}}
+		_, err := s.bot.UploadFiles("setWebhook", params, files)
+		return err
+	}
+	_, err := s.bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+// Shutdown снимает вебхук, дожидается принятых запросов и закрывает канал обновлений.
+// Если ctx истёк раньше, оставшиеся запросы получают 503, их обновления Telegram пришлёт после следующего запуска
+func (s *Server) Shutdown(ctx context.Context) error {
+	if _, err := s.bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		log.Printf("Ошибка удаления вебхука: %v", err)
+	}
+	err := s.server.Shutdown(ctx)
+	s.close.Do(func() {
+		s.mu.Lock()
+		s.stopped = true
+		close(s.stop)
+		s.mu.Unlock()
+		s.inflight.Wait() //канал закрывается, только когда в него никто не пишет
+		close(s.updates)
+	})
+	return err
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	secret := r.Header.Get(SecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.cfg.Secret)) != 1 {
+		log.Printf("Запрос вебхука с неверным секретом от %s", r.RemoteAddr)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	update, err := s.bot.HandleUpdate(r)
+	if err != nil {
+		log.Printf("Ошибка разбора обновления вебхука: %v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	s.inflight.Add(1)
+	s.mu.Unlock()
+	defer s.inflight.Done()
+
+	select {
+	case s.updates <- *update:
+		w.WriteHeader(http.StatusOK)
+	case <-s.stop: //бот останавливается, Telegram повторит обновление
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case <-r.Context().Done(): //Telegram не дождался ответа и повторит обновление
+		http.Error(w, "timeout", http.StatusServiceUnavailable)
+	}
+}
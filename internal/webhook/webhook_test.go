@@ -0,0 +1,75 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testSecret = "s3cret_token-1"
+
+func newTestServer(t *testing.T) *Server {
+	s, err := NewServer(&tgbotapi.BotAPI{}, Config{URL: "https://example.com/hook", Listen: ":0", Secret: testSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServeHTTPChecksSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		secret string
+		set    bool
+		status int
+		queued bool
+	}{
+		{name: "верный секрет", method: http.MethodPost, secret: testSecret, set: true, status: http.StatusOK, queued: true},
+		{name: "без заголовка", method: http.MethodPost, status: http.StatusForbidden},
+		{name: "пустой секрет", method: http.MethodPost, secret: "", set: true, status: http.StatusForbidden},
+		{name: "неверный секрет", method: http.MethodPost, secret: "wrong", set: true, status: http.StatusForbidden},
+		{name: "префикс секрета", method: http.MethodPost, secret: testSecret[:5], set: true, status: http.StatusForbidden},
+		{name: "секрет с лишним символом", method: http.MethodPost, secret: testSecret + "x", set: true, status: http.StatusForbidden},
+		{name: "GET", method: http.MethodGet, secret: testSecret, set: true, status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			req := httptest.NewRequest(tt.method, "/hook", strings.NewReader(`{"update_id": 7}`))
+			if tt.set {
+				req.Header.Set(SecretHeader, tt.secret)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("статус %d, ожидался %d", rec.Code, tt.status)
+			}
+			select {
+			case update := <-s.updates:
+				if !tt.queued {
+					t.Fatalf("обновление %d принято без верного секрета", update.UpdateID)
+				}
+				if update.UpdateID != 7 {
+					t.Fatalf("UpdateID = %d, ожидался 7", update.UpdateID)
+				}
+			default:
+				if tt.queued {
+					t.Fatal("обновление не попало в канал")
+				}
+			}
+		})
+	}
+}
+
+func TestNewServerRejectsInvalidSecret(t *testing.T) {
+	for _, secret := range []string{"", "with space", "кириллица", strings.Repeat("a", 257)} {
+		if _, err := NewServer(&tgbotapi.BotAPI{}, Config{URL: "https://example.com/hook", Secret: secret}); err == nil {
+			t.Errorf("NewServer принял секрет %q", secret)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS product_nutrition (
+    product_id INTEGER PRIMARY KEY REFERENCES products(id) ON DELETE CASCADE,
+    serving_size DECIMAL(10,2) NOT NULL,
+    protein DECIMAL(10,2) DEFAULT 0,
+    carbs DECIMAL(10,2) DEFAULT 0,
+    fat DECIMAL(10,2) DEFAULT 0,
+    calories DECIMAL(10,2) DEFAULT 0
+);
@@ -0,0 +1,16 @@
+ALTER TABLE products ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP;
+
+-- снимки товара в позициях заказа: история не зависит от дальнейших изменений каталога
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS product_name VARCHAR(200);
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS flavor VARCHAR(100);
+
+UPDATE order_items
+SET product_name = products.name, flavor = products.flavor
+FROM products
+WHERE order_items.product_id = products.id AND order_items.product_name IS NULL;
+
+-- товар с историей заказов нельзя удалить физически, только архивировать
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_product_id_fkey;
+ALTER TABLE order_items ADD CONSTRAINT order_items_product_id_fkey
+    FOREIGN KEY (product_id) REFERENCES products(id) ON DELETE RESTRICT;
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS price_history (
+    id SERIAL PRIMARY KEY,
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    old_price DECIMAL(10,2) NOT NULL,
+    new_price DECIMAL(10,2) NOT NULL,
+    changed_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sales (
+    id SERIAL PRIMARY KEY,
+    target_type VARCHAR(20) NOT NULL CHECK (target_type IN ('product', 'category', 'brand')),
+    target VARCHAR(100) NOT NULL, -- ID товара или категории, название бренда
+    sale_price DECIMAL(10,2), -- фиксированная цена, только для товара
+    discount_percent DECIMAL(5,2), -- скидка в процентах
+    starts_at TIMESTAMP NOT NULL,
+    ends_at TIMESTAMP NOT NULL,
+    is_active BOOLEAN DEFAULT FALSE, -- выставляется фоновой задачей
+    created_at TIMESTAMP DEFAULT NOW(),
+    CHECK (ends_at > starts_at),
+    CHECK ((sale_price IS NULL) <> (discount_percent IS NULL))
+);
+
+-- текущая цена по распродаже, NULL если распродажи нет
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sale_price DECIMAL(10,2);
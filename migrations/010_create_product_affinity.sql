@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS product_affinity (
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    related_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    score INTEGER NOT NULL, -- сколько подтверждённых заказов содержат оба товара
+    updated_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (product_id, related_id)
+);
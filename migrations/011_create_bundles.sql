@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS bundles (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    description TEXT,
+    price DECIMAL(10,2) NOT NULL,
+    is_active BOOLEAN DEFAULT TRUE,
+    archived_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS bundle_items (
+    bundle_id INTEGER NOT NULL REFERENCES bundles(id) ON DELETE CASCADE,
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    PRIMARY KEY (bundle_id, product_id)
+);
+
+-- позиция заказа - либо товар, либо набор
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS bundle_id INTEGER REFERENCES bundles(id) ON DELETE RESTRICT;
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_product_or_bundle;
+ALTER TABLE order_items ADD CONSTRAINT order_items_product_or_bundle
+    CHECK ((product_id IS NULL) <> (bundle_id IS NULL));
+CREATE UNIQUE INDEX IF NOT EXISTS order_items_order_id_bundle_id_key ON order_items(order_id, bundle_id);
+
+-- состав набора на момент подтверждения заказа
+CREATE TABLE IF NOT EXISTS order_item_components (
+    order_item_id INTEGER NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+    product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE RESTRICT,
+    product_name VARCHAR(200),
+    flavor VARCHAR(100),
+    quantity INTEGER NOT NULL,
+    PRIMARY KEY (order_item_id, product_id)
+);
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS brands (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    description TEXT DEFAULT '',
+    country VARCHAR(100) DEFAULT '',
+    logo_url TEXT DEFAULT '',
+    is_active BOOLEAN DEFAULT TRUE,
+    archived_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+CREATE UNIQUE INDEX IF NOT EXISTS brands_name_key ON brands (LOWER(name));
+
+-- бренды из текстового поля товаров
+INSERT INTO brands (name)
+SELECT DISTINCT ON (LOWER(brand)) brand FROM products
+WHERE brand IS NOT NULL AND brand <> ''
+ON CONFLICT DO NOTHING;
+INSERT INTO brands (name) VALUES ('SportBrand') ON CONFLICT DO NOTHING;
+
+ALTER TABLE products ADD COLUMN IF NOT EXISTS brand_id INTEGER REFERENCES brands(id) ON DELETE RESTRICT;
+UPDATE products SET brand_id = brands.id
+FROM brands
+WHERE LOWER(products.brand) = LOWER(brands.name) AND products.brand_id IS NULL;
+CREATE INDEX IF NOT EXISTS products_brand_id_idx ON products (brand_id);
@@ -0,0 +1,4 @@
+-- удаление категории с товарами запрещено: товары сначала переносятся или архивируются
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_category_id_fkey;
+ALTER TABLE products ADD CONSTRAINT products_category_id_fkey
+    FOREIGN KEY (category_id) REFERENCES categories(id) ON DELETE RESTRICT;
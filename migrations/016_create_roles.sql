@@ -0,0 +1,56 @@
+-- роли и именованные разрешения вместо проверки role = 'admin'
+CREATE TABLE IF NOT EXISTS roles (
+name         VARCHAR(20) PRIMARY KEY,
+description  TEXT,
+priority     INT NOT NULL DEFAULT 0 -- основная роль пользователя - с наибольшим приоритетом
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+name         VARCHAR(50) PRIMARY KEY,
+description  TEXT
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+role         VARCHAR(20) REFERENCES roles(name) ON DELETE CASCADE,
+permission   VARCHAR(50) REFERENCES permissions(name) ON DELETE CASCADE,
+PRIMARY KEY (role, permission)
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+user_id      BIGINT REFERENCES users(id) ON DELETE CASCADE,
+role         VARCHAR(20) REFERENCES roles(name) ON DELETE CASCADE,
+granted_by   BIGINT REFERENCES users(id) ON DELETE SET NULL,
+granted_at   TIMESTAMP DEFAULT NOW(),
+PRIMARY KEY (user_id, role)
+);
+
+INSERT INTO permissions (name, description) VALUES
+('catalog.write', 'Товары, категории, бренды, наборы и распродажи'),
+('orders.manage', 'Просмотр и обработка заказов'),
+('users.manage', 'Пользователи и их сессии'),
+('reports.view', 'Отчёты и история цен'),
+('roles.manage', 'Назначение и снятие ролей')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (name, description, priority) VALUES
+('owner', 'Владелец магазина', 100),
+('admin', 'Администратор', 90),
+('manager', 'Менеджер', 50),
+('warehouse', 'Склад', 30),
+('support', 'Поддержка', 20)
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+('owner', 'catalog.write'), ('owner', 'orders.manage'), ('owner', 'users.manage'),
+('owner', 'reports.view'), ('owner', 'roles.manage'),
+('admin', 'catalog.write'), ('admin', 'orders.manage'), ('admin', 'users.manage'),
+('admin', 'reports.view'), ('admin', 'roles.manage'),
+('manager', 'catalog.write'), ('manager', 'orders.manage'), ('manager', 'reports.view'),
+('warehouse', 'catalog.write'), ('warehouse', 'orders.manage'),
+('support', 'orders.manage'), ('support', 'users.manage')
+ON CONFLICT DO NOTHING;
+
+-- существующие администраторы получают роль admin
+INSERT INTO user_roles (user_id, role)
+SELECT id, role FROM users WHERE role IN (SELECT name FROM roles)
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,14 @@
+-- журнал попыток входа по паролю: по нему считаются задержки и блокировки
+CREATE TABLE IF NOT EXISTS login_attempts (
+id           BIGSERIAL PRIMARY KEY,
+telegram_id  BIGINT NOT NULL, -- аккаунт, в который пытались войти
+user_id      BIGINT REFERENCES users(id) ON DELETE SET NULL,
+chat_id      BIGINT NOT NULL, -- чат, из которого была попытка
+from_id      BIGINT NOT NULL, -- Telegram ID отправителя
+success      BOOLEAN NOT NULL,
+reason       VARCHAR(20) NOT NULL, -- ok, password, totp, not_found, blocked
+created_at   TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_account_idx ON login_attempts (telegram_id, created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_chat_idx ON login_attempts (chat_id, created_at);
@@ -0,0 +1,13 @@
+-- одноразовые коды восстановления пароля, код отправляется в Telegram владельца аккаунта
+CREATE TABLE IF NOT EXISTS password_resets (
+id           BIGSERIAL PRIMARY KEY,
+user_id      BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+code_hash    VARCHAR(255) NOT NULL,
+attempts     INT NOT NULL DEFAULT 0, -- неверные вводы кода
+requested_in BIGINT NOT NULL, -- чат, из которого запросили код
+created_at   TIMESTAMP DEFAULT NOW(),
+expires_at   TIMESTAMP NOT NULL,
+used_at      TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_idx ON password_resets (user_id, created_at);
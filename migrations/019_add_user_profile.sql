@@ -0,0 +1,5 @@
+-- профиль покупателя: адрес доставки, подтверждённый телефон и настройки уведомлений
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delivery_address TEXT DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_verified BOOLEAN DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_restock BOOLEAN DEFAULT TRUE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS notify_orders BOOLEAN DEFAULT TRUE;
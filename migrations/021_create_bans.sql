@@ -0,0 +1,10 @@
+-- блокировки по Telegram ID: переживают удаление и повторную регистрацию аккаунта
+CREATE TABLE IF NOT EXISTS bans (
+telegram_id  BIGINT PRIMARY KEY,
+user_id      BIGINT REFERENCES users(id) ON DELETE SET NULL,
+reason       TEXT NOT NULL DEFAULT '',
+blocked_by   BIGINT REFERENCES users(id) ON DELETE SET NULL,
+blocked_at   TIMESTAMP DEFAULT NOW(),
+expires_at   TIMESTAMP, -- NULL - бессрочно
+notified     BOOLEAN NOT NULL DEFAULT FALSE -- пользователю отправлено сообщение о блокировке
+);
@@ -0,0 +1,38 @@
+-- реферальная программа и настройки магазина, которые меняют администраторы
+CREATE TABLE IF NOT EXISTS settings (
+key          VARCHAR(50) PRIMARY KEY,
+value        TEXT NOT NULL,
+description  TEXT,
+updated_at   TIMESTAMP DEFAULT NOW()
+);
+
+INSERT INTO settings (key, value, description) VALUES
+('referral_referrer_bonus', '200', 'Бонус пригласившему за первый доставленный заказ друга'),
+('referral_referee_bonus', '100', 'Бонус приглашённому за первый доставленный заказ'),
+('referral_min_order', '500', 'Минимальная сумма заказа для реферального бонуса')
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+('settings.manage', 'Настройки магазина и бонусов')
+ON CONFLICT (name) DO NOTHING;
+INSERT INTO role_permissions (role, permission) VALUES
+('owner', 'settings.manage'), ('admin', 'settings.manage')
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code VARCHAR(16) UNIQUE;
+
+CREATE TABLE IF NOT EXISTS referrals (
+id                   BIGSERIAL PRIMARY KEY,
+referrer_id          BIGINT NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+referee_id           BIGINT NOT NULL UNIQUE REFERENCES users(id) ON DELETE RESTRICT,
+referee_telegram_id  BIGINT NOT NULL UNIQUE, -- Telegram приглашается один раз, даже после удаления аккаунта
+status               VARCHAR(20) NOT NULL DEFAULT 'pending', -- pending, rewarded, rejected
+order_id             INTEGER REFERENCES orders(id) ON DELETE SET NULL, -- заказ, за который начислен бонус
+referrer_bonus       DECIMAL(10,2) NOT NULL DEFAULT 0,
+referee_bonus        DECIMAL(10,2) NOT NULL DEFAULT 0,
+created_at           TIMESTAMP DEFAULT NOW(),
+rewarded_at          TIMESTAMP,
+CHECK (referrer_id <> referee_id)
+);
+
+CREATE INDEX IF NOT EXISTS referrals_referrer_idx ON referrals (referrer_id, status);
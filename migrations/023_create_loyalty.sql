@@ -0,0 +1,34 @@
+-- бонусные баллы: журнал начислений и списаний, 1 балл = 1 рубль скидки
+CREATE TABLE IF NOT EXISTS loyalty_transactions (
+id          BIGSERIAL PRIMARY KEY,
+user_id     BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+amount      DECIMAL(10,2) NOT NULL, -- начисление > 0, списание < 0
+remaining   DECIMAL(10,2) NOT NULL DEFAULT 0, -- неизрасходованный остаток начисления
+kind        VARCHAR(20) NOT NULL, -- accrual, referral, refund, adjust, redeem, expire
+order_id    INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+reason      TEXT,
+created_by  BIGINT REFERENCES users(id) ON DELETE SET NULL, -- сотрудник при ручной корректировке
+created_at  TIMESTAMP DEFAULT NOW(),
+expires_at  TIMESTAMP, -- для начислений
+CHECK (remaining >= 0 AND remaining <= GREATEST(amount, 0))
+);
+
+CREATE INDEX IF NOT EXISTS loyalty_transactions_user_idx ON loyalty_transactions (user_id, created_at);
+CREATE INDEX IF NOT EXISTS loyalty_transactions_expires_idx ON loyalty_transactions (expires_at) WHERE remaining > 0;
+-- за один заказ баллы начисляются один раз
+CREATE UNIQUE INDEX IF NOT EXISTS loyalty_transactions_accrual_idx ON loyalty_transactions (order_id) WHERE kind = 'accrual';
+
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS points_used DECIMAL(10,2) NOT NULL DEFAULT 0;
+
+INSERT INTO settings (key, value, description) VALUES
+('loyalty_accrual_percent', '5', 'Процент от доставленного заказа, начисляемый баллами'),
+('loyalty_redeem_max_percent', '30', 'Какую часть заказа в процентах можно оплатить баллами'),
+('loyalty_expiry_days', '180', 'Через сколько дней сгорают начисленные баллы')
+ON CONFLICT (key) DO NOTHING;
+
+INSERT INTO permissions (name, description) VALUES
+('loyalty.manage', 'Ручная корректировка бонусных баллов')
+ON CONFLICT (name) DO NOTHING;
+INSERT INTO role_permissions (role, permission) VALUES
+('owner', 'loyalty.manage'), ('admin', 'loyalty.manage')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,21 @@
+-- журнал действий сотрудников: кто, что и с какой сущностью сделал, изменённые поля
+CREATE TABLE IF NOT EXISTS audit_log (
+id          BIGSERIAL PRIMARY KEY,
+actor_id    BIGINT REFERENCES users(id) ON DELETE SET NULL,
+action      VARCHAR(50) NOT NULL, -- команда: update_product, ban и т.д.
+entity      VARCHAR(30) NOT NULL, -- product, category, user, order...
+entity_id   VARCHAR(64) NOT NULL,
+changes     JSONB NOT NULL DEFAULT '{}', -- {"поле": {"old": ..., "new": ...}}
+created_at  TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON audit_log (entity, entity_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON audit_log (actor_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS audit_log_created_idx ON audit_log (created_at DESC);
+
+INSERT INTO permissions (name, description) VALUES
+('audit.view', 'Просмотр журнала действий сотрудников')
+ON CONFLICT (name) DO NOTHING;
+INSERT INTO role_permissions (role, permission) VALUES
+('owner', 'audit.view'), ('admin', 'audit.view')
+ON CONFLICT DO NOTHING;
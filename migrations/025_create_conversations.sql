@@ -0,0 +1,10 @@
+-- состояние многошагового диалога в чате: переживает перезапуск бота
+CREATE TABLE IF NOT EXISTS conversations (
+chat_id     BIGINT PRIMARY KEY,
+state       VARCHAR(50) NOT NULL, -- search_product, confirm, shopping...
+payload     JSONB NOT NULL DEFAULT '{}', -- данные состояния: выбранный товар, удаляемый объект и т.д.
+expires_at  TIMESTAMP NOT NULL,
+updated_at  TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS conversations_expires_idx ON conversations (expires_at);
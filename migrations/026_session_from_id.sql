@@ -0,0 +1,8 @@
+-- сессия принадлежит отправителю в чате: в группе участники не наследуют чужой вход
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS from_id BIGINT;
+
+-- у старых сессий отправитель неизвестен, после обновления нужно войти заново
+UPDATE sessions SET revoked_at = NOW() WHERE from_id IS NULL AND revoked_at IS NULL;
+
+DROP INDEX IF EXISTS sessions_chat_id_idx;
+CREATE INDEX IF NOT EXISTS sessions_chat_from_idx ON sessions (chat_id, from_id) WHERE revoked_at IS NULL;
@@ -0,0 +1,4 @@
+-- распродажа бренда хранит его ID, как распродажа категории: переименование бренда её не ломает
+UPDATE sales SET target = brands.id::text
+FROM brands
+WHERE sales.target_type = 'brand' AND LOWER(sales.target) = LOWER(brands.name);
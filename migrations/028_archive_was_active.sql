@@ -0,0 +1,4 @@
+-- видимость до архивации: восстановление возвращает её, а не включает скрытое. NULL - архивировано раньше, считается видимым
+ALTER TABLE products ADD COLUMN IF NOT EXISTS was_active BOOLEAN;
+ALTER TABLE categories ADD COLUMN IF NOT EXISTS was_active BOOLEAN;
+ALTER TABLE brands ADD COLUMN IF NOT EXISTS was_active BOOLEAN;
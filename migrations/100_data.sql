@@ -93,3 +93,13 @@ UPDATE orders SET amount = (
 ) WHERE id = 10;
 
 
+
+-- ссылка товаров на бренд по названию
+UPDATE products SET brand_id = brands.id
+FROM brands
+WHERE LOWER(products.brand) = LOWER(brands.name) AND products.brand_id IS NULL;
+
+-- Роли пользователей
+INSERT INTO user_roles (user_id, role)
+SELECT id, role FROM users WHERE role IN (SELECT name FROM roles)
+ON CONFLICT DO NOTHING;
@@ -33,6 +33,30 @@ func main() {
 		"002_create_products.sql",
 		"003_create_users.sql",
 		"004_create_orders.sql",
+		"005_create_stock_subscriptions.sql",
+		"006_add_product_images.sql",
+		"007_create_product_nutrition.sql",
+		"008_archive_products.sql",
+		"009_create_price_history_and_sales.sql",
+		"010_create_product_affinity.sql",
+		"011_create_bundles.sql",
+		"012_create_brands.sql",
+		"013_restrict_category_delete.sql",
+		"014_create_sessions.sql",
+		"015_add_user_totp.sql",
+		"016_create_roles.sql",
+		"017_create_login_attempts.sql",
+		"018_create_password_resets.sql",
+		"019_add_user_profile.sql",
+		"020_anonymize_users.sql",
+		"021_create_bans.sql",
+		"022_create_referrals.sql",
+		"023_create_loyalty.sql",
+		"024_create_audit_log.sql",
+		"025_create_conversations.sql",
+		"026_session_from_id.sql",
+		"027_brand_sales_by_id.sql",
+		"028_archive_was_active.sql",
 		"100_data.sql",
 	}
 